@@ -0,0 +1,118 @@
+// archive.go
+//
+// The archive command, which keeps the frame fresh by moving photos that have been
+// on it a long time into an archive folder the slideshow does not show. Archived
+// items are remembered in the manifest so that later syncs do not bring them back.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+)
+
+// runArchive moves files saved before -older-than, and any beyond the newest
+// -keep, into the archive folder.
+func runArchive(args []string) {
+	fs := flag.NewFlagSet("archive", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to archive photos from")
+	olderThanPtr := fs.String("older-than", "", "Archive files saved before this date: YYYY-MM-DD or an age such as 90d, 6m or 1y")
+	keepPtr := fs.Int("keep", 0, "Archive all but the N most recently saved files; 0 means no limit")
+	dirPtr := fs.String("archive-dir", ".archive", "Folder inside -folder to move archived files to; hidden by default so frames skip it")
+	dryRunPtr := fs.Bool("dry-run", false, "List what would be archived without moving anything")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+	if *olderThanPtr == "" && *keepPtr <= 0 {
+		log.Fatal("Specify -older-than, -keep or both.")
+	}
+	cutoff, err := parseDateBound(*olderThanPtr, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid -older-than: %v", err)
+	}
+	folder := *folderPtr
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+
+	// The time a file was saved is how long it has been on the frame
+	type candidate struct {
+		index int
+		saved time.Time
+	}
+	var onFrame []candidate
+	for i, entry := range m.Items {
+		if entry.Filename == "" || entry.Archived {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(folder, entry.Filename))
+		if err != nil {
+			continue
+		}
+		onFrame = append(onFrame, candidate{index: i, saved: info.ModTime()})
+	}
+	slices.SortStableFunc(onFrame, func(a, b candidate) int {
+		return b.saved.Compare(a.saved)
+	})
+
+	var toArchive []int
+	for rank, c := range onFrame {
+		if (*keepPtr > 0 && rank >= *keepPtr) || c.saved.Before(cutoff) {
+			toArchive = append(toArchive, c.index)
+		}
+	}
+	if len(toArchive) == 0 {
+		report("Nothing to archive.", "Nothing to archive")
+		return
+	}
+	if *dryRunPtr {
+		for _, i := range toArchive {
+			report("Would archive "+m.Items[i].Filename, "Would archive", "file", m.Items[i].Filename)
+		}
+		return
+	}
+
+	finishWrites := common.beginWrites()
+	defer finishWrites()
+	if err := os.MkdirAll(filepath.Join(folder, *dirPtr), 0o755); err != nil {
+		log.Fatalf("Unable to create archive folder: %v", err)
+	}
+	archived := 0
+	for _, i := range toArchive {
+		entry := &m.Items[i]
+		archivedName := filepath.Join(*dirPtr, entry.Filename)
+		if err := os.Rename(filepath.Join(folder, entry.Filename), filepath.Join(folder, archivedName)); err != nil {
+			report(fmt.Sprintf("Error archiving %s: %v", entry.Filename, err), "Error archiving", "file", entry.Filename, "err", err)
+			continue
+		}
+		report("Archived "+entry.Filename, "Archived", "file", entry.Filename)
+		entry.Filename, entry.Archived = archivedName, true
+		archived++
+	}
+	if err := m.Save(folder); err != nil {
+		log.Fatalf("Unable to save manifest: %v", err)
+	}
+	report(fmt.Sprintf("Archived %d of %d files on the frame.", archived, len(onFrame)),
+		"Archive finished", "archived", archived, "on_frame", len(onFrame))
+}
@@ -0,0 +1,241 @@
+// audit.go
+//
+// An opt-in log of every outbound connection, for users who want to check what
+// the frame host talks to. Each request is recorded with its time, host, purpose
+// and outcome, but never its query, nor the path of a download, which grants
+// access to the photo while the link lasts. The audit command shows the log.
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/transport"
+)
+
+// auditEnabled records outbound requests in the audit log.
+var auditEnabled = false
+
+// auditFileName is the audit log inside the state folder.
+const auditFileName = "audit.jsonl"
+
+// auditPath is where the audit log is kept.
+func auditPath() string {
+	return filepath.Join(stateDir, auditFileName)
+}
+
+// auditEntry is one outbound request.
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	Method  string    `json:"method"`
+	Host    string    `json:"host"`
+	Path    string    `json:"path,omitempty"`
+	Purpose string    `json:"purpose"`
+	Status  int       `json:"status,omitempty"`
+	Error   string    `json:"error,omitempty"`
+}
+
+// auditPurposes say why the app talks to each host, and for some hosts each path
+// prefix, most specific first. Paths are only recorded where they say nothing
+// about the user's photos.
+var auditPurposes = []struct {
+	host, path, purpose string
+	keepPath            bool
+}{
+	{"oauth2.googleapis.com", "/revoke", "revoking access", true},
+	{"oauth2.googleapis.com", "/device", "signing in with a code", true},
+	{"oauth2.googleapis.com", "", "signing in or refreshing access", true},
+	{"accounts.google.com", "", "signing in", true},
+	{"photospicker.googleapis.com", "/v1/sessions", "choosing photos", true},
+	{"photospicker.googleapis.com", "/v1/mediaItems", "listing chosen photos", true},
+	{"photospicker.googleapis.com", "", "checking the connection", true},
+	{"www.googleapis.com", "/oauth2/", "looking up the signed-in account", true},
+	{"openidconnect.googleapis.com", "", "looking up the signed-in account", true},
+	{".googleusercontent.com", "", "downloading a photo", false},
+	{"api.github.com", "", "checking for updates", true},
+	{"github.com", "", "downloading an update", false},
+	{".githubusercontent.com", "", "downloading an update", false},
+}
+
+// auditPurpose returns why req was made and whether its path may be recorded.
+func auditPurpose(req *http.Request) (string, bool) {
+	host := strings.ToLower(req.URL.Hostname())
+	for _, p := range auditPurposes {
+		matches := host == p.host || strings.HasPrefix(p.host, ".") && strings.HasSuffix(host, p.host)
+		if matches && strings.HasPrefix(req.URL.Path, p.path) {
+			return p.purpose, p.keepPath
+		}
+	}
+	return "other", false
+}
+
+// auditMu keeps concurrent requests' entries from interleaving.
+var auditMu sync.Mutex
+
+// recordAudit appends entry to the audit log. Failures are logged, not returned,
+// since the request itself has already been made.
+func recordAudit(entry auditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	f, err := os.OpenFile(auditPath(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Printf("Unable to write the audit log: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Unable to write the audit log: %v", err)
+	}
+}
+
+// auditRequests records every request in the audit log.
+func auditRequests() transport.Middleware {
+	return func(next transport.Doer) transport.Doer {
+		return transport.DoerFunc(func(req *http.Request) (*http.Response, error) {
+			purpose, keepPath := auditPurpose(req)
+			entry := auditEntry{Time: time.Now(), Method: req.Method, Host: req.URL.Host, Purpose: purpose}
+			if keepPath {
+				entry.Path = req.URL.Path
+			}
+			resp, err := next.Do(req)
+			if err != nil {
+				entry.Error = err.Error()
+			} else {
+				entry.Status = resp.StatusCode
+			}
+			recordAudit(entry)
+			return resp, err
+		})
+	}
+}
+
+// readAudit reads the audit log, oldest first.
+func readAudit(path string) ([]auditEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []auditEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry auditEntry
+		// A line cut short by a crash is skipped rather than failing the rest
+		if json.Unmarshal(scanner.Bytes(), &entry) == nil {
+			entries = append(entries, entry)
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// runAudit dispatches the audit subcommands.
+func runAudit(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		log.Fatal("Specify what to do with the audit log: audit show or audit clear")
+	}
+	switch args[0] {
+	case "show":
+		runAuditShow(args[1:])
+	case "clear":
+		runAuditClear(args[1:])
+	default:
+		log.Fatalf("Unknown audit command %q. Available commands: show, clear", args[0])
+	}
+}
+
+// runAuditShow prints the outbound requests recorded, or a summary of the hosts
+// contacted.
+func runAuditShow(args []string) {
+	fs := flag.NewFlagSet("audit show", flag.ExitOnError)
+	sincePtr := fs.String("since", "", "Only show requests made since this date: YYYY-MM-DD or an age such as 7d")
+	summaryPtr := fs.Bool("summary", false, "Show each host and purpose once, with how many requests were made and when")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	since, err := parseDateBound(*sincePtr, time.Now())
+	if err != nil {
+		log.Fatalf("Invalid -since: %v", err)
+	}
+	entries, err := readAudit(auditPath())
+	if errors.Is(err, os.ErrNotExist) {
+		report("Nothing recorded yet; run commands with -audit to record their outbound requests.", "Audit log empty")
+		return
+	}
+	if err != nil {
+		log.Fatalf("Unable to read the audit log: %v", err)
+	}
+	entries = slices.DeleteFunc(entries, func(e auditEntry) bool { return e.Time.Before(since) })
+
+	if !*summaryPtr {
+		for _, e := range entries {
+			outcome := e.Error
+			if outcome == "" {
+				outcome = fmt.Sprint(e.Status)
+			}
+			report(fmt.Sprintf("%s  %-6s %s%s  %s  %s", e.Time.Local().Format(time.DateTime), e.Method, e.Host, e.Path, e.Purpose, outcome),
+				"Outbound request", "time", e.Time, "method", e.Method, "host", e.Host, "path", e.Path, "purpose", e.Purpose, "status", e.Status, "err", e.Error)
+		}
+		return
+	}
+
+	type contact struct {
+		host, purpose string
+		count         int
+		first, last   time.Time
+	}
+	var contacts []*contact
+	byKey := make(map[[2]string]*contact)
+	for _, e := range entries {
+		key := [2]string{e.Host, e.Purpose}
+		c, ok := byKey[key]
+		if !ok {
+			c = &contact{host: e.Host, purpose: e.Purpose, first: e.Time}
+			byKey[key] = c
+			contacts = append(contacts, c)
+		}
+		c.count++
+		c.last = e.Time
+	}
+	slices.SortFunc(contacts, func(a, b *contact) int {
+		return cmp.Or(strings.Compare(a.host, b.host), strings.Compare(a.purpose, b.purpose))
+	})
+	for _, c := range contacts {
+		report(fmt.Sprintf("%-36s %-34s %6d requests, %s to %s", c.host, c.purpose, c.count,
+			c.first.Local().Format(time.DateOnly), c.last.Local().Format(time.DateOnly)),
+			"Host contacted", "host", c.host, "purpose", c.purpose, "requests", c.count, "first", c.first, "last", c.last)
+	}
+}
+
+// runAuditClear deletes the audit log.
+func runAuditClear(args []string) {
+	fs := flag.NewFlagSet("audit clear", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if err := os.Remove(auditPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Fatalf("Unable to clear the audit log: %v", err)
+	}
+	report("Audit log cleared.", "Audit log cleared")
+}
@@ -0,0 +1,159 @@
+// bursts.go
+//
+// The bursts command, which finds bursts of near-identical shots and keeps only the
+// best of each on the frame.
+package main
+
+import (
+	"cmp"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/quality"
+)
+
+// burstShot is a photo that may belong to a burst.
+type burstShot struct {
+	index    int
+	created  time.Time
+	analysis quality.Analysis
+}
+
+// runBursts groups photos taken within -window of each other that look alike,
+// keeps the sharpest, best exposed one of each group and moves the others into
+// the extras folder, marking them archived so syncs leave them there.
+func runBursts(args []string) {
+	fs := flag.NewFlagSet("bursts", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to look for bursts in")
+	windowPtr := fs.Duration("window", 2*time.Second, "Most time between consecutive shots of a burst")
+	distancePtr := fs.Int("max-distance", 12, "Most bits, out of 64, in which the image hashes of two shots of a burst may differ")
+	extrasPtr := fs.String("extras-dir", ".extras", "Folder inside -folder to move the rest of each burst to")
+	dryRunPtr := fs.Bool("dry-run", false, "List the bursts found without moving anything")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+	if err := refuseInLowMemory("bursts"); err != nil {
+		log.Fatal(err)
+	}
+	folder := *folderPtr
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+	var shots []burstShot
+	for i, entry := range m.Items {
+		if entry.Filename == "" || entry.Archived || entry.Local() || !decodableExtensions[strings.ToLower(filepath.Ext(entry.Filename))] {
+			continue
+		}
+		created, err := time.Parse(time.RFC3339, entry.CreateTime)
+		if err != nil {
+			continue
+		}
+		shots = append(shots, burstShot{index: i, created: created})
+	}
+	slices.SortFunc(shots, func(a, b burstShot) int { return a.created.Compare(b.created) })
+
+	// Only shots with a neighbour inside the window can be in a burst, so only
+	// they are worth decoding
+	var analysed []burstShot
+	for i, shot := range shots {
+		near := (i > 0 && shot.created.Sub(shots[i-1].created) <= *windowPtr) ||
+			(i < len(shots)-1 && shots[i+1].created.Sub(shot.created) <= *windowPtr)
+		if !near {
+			continue
+		}
+		analysis, err := quality.Analyze(filepath.Join(folder, m.Items[shot.index].Filename))
+		if err != nil {
+			log.Printf("Unable to analyse %s: %v", m.Items[shot.index].Filename, err)
+			continue
+		}
+		shot.analysis = analysis
+		analysed = append(analysed, shot)
+	}
+
+	var bursts [][]burstShot
+	var current []burstShot
+	for _, shot := range analysed {
+		if len(current) > 0 {
+			last := current[len(current)-1]
+			if shot.created.Sub(last.created) > *windowPtr || quality.Distance(shot.analysis.Hash, last.analysis.Hash) > *distancePtr {
+				if len(current) > 1 {
+					bursts = append(bursts, current)
+				}
+				current = nil
+			}
+		}
+		current = append(current, shot)
+	}
+	if len(current) > 1 {
+		bursts = append(bursts, current)
+	}
+	if len(bursts) == 0 {
+		report("No bursts found.", "No bursts found")
+		return
+	}
+
+	var finishWrites func()
+	moved := 0
+	for _, burst := range bursts {
+		best := slices.MaxFunc(burst, func(a, b burstShot) int {
+			return cmp.Compare(a.analysis.Score(), b.analysis.Score())
+		})
+		report(fmt.Sprintf("Burst of %d, keeping %s", len(burst), m.Items[best.index].Filename),
+			"Burst found", "shots", len(burst), "keep", m.Items[best.index].Filename)
+		for _, shot := range burst {
+			if shot.index == best.index {
+				continue
+			}
+			entry := &m.Items[shot.index]
+			if *dryRunPtr {
+				report("  would move "+entry.Filename, "Would move extra shot", "file", entry.Filename)
+				continue
+			}
+			if finishWrites == nil {
+				finishWrites = common.beginWrites()
+				defer finishWrites()
+				if err := os.MkdirAll(filepath.Join(folder, *extrasPtr), 0o755); err != nil {
+					log.Fatalf("Unable to create %s: %v", *extrasPtr, err)
+				}
+			}
+			extra := filepath.Join(*extrasPtr, entry.Filename)
+			if err := os.Rename(filepath.Join(folder, entry.Filename), filepath.Join(folder, extra)); err != nil {
+				report(fmt.Sprintf("  error moving %s: %v", entry.Filename, err), "Error moving extra shot", "file", entry.Filename, "err", err)
+				continue
+			}
+			report("  moved "+entry.Filename, "Moved extra shot", "file", entry.Filename)
+			entry.Filename, entry.Archived = extra, true
+			moved++
+		}
+	}
+	if *dryRunPtr {
+		return
+	}
+	if err := m.Save(folder); err != nil {
+		log.Fatalf("Unable to save manifest: %v", err)
+	}
+	report(fmt.Sprintf("Found %d bursts, moved %d extra shots to %s.", len(bursts), moved, *extrasPtr),
+		"Bursts finished", "bursts", len(bursts), "moved", moved, "folder", *extrasPtr)
+}
@@ -0,0 +1,343 @@
+// cli.go
+//
+// Command line plumbing shared by the sync, pick, download and import commands.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/auth"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/retry"
+)
+
+// stateDir holds credentials.json and token.json. Containers point it at a mounted volume.
+var stateDir = "."
+
+// authFlow selects how a new OAuth token is obtained: "web" runs a local callback
+// server, "device" uses the device authorization flow and needs no inbound connection.
+var authFlow = auth.FlowWeb
+
+// callbackAddr is the address the OAuth callback server listens on for the web flow.
+var callbackAddr = ":8080"
+
+// callbackListener is bound before privileges are dropped so that the web flow can
+// use a low port. When nil the callback server binds callbackAddr itself.
+var callbackListener net.Listener
+
+// controlRetry governs retries of session creation and token exchange.
+var controlRetry = retry.DefaultPolicy
+
+// redirectURL overrides the redirect URL from credentials.json, e.g. when the
+// callback server is reached through a forwarded port or reverse proxy.
+var redirectURL = ""
+
+// reauth discards the saved token so that the user signs in and consents again.
+var reauth = false
+
+// extraScopes are requested on top of auth.DefaultScopes, for features that need them.
+var extraScopes stringList
+
+const containerPhotosDir = "/photos"
+
+const containerStateDir = "/state"
+
+// commonFlags holds the options shared by every command.
+type commonFlags struct {
+	requestTimeout    time.Duration
+	slowCallThreshold time.Duration
+	listPageSize      int
+
+	lockWait    time.Duration
+	container   bool
+	runAs       string
+	memoryLimit string
+
+	remountReadOnly string
+}
+
+// registerCommonFlags adds the shared options to fs.
+func registerCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{}
+	fs.DurationVar(&c.requestTimeout, "request-timeout", 30*time.Second, "Maximum time to wait for each Picker API call")
+	fs.DurationVar(&c.slowCallThreshold, "slow-call-warning", 5*time.Second, "Log a warning when a Picker API call takes longer than this")
+	fs.IntVar(&c.listPageSize, "list-page-size", 0, "Media items to ask for in each page when listing a selection, up to 100 (default 100, or 25 with -low-memory)")
+	fs.IntVar(&controlRetry.Attempts, "control-retries", controlRetry.Attempts, "Maximum attempts for session creation and token exchange")
+	fs.DurationVar(&c.lockWait, "lock-wait", 0, "How long to wait for another run using the same folder to finish before exiting")
+	fs.StringVar(&stateDir, "state-dir", stateDir, "Folder holding credentials.json and token.json")
+	fs.StringVar(&credentialsFile, "credentials", credentialsFile, "OAuth client credentials file (default $"+googleCredentialsEnv+" or credentials.json in the state folder)")
+	fs.StringVar(&configFile, "config", configFile, "Profiles config file (default photosync.json in the state folder)")
+	fs.StringVar(&profileName, "profile", profileName, "Profile to use from the config file")
+	fs.StringVar(&authFlow, "auth-flow", authFlow, "How to obtain a new OAuth token: web or device")
+	fs.StringVar(&callbackAddr, "callback-addr", callbackAddr, "Listen address for the OAuth callback server")
+	fs.StringVar(&redirectURL, "redirect-url", redirectURL, "OAuth redirect URL, if different from the one in credentials.json")
+	fs.BoolVar(&reauth, "reauth", reauth, "Delete the saved token and sign in again, e.g. after a permissions error")
+	fs.Var(&extraScopes, "scope", "Extra OAuth scope to request on top of the Photos Picker scope; may be repeated")
+	fs.BoolVar(&c.container, "container", false, "Use container defaults: photos in /photos, state in /state and device flow auth")
+	fs.StringVar(&c.runAs, "run-as", "", "When started as root, drop to this user[:group] after binding the callback port")
+	fs.BoolVar(&lowMemory, "low-memory", lowMemory, "Reduce memory use for devices with 512MB of RAM or less: one download at a time, and no features that decode whole photos")
+	fs.StringVar(&c.memoryLimit, "memory-limit", "", "Soft memory limit for the Go runtime, e.g. 256MiB (overrides GOMEMLIMIT)")
+	fs.BoolVar(&plainOutput, "plain", plainOutput, "Print stable key=value lines instead of human-oriented output, for scripts and log collectors")
+	fs.BoolVar(&jsonOutput, "json", jsonOutput, "Print one JSON object per line instead of human-oriented output, for programs and home automation")
+	fs.StringVar(&proxyURL, "proxy", proxyURL, "Proxy for all outbound connections, e.g. http://proxy:3128 or socks5://proxy:1080 (default $HTTPS_PROXY); hosts in $NO_PROXY bypass it")
+	fs.StringVar(&caCertFile, "ca-cert", caCertFile, "PEM file of extra certificate authorities to trust, e.g. an SSL-inspecting proxy's")
+	fs.StringVar(&tlsMinVersion, "tls-min", tlsMinVersion, "Oldest TLS version to accept for outbound connections: 1.2 or 1.3")
+	fs.BoolVar(&debugHTTP, "debug-http", debugHTTP, "Log every HTTP request's URL, status, latency and headers, with credentials redacted")
+	fs.BoolVar(&auditEnabled, "audit", auditEnabled, "Record the time, host and purpose of every outbound request in "+auditFileName+" in the state folder, for audit show")
+	fs.BoolVar(&sdFriendly, "sd-friendly", sdFriendly, "Minimise flash wear: stage files as .part and flush once at the end")
+	fs.StringVar(&c.remountReadOnly, "remount-ro", "", "Mount point to remount read-write during the sync and read-only afterwards (requires root)")
+	return c
+}
+
+// parse parses args into fs, applies PHOTOSYNC_* environment overrides, the selected
+// profile and container defaults, then drops root privileges if requested.
+func (c *commonFlags) parse(fs *flag.FlagSet, args []string) {
+	fs.Parse(args)
+	if err := applyEnvOverrides(fs); err != nil {
+		log.Fatal(err)
+	}
+	if profileName != "" {
+		path := configFile
+		if path == "" {
+			dir := stateDir
+			if c.container && dir == "." {
+				dir = containerStateDir
+			}
+			path = filepath.Join(dir, "photosync.json")
+		}
+		if err := applyProfile(fs, path); err != nil {
+			log.Fatal(err)
+		}
+	}
+	setupOutput()
+	if c.listPageSize < 0 || c.listPageSize > 100 {
+		log.Fatalf("Invalid -list-page-size %d: expected 1 to 100", c.listPageSize)
+	}
+	if err := applyMemorySettings(c.memoryLimit); err != nil {
+		log.Fatalf("Invalid memory settings: %v", err)
+	}
+
+	if c.container {
+		if stateDir == "." {
+			stateDir = containerStateDir
+		}
+		if authFlow == auth.FlowWeb && redirectURL == "" {
+			authFlow = auth.FlowDevice
+		}
+	}
+
+	if c.runAs != "" {
+		if !runningAsRoot() {
+			log.Fatal("The -run-as flag requires starting as root.")
+		}
+		// Bind the callback port while we still can, in case it is a privileged port
+		if authFlow == auth.FlowWeb {
+			listener, err := net.Listen("tcp", callbackAddr)
+			if err != nil {
+				log.Fatalf("Unable to bind OAuth callback address %s: %v", callbackAddr, err)
+			}
+			callbackListener = listener
+		}
+		if err := dropPrivileges(c.runAs); err != nil {
+			log.Fatalf("Unable to drop privileges to %s: %v", c.runAs, err)
+		}
+	} else if runningAsRoot() && !c.container {
+		log.Printf("Warning: running as root; use -run-as to drop privileges")
+	}
+}
+
+// defaultFolder fills in the container photos folder if none was given.
+func (c *commonFlags) defaultFolder(folder *string) {
+	if c.container && *folder == "" {
+		*folder = containerPhotosDir
+	}
+}
+
+// prepareFolder creates folder if needed and locks it for this run. It returns false
+// if another instance holds the lock and the caller should exit.
+func prepareFolder(folder string, lockWait time.Duration) (*instanceLock, bool) {
+	if _, err := os.Stat(folder); os.IsNotExist(err) {
+		if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+			log.Fatalf("Unable to create folder %s: %v", folder, err)
+		}
+	}
+
+	lock, err := acquireFolderLock(folder, lockWait)
+	if errors.Is(err, errLocked) {
+		report(fmt.Sprintf("Another sync is already running in %s: %v", folder, err), "Folder busy", "folder", folder, "err", err)
+		return nil, false
+	} else if err != nil {
+		log.Fatalf("Unable to lock folder %s: %v", folder, err)
+	}
+	return lock, true
+}
+
+// tokenPath returns the location of the cached OAuth2 token. Each profile gets its
+// own token, since profiles may use different Google accounts.
+func tokenPath() string {
+	if tokenFile != "" {
+		return tokenFile
+	}
+	if profileName != "" {
+		return filepath.Join(stateDir, "token-"+profileName+".json")
+	}
+	return filepath.Join(stateDir, "token.json")
+}
+
+// tokenRefreshWait is the least time a refresh waits for another process to
+// finish with the token file.
+const tokenRefreshWait = 30 * time.Second
+
+// authenticate loads the OAuth client credentials and returns an authorized HTTP
+// client, running an OAuth flow if there is no usable token or it lacks a scope. The
+// command's own scopes are requested on top of the defaults and -scope. It returns
+// false if another instance is using the token file and the caller should exit.
+func authenticate(lockWait time.Duration, scopes ...string) (*http.Client, bool) {
+	scopes = append(append(slices.Clone(auth.DefaultScopes), extraScopes...), scopes...)
+	config, err := auth.LoadConfig(credentialsPath(), slices.Compact(slices.Sorted(slices.Values(scopes)))...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if redirectURL != "" {
+		config.RedirectURL = redirectURL
+	}
+
+	tokenLock, err := acquireLock(tokenPath()+".lock", lockWait)
+	if errors.Is(err, errLocked) {
+		report(fmt.Sprintf("Another sync is already using %s: %v", tokenPath(), err), "Token busy", "file", tokenPath(), "err", err)
+		return nil, false
+	} else if err != nil {
+		log.Fatalf("Unable to lock token file: %v", err)
+	}
+	defer tokenLock.Release()
+
+	if reauth {
+		if err := os.Remove(tokenPath()); err != nil && !os.IsNotExist(err) {
+			log.Fatalf("Unable to delete saved token: %v", err)
+		}
+	}
+
+	authenticator := auth.NewAuthenticator(config, tokenPath(),
+		auth.WithFlow(authFlow),
+		auth.WithCallbackAddr(callbackAddr),
+		auth.WithCallbackListener(callbackListener),
+		auth.WithRetryPolicy(controlRetry),
+		auth.WithHTTPClient(httpClient()),
+		auth.WithTokenLock(func() (func(), error) {
+			// Refreshing takes seconds, so waiting a little for another
+			// process to finish one beats failing the request
+			lock, err := acquireLock(tokenPath()+".lock", max(lockWait, tokenRefreshWait))
+			if err != nil {
+				return nil, err
+			}
+			return lock.Release, nil
+		}),
+	)
+	client, _, err := authenticator.Client()
+	if err != nil {
+		log.Fatal(err)
+	}
+	return client, true
+}
+
+// pickerClient wraps an authorized HTTP client in a Picker API client configured
+// from the command line.
+func (c *commonFlags) pickerClient(httpClient *http.Client) *picker.PickerClient {
+	opts := []picker.Option{
+		picker.WithRequestTimeout(c.requestTimeout),
+		picker.WithSlowCallThreshold(c.slowCallThreshold),
+		picker.WithRetryPolicy(controlRetry),
+	}
+	if c.listPageSize > 0 {
+		opts = append(opts, picker.WithPageSize(c.listPageSize))
+	} else if lowMemory {
+		// Keep fewer listing results in flight
+		opts = append(opts, picker.WithPageSize(lowMemoryPageSize))
+	}
+	return picker.NewPickerClient(httpClient, opts...)
+}
+
+// explainAccessError prints how to recover when Google refuses a Picker API call
+// because of how the token or the Cloud project is set up.
+func explainAccessError(err error) {
+	var apiErr *picker.APIError
+	if !errors.As(err, &apiErr) {
+		return
+	}
+	var problem, fix string
+	switch {
+	case apiErr.Reason == picker.ReasonScopeInsufficient:
+		problem = fmt.Sprintf("The saved token (%s) was granted without permission to use the Photos Picker.", tokenPath())
+		fix = "Run again with -reauth to sign in again, and allow every permission on the consent screen."
+	case apiErr.Reason == picker.ReasonServiceDisabled:
+		problem = fmt.Sprintf("The Google Photos Picker API is not enabled in the Cloud project of %s.", credentialsPath())
+		fix = "Enable it under APIs & Services > Library in the Google Cloud console, wait a few minutes and try again."
+	case apiErr.StatusCode == http.StatusUnauthorized:
+		problem = fmt.Sprintf("Google rejected the saved token (%s); it may have been revoked or expired.", tokenPath())
+		fix = "Run again with -reauth to sign in again."
+	case apiErr.StatusCode == http.StatusForbidden:
+		problem = "Google refused access to the Photos Picker."
+		fix = "If the OAuth app is unverified or in testing, add your Google account as a test user on the\n" +
+			"OAuth consent screen in the Google Cloud console, then run again with -reauth."
+	default:
+		return
+	}
+	report("\n"+problem+"\n"+fix, "Access refused", "problem", problem, "fix", strings.ReplaceAll(fix, "\n", " "))
+}
+
+// pickFlags holds the options of the Picker session the user selects photos in.
+type pickFlags struct {
+	maxItems int
+}
+
+// registerPickFlags adds the Picker session options to fs.
+func registerPickFlags(fs *flag.FlagSet) *pickFlags {
+	p := &pickFlags{}
+	fs.IntVar(&p.maxItems, "max-items", 0, "Most items the user may pick in the session; 0 leaves it to Google Photos")
+	return p
+}
+
+// pickMediaItems creates a Picker session, asks the user to select photos and waits
+// for the selection. It returns false if the wait was interrupted.
+func pickMediaItems(ctx context.Context, client *picker.PickerClient, pick *pickFlags) (picker.DownloadableMediaItems, bool) {
+	var opts []picker.SessionOption
+	if pick.maxItems > 0 {
+		opts = append(opts, picker.MaxItemCount(pick.maxItems))
+	}
+
+	// Create a google photos picker session
+	pickingSession, err := client.CreateSession(ctx, opts...)
+	if err != nil {
+		explainAccessError(err)
+		log.Fatalf("Failed to initialise photos picker session: %v", err)
+	}
+
+	// Print the picker URL so the user can open it in their browser
+	report(fmt.Sprintf("\nOpen the following URL in your browser to select photos:\n%s", pickingSession.PickerURI),
+		"Open the picker URL to select photos", "url", pickingSession.PickerURI)
+	report(fmt.Sprintf("\nWaiting for photo selection (timeout: %s, polling every %s)...",
+		pickingSession.PollingConfig.TimeoutIn, pickingSession.PollingConfig.PollInterval),
+		"Waiting for photo selection", "timeout", pickingSession.PollingConfig.TimeoutIn, "poll_interval", pickingSession.PollingConfig.PollInterval)
+
+	// Wait for the user to complete their photo selection
+	downloadableItems, err := client.WaitForSelection(ctx, pickingSession)
+	if errors.Is(err, context.Canceled) {
+		report("Interrupted while waiting for photo selection, exiting.", "Interrupted while waiting for photo selection")
+		return picker.DownloadableMediaItems{}, false
+	} else if err != nil {
+		explainAccessError(err)
+		log.Fatalf("Failed while waiting for photo selection: %v", err)
+	}
+	return downloadableItems, true
+}
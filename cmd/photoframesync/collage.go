@@ -0,0 +1,176 @@
+// collage.go
+//
+// The collage command, which replaces photos far below the frame's resolution with
+// collages of two to four of them, rather than have the frame blow each one up into
+// a blurry slide.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/collage"
+	"PhotoSync/pkg/manifest"
+)
+
+// collagedDir holds the photos that were made into collages, out of the frame's view.
+const collagedDir = ".collaged"
+
+// decodableExtensions are the photo formats the standard library can decode, and so
+// the ones collages can be made from and photos analysed in.
+var decodableExtensions = map[string]bool{".jpg": true, ".jpeg": true, ".png": true, ".gif": true}
+
+// runCollage groups the folder's low-resolution photos into collages the size of
+// the frame. The photos used are moved into the .collaged folder and marked as
+// archived, so syncs do not download them again.
+func runCollage(args []string) {
+	fs := flag.NewFlagSet("collage", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to make collages in")
+	framePtr := fs.String("frame", "", "Frame resolution as WIDTHxHEIGHT, e.g. 1024x600")
+	lowResPtr := fs.Float64("low-res", 0.5, "Treat photos whose longest side is below this fraction of the frame's as low resolution")
+	perCollagePtr := fs.Int("per-collage", 4, "Photos per collage, from 2 to 4")
+	maxUpscalePtr := fs.Float64("max-upscale", 1.5, "Most a photo may be enlarged to fill its place in a collage")
+	dryRunPtr := fs.Bool("dry-run", false, "List the collages that would be made without making them")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" || *framePtr == "" {
+		log.Fatal("You must specify both -folder and -frame.")
+	}
+	if err := refuseInLowMemory("collage"); err != nil {
+		log.Fatal(err)
+	}
+	frameW, frameH, err := parseDimensions(*framePtr)
+	if err != nil {
+		log.Fatalf("Invalid -frame: %v", err)
+	}
+	if *perCollagePtr < 2 || *perCollagePtr > 4 {
+		log.Fatal("-per-collage must be 2, 3 or 4.")
+	}
+	folder := *folderPtr
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+	limit := *lowResPtr * float64(max(frameW, frameH))
+	var lowRes []int
+	for i, entry := range m.Items {
+		if entry.Filename == "" || entry.Archived || entry.Local() || !decodableExtensions[strings.ToLower(filepath.Ext(entry.Filename))] {
+			continue
+		}
+		w, h, err := collage.Size(filepath.Join(folder, entry.Filename))
+		if err != nil {
+			continue
+		}
+		if float64(max(w, h)) < limit {
+			lowRes = append(lowRes, i)
+		}
+	}
+
+	var groups [][]int
+	for len(lowRes) >= 2 {
+		n := min(*perCollagePtr, len(lowRes))
+		groups = append(groups, lowRes[:n])
+		lowRes = lowRes[n:]
+	}
+	if len(groups) == 0 {
+		report("Not enough low resolution photos for a collage.", "Not enough photos for a collage")
+		return
+	}
+	if *dryRunPtr {
+		for _, group := range groups {
+			var names []string
+			for _, i := range group {
+				names = append(names, m.Items[i].Filename)
+			}
+			report("Would combine "+strings.Join(names, ", "), "Would combine", "files", names)
+		}
+		return
+	}
+
+	finishWrites := common.beginWrites()
+	defer finishWrites()
+	if err := os.MkdirAll(filepath.Join(folder, collagedDir), 0o755); err != nil {
+		log.Fatalf("Unable to create %s: %v", collagedDir, err)
+	}
+	made := 0
+	for _, group := range groups {
+		entry, err := makeCollage(folder, m, group, frameW, frameH, *maxUpscalePtr)
+		if err != nil {
+			report(fmt.Sprintf("Error making collage: %v", err), "Error making collage", "err", err)
+			continue
+		}
+		m.Items = append(m.Items, entry)
+		report(fmt.Sprintf("Made %s from %d photos", entry.Filename, len(group)), "Made collage", "file", entry.Filename, "photos", len(group))
+		made++
+	}
+	if err := m.Save(folder); err != nil {
+		log.Fatalf("Unable to save manifest: %v", err)
+	}
+	report(fmt.Sprintf("Made %d collages.", made), "Collage finished", "made", made)
+}
+
+// makeCollage composes the photos of the entries at indexes group into a collage in
+// folder, moves the photos into collagedDir and returns the collage's entry.
+func makeCollage(folder string, m *manifest.Manifest, group []int, width, height int, maxUpscale float64) (manifest.Entry, error) {
+	var paths []string
+	for _, i := range group {
+		paths = append(paths, filepath.Join(folder, m.Items[i].Filename))
+	}
+	img, err := collage.Compose(paths, width, height, maxUpscale)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	// Name it after the first photo's capture date so it sorts near its neighbours
+	name := "collage.jpg"
+	if created := m.Items[group[0]].CreateTime; len(created) >= len(time.DateOnly) {
+		name = "collage-" + created[:len(time.DateOnly)] + ".jpg"
+	}
+	filename := unusedFilename(folder, name)
+	path := filepath.Join(folder, filename)
+	if err := collage.Save(path, img); err != nil {
+		return manifest.Entry{}, err
+	}
+	digest, err := manifest.FileDigest(path)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return manifest.Entry{}, err
+	}
+
+	for _, i := range group {
+		entry := &m.Items[i]
+		moved := filepath.Join(collagedDir, entry.Filename)
+		if err := os.Rename(filepath.Join(folder, entry.Filename), filepath.Join(folder, moved)); err != nil {
+			log.Printf("Unable to move %s out of the frame: %v", entry.Filename, err)
+			continue
+		}
+		entry.Filename, entry.Archived = moved, true
+	}
+	return manifest.Entry{
+		ID:               "collage:" + digest[:16],
+		Filename:         filename,
+		OriginalFilename: filename,
+		Size:             info.Size(),
+		SHA256:           digest,
+		Source:           manifest.SourceCollage,
+	}, nil
+}
@@ -0,0 +1,182 @@
+// drop.go
+//
+// The drop command, which merges photos from a local drop folder, e.g. a Syncthing
+// share the family writes to, into the frame folder alongside the Google Photos
+// selection. Files are recognised by checksum, so each is merged once however
+// often the drop folder is scanned and whatever it is called.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+)
+
+// dropExtensions are the kinds of file merged from a drop folder.
+var dropExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".heic": true, ".mp4": true, ".mov": true,
+}
+
+// dropSettle is how long a file must go unmodified before it is merged, so that
+// files still being copied into the drop folder are left for the next scan.
+const dropSettle = 10 * time.Second
+
+// runDrop merges the drop folder into the frame folder once, or every -watch
+// interval until interrupted.
+func runDrop(args []string) {
+	fs := flag.NewFlagSet("drop", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Frame folder to merge photos into")
+	dropPtr := fs.String("drop", "", "Drop folder to merge photos from")
+	watchPtr := fs.Duration("watch", 0, "Scan the drop folder again at this interval, e.g. 1m; 0 scans once")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" || *dropPtr == "" {
+		log.Fatal("You must specify both -folder and -drop.")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	for {
+		mergeDropFolder(*folderPtr, *dropPtr, common)
+		if *watchPtr <= 0 {
+			return
+		}
+		select {
+		case <-time.After(*watchPtr):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// mergeDropFolder copies the settled files of dropDir that are not yet in folder
+// into it and records them in the manifest.
+func mergeDropFolder(folder, dropDir string, common *commonFlags) {
+	entries, err := os.ReadDir(dropDir)
+	if err != nil {
+		log.Printf("Unable to read drop folder: %v", err)
+		return
+	}
+	var candidates []string
+	for _, entry := range entries {
+		name := entry.Name()
+		// Sync tools write into hidden temporary files first
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || !dropExtensions[strings.ToLower(filepath.Ext(name))] {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < dropSettle {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+	if len(candidates) == 0 {
+		return
+	}
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Printf("Unable to read manifest: %v", err)
+		return
+	}
+	// A file deleted from the frame folder is merged again, replacing its entry
+	known := make(map[string]bool, len(m.Items))
+	kept := m.Items[:0]
+	for _, entry := range m.Items {
+		if entry.Source == manifest.SourceDrop && !fileExists(filepath.Join(folder, entry.Filename)) {
+			continue
+		}
+		if entry.SHA256 != "" {
+			known[entry.SHA256] = true
+		}
+		kept = append(kept, entry)
+	}
+	m.Items = kept
+
+	writer := folderWriter(folder)
+	var finishWrites func()
+	merged := 0
+	for _, name := range candidates {
+		src := filepath.Join(dropDir, name)
+		digest, err := manifest.FileDigest(src)
+		if err != nil {
+			log.Printf("Unable to read %s: %v", src, err)
+			continue
+		}
+		if known[digest] {
+			continue
+		}
+		if finishWrites == nil {
+			finishWrites = common.beginWrites(writer)
+		}
+		filename := unusedFilename(folder, name)
+		if err := writer.CopyFile(src, filepath.Join(folder, filename)); err != nil {
+			report(fmt.Sprintf("Error merging %s: %v", name, err), "Error merging", "file", name, "err", err)
+			continue
+		}
+		info, err := os.Stat(filepath.Join(folder, filename))
+		if err != nil {
+			continue
+		}
+		m.Items = append(m.Items, manifest.Entry{
+			ID:               "drop:" + digest[:16],
+			Filename:         filename,
+			OriginalFilename: name,
+			Size:             info.Size(),
+			SHA256:           digest,
+			Source:           manifest.SourceDrop,
+		})
+		known[digest] = true
+		report("Merged: "+filename, "Merged", "file", filename)
+		merged++
+	}
+	if finishWrites == nil {
+		return
+	}
+	defer finishWrites()
+	if err := m.Save(folder); err != nil {
+		log.Printf("Unable to save manifest: %v", err)
+	}
+	report(fmt.Sprintf("Merged %d files from %s", merged, dropDir), "Drop finished", "merged", merged, "folder", dropDir)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// unusedFilename returns name, or name with a number added if folder already has a
+// file by that name.
+func unusedFilename(folder, name string) string {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 1; ; i++ {
+		if !fileExists(filepath.Join(folder, candidate)) {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s-%d%s", stem, i, ext)
+	}
+}
@@ -0,0 +1,44 @@
+// env.go
+//
+// Environment variable configuration, so the app can be configured entirely from the
+// environment when running in a container.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const envPrefix = "PHOTOSYNC_"
+
+// envName returns the environment variable that configures the named flag,
+// e.g. "request-timeout" becomes PHOTOSYNC_REQUEST_TIMEOUT.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyEnvOverrides sets every flag that was not given on the command line from its
+// PHOTOSYNC_* environment variable, if present. Command line flags always win.
+func applyEnvOverrides(fs *flag.FlagSet) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || explicit[f.Name] {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value %q for %s: %v", value, envName(f.Name), setErr)
+		}
+	})
+	return err
+}
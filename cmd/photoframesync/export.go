@@ -0,0 +1,369 @@
+// export.go
+//
+// The export command, which turns a synced library into something to share or
+// keep: "export html" writes a static gallery that needs no server, so it can be
+// put on any web host or opened straight from a USB stick, and "export pdf" lays
+// the frame's photos out for printing.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/photobook"
+	"PhotoSync/pkg/variant"
+)
+
+// runExport runs the export subcommand named by the first argument.
+func runExport(args []string) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		log.Fatal("Specify what to export: export html or export pdf")
+	}
+	switch args[0] {
+	case "html":
+		runExportHTML(args[1:])
+	case "pdf":
+		runExportPDF(args[1:])
+	default:
+		log.Fatalf("Unknown export %q. Available exports: html, pdf", args[0])
+	}
+}
+
+// exported is a file of the library being exported.
+type exported struct {
+	// Path is the file's location and Name its path relative to the synced folder.
+	Path    string
+	Name    string
+	Created time.Time
+}
+
+// libraryFiles lists the files of the synced library in folder, newest first.
+// Archived files are included if archived is set.
+func libraryFiles(folder string, archived bool) ([]exported, error) {
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return nil, err
+	}
+	var files []exported
+	for _, entry := range m.Items {
+		if entry.Filename == "" || (entry.Archived && !archived) {
+			continue
+		}
+		path := filepath.Join(folder, entry.Filename)
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		f := exported{Path: path, Name: filepath.ToSlash(entry.Filename)}
+		f.Created, err = time.Parse(time.RFC3339, entry.CreateTime)
+		if err != nil {
+			// Files made locally have no capture time; when they were saved will do
+			f.Created = info.ModTime()
+		}
+		files = append(files, f)
+	}
+	slices.SortStableFunc(files, func(a, b exported) int {
+		return b.Created.Compare(a.Created)
+	})
+	return files, nil
+}
+
+// videoExtensions are shown with a video player instead of as a photo.
+var videoExtensions = map[string]bool{
+	".mp4": true, ".m4v": true, ".mov": true, ".webm": true, ".3gp": true, ".mkv": true, ".avi": true,
+}
+
+// galleryItem is one tile of the gallery.
+type galleryItem struct {
+	// ID anchors the item's lightbox; Prev and Next are those of its neighbours.
+	ID, Prev, Next string
+	// File and Thumb are relative to the gallery's index.html. Thumb is empty
+	// for files without one, such as videos.
+	File, Thumb string
+	Video       bool
+	Date        string
+	month       string
+}
+
+// galleryMonth is a heading of the gallery and the items under it.
+type galleryMonth struct {
+	Title string
+	Items []galleryItem
+}
+
+// galleryPage is the gallery's index.html. The lightbox is pure CSS, opened by
+// following a link to the item's anchor, so the page works from file:// with
+// scripts blocked.
+var galleryPage = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 0; padding: 1em; background: #fafafa; color: #222; }
+h1 { font-weight: normal; }
+h2 { font-weight: normal; color: #555; margin: 1.5em 0 0.5em; }
+.grid { display: grid; grid-template-columns: repeat(auto-fill, minmax({{.ThumbSize}}px, 1fr)); gap: 6px; }
+.grid a { display: block; aspect-ratio: 1; overflow: hidden; background: #ddd; color: #555; text-decoration: none; }
+.grid img { width: 100%; height: 100%; object-fit: cover; }
+.grid .video { display: flex; align-items: center; justify-content: center; height: 100%; font-size: 3em; }
+.lightbox { display: none; position: fixed; inset: 0; background: rgba(0, 0, 0, 0.92); z-index: 1; }
+.lightbox:target { display: flex; flex-direction: column; align-items: center; justify-content: center; }
+.lightbox img, .lightbox video { max-width: 95vw; max-height: 85vh; }
+.lightbox p { color: #ccc; margin: 0.8em; }
+.lightbox a { color: #fff; text-decoration: none; font-size: 2em; position: absolute; padding: 0.3em 0.5em; }
+.close { top: 0; right: 0; }
+.prev { left: 0; top: 45%; }
+.next { right: 0; top: 45%; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Months}}
+<h2>{{.Title}}</h2>
+<div class="grid">
+{{range .Items}}<a href="#{{.ID}}" title="{{.Date}}">{{if .Thumb}}<img src="{{.Thumb}}" loading="lazy" alt="{{.Date}}">{{else}}<span class="video">&#9654;</span>{{end}}</a>
+{{end}}</div>
+{{end}}
+{{range .Months}}{{range .Items}}
+<div class="lightbox" id="{{.ID}}">
+{{if .Video}}<video src="{{.File}}" controls preload="none"></video>{{else}}<img src="{{.File}}" loading="lazy" alt="{{.Date}}">{{end}}
+<p>{{.Date}}</p>
+<a class="close" href="#">&times;</a>
+{{if .Prev}}<a class="prev" href="#{{.Prev}}">&lsaquo;</a>{{end}}
+{{if .Next}}<a class="next" href="#{{.Next}}">&rsaquo;</a>{{end}}
+</div>
+{{end}}{{end}}
+<p>Exported {{.Exported}}</p>
+</body>
+</html>
+`))
+
+// runExportHTML writes a static gallery of the synced folder: index.html, the
+// files themselves and a thumbnail of each photo. Running it again only copies
+// what changed.
+func runExportHTML(args []string) {
+	fs := flag.NewFlagSet("export html", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to export")
+	outPtr := fs.String("out", "", "Folder to write the gallery into")
+	titlePtr := fs.String("title", "Photos", "Title of the gallery")
+	thumbSizePtr := fs.Int("thumb-size", 240, "Size of the thumbnails in pixels")
+	archivedPtr := fs.Bool("archived", false, "Include archived photos")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" || *outPtr == "" {
+		log.Fatal("You must specify the synced folder with -folder and where to write the gallery with -out.")
+	}
+	if *thumbSizePtr <= 0 {
+		log.Fatal("-thumb-size must be positive.")
+	}
+	files, err := libraryFiles(*folderPtr, *archivedPtr)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+	if len(files) == 0 {
+		log.Fatalf("Nothing to export from %s; sync into it first.", *folderPtr)
+	}
+	if err := os.MkdirAll(*outPtr, 0o755); err != nil {
+		log.Fatalf("Unable to create the gallery: %v", err)
+	}
+
+	// Thumbnails are twice the tile size, for high-density screens
+	thumbSpec := variant.Spec{Width: 2 * *thumbSizePtr, Height: 2 * *thumbSizePtr, Crop: true, Format: variant.JPEG}
+	var items []galleryItem
+	failed := 0
+	for i, f := range files {
+		item := galleryItem{
+			ID:    fmt.Sprintf("i%d", i+1),
+			File:  "files/" + f.Name,
+			Video: videoExtensions[strings.ToLower(filepath.Ext(f.Name))],
+			Date:  f.Created.Local().Format("Monday 2 January 2006"),
+			month: f.Created.Local().Format("January 2006"),
+		}
+		if err := exportFile(f.Path, filepath.Join(*outPtr, filepath.FromSlash(item.File))); err != nil {
+			log.Printf("Unable to copy %s: %v", f.Name, err)
+			failed++
+			continue
+		}
+		if !item.Video && lowMemory {
+			// Thumbnails decode whole photos, so low-memory hosts show them full
+			// size in the grid
+			item.Thumb = item.File
+		} else if !item.Video {
+			item.Thumb = "thumbs/" + thumbSpec.Filename(f.Name)
+			err := exportThumbnail(f.Path, filepath.Join(*outPtr, filepath.FromSlash(item.Thumb)), thumbSpec)
+			if err != nil {
+				if !errors.Is(err, variant.ErrUnsupported) {
+					log.Printf("Unable to make a thumbnail of %s: %v", f.Name, err)
+				}
+				// Shown full size in the grid instead, if the browser can show it
+				item.Thumb = item.File
+			}
+		}
+		items = append(items, item)
+	}
+
+	var months []galleryMonth
+	for i, item := range items {
+		if i > 0 {
+			item.Prev = items[i-1].ID
+		}
+		if i < len(items)-1 {
+			item.Next = items[i+1].ID
+		}
+		if len(months) == 0 || months[len(months)-1].Title != item.month {
+			months = append(months, galleryMonth{Title: item.month})
+		}
+		months[len(months)-1].Items = append(months[len(months)-1].Items, item)
+	}
+
+	out, err := os.Create(filepath.Join(*outPtr, "index.html.part"))
+	if err != nil {
+		log.Fatalf("Unable to write the gallery: %v", err)
+	}
+	err = galleryPage.Execute(out, map[string]any{
+		"Title":     *titlePtr,
+		"ThumbSize": *thumbSizePtr,
+		"Months":    months,
+		"Exported":  time.Now().Format("2 January 2006"),
+	})
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(out.Name(), filepath.Join(*outPtr, "index.html"))
+	}
+	if err != nil {
+		os.Remove(out.Name())
+		log.Fatalf("Unable to write the gallery: %v", err)
+	}
+	report(fmt.Sprintf("Exported %d files to %s, %d failed.", len(items), filepath.Join(*outPtr, "index.html"), failed),
+		"Exported gallery", "files", len(items), "failed", failed, "out", *outPtr)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// exportFile copies src to dst unless dst is already up to date.
+func exportFile(src, dst string) error {
+	if upToDate(src, dst) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return copyFile(src, dst)
+}
+
+// exportThumbnail makes the thumbnail of src at dst unless it is already up to date.
+func exportThumbnail(src, dst string, spec variant.Spec) error {
+	if upToDate(src, dst) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return variant.Make(src, dst, spec)
+}
+
+// runExportPDF lays the photos on the frame out into a PDF for printing, oldest
+// first. Videos are left out.
+func runExportPDF(args []string) {
+	fs := flag.NewFlagSet("export pdf", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to export")
+	outPtr := fs.String("out", "", "PDF file to write")
+	pagePtr := fs.String("page", "a4", "Page size: a3, a4, a5, letter, legal or WIDTHxHEIGHT in mm")
+	landscapePtr := fs.Bool("landscape", false, "Turn the pages sideways")
+	perPagePtr := fs.Int("per-page", photobook.Default.PerPage, "Photos on each page")
+	marginPtr := fs.Float64("margin", 12, "Margin around each page in mm")
+	captionPtr := fs.String("caption", "date", "Caption under each photo: date, filename or none")
+	titlePtr := fs.String("title", "", "Title for a cover page; none if empty")
+	dpiPtr := fs.Int("dpi", photobook.Default.DPI, "Print resolution photos are scaled down to")
+	archivedPtr := fs.Bool("archived", false, "Include archived photos")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" || *outPtr == "" {
+		log.Fatal("You must specify the synced folder with -folder and the PDF to write with -out.")
+	}
+	opts := photobook.Default
+	page, err := photobook.ParsePageSize(*pagePtr)
+	if err != nil {
+		log.Fatalf("Invalid -page: %v", err)
+	}
+	if *landscapePtr {
+		page = page.Landscape()
+	}
+	opts.Page, opts.PerPage, opts.Title, opts.DPI = page, *perPagePtr, *titlePtr, *dpiPtr
+	opts.Margin = *marginPtr * 72 / 25.4
+	if opts.DPI <= 0 {
+		log.Fatal("-dpi must be positive.")
+	}
+	if !slices.Contains([]string{"date", "filename", "none"}, *captionPtr) {
+		log.Fatalf("Invalid -caption %q: expected date, filename or none", *captionPtr)
+	}
+
+	files, err := libraryFiles(*folderPtr, *archivedPtr)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+	var photos []photobook.Photo
+	// A book reads from the oldest photo to the newest
+	for _, f := range slices.Backward(files) {
+		if videoExtensions[strings.ToLower(filepath.Ext(f.Name))] {
+			continue
+		}
+		photo := photobook.Photo{Path: f.Path}
+		switch *captionPtr {
+		case "date":
+			photo.Caption = f.Created.Local().Format("2 January 2006")
+		case "filename":
+			photo.Caption = filepath.Base(f.Name)
+		}
+		photos = append(photos, photo)
+	}
+	if len(photos) == 0 {
+		log.Fatalf("No photos to export from %s; sync into it first.", *folderPtr)
+	}
+
+	tmp := *outPtr + ".part"
+	out, err := os.Create(tmp)
+	if err != nil {
+		log.Fatalf("Unable to write the PDF: %v", err)
+	}
+	skipped, err := photobook.Write(out, photos, opts)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp, *outPtr)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		log.Fatalf("Unable to write the PDF: %v", err)
+	}
+	for _, path := range skipped {
+		report("Left out "+filepath.Base(path)+", which is not a photo", "Left out", "file", path)
+	}
+	report(fmt.Sprintf("Exported %d photos to %s.", len(photos)-len(skipped), *outPtr),
+		"Exported PDF", "photos", len(photos)-len(skipped), "out", *outPtr)
+}
@@ -0,0 +1,167 @@
+// fanout.go
+//
+// Copying each sync's downloads on to further frames, each with its own size and
+// format, so one picker session can fill several frames while downloading every
+// photo only once.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/frameindex"
+	"PhotoSync/pkg/variant"
+)
+
+// target is a further frame folder that downloads are fanned out to.
+type target struct {
+	folder  string
+	spec    variant.Spec
+	indexes []frameindex.Writer
+}
+
+// parseTarget parses a -target value: the folder, optionally followed by a comma
+// and WIDTHxHEIGHT, "crop", "jpeg" or "png", and index=FORMAT for each index file
+// the frame needs, in any order.
+func parseTarget(s string) (target, error) {
+	fields := strings.Split(s, ",")
+	t := target{folder: strings.TrimSpace(fields[0])}
+	if t.folder == "" {
+		return target{}, fmt.Errorf("no folder in %q", s)
+	}
+	for _, field := range fields[1:] {
+		field = strings.ToLower(strings.TrimSpace(field))
+		if name, ok := strings.CutPrefix(field, "index="); ok {
+			w, err := frameindex.Lookup(name)
+			if err != nil {
+				return target{}, fmt.Errorf("%v in %q", err, s)
+			}
+			t.indexes = append(t.indexes, w)
+			continue
+		}
+		switch field {
+		case "crop":
+			t.spec.Crop = true
+		case variant.JPEG, "jpg":
+			t.spec.Format = variant.JPEG
+		case variant.PNG:
+			t.spec.Format = variant.PNG
+		default:
+			width, height, err := parseDimensions(field)
+			if err != nil {
+				return target{}, fmt.Errorf("invalid option %q in %q", field, s)
+			}
+			t.spec.Width, t.spec.Height = width, height
+		}
+	}
+	if t.spec.Crop && t.spec.Width == 0 {
+		return target{}, fmt.Errorf("crop needs a size in %q", s)
+	}
+	return t, nil
+}
+
+// fanOut brings each target folder up to date with the items saved in folder.
+// Variants newer than their original are left alone, so repeat syncs only redo
+// the photos that changed. Files that cannot be decoded, such as videos, are
+// copied as they are, unless the target asks for a specific format, which
+// suggests a photo-only frame. In low-memory mode photos are copied as they are too.
+func fanOut(folder string, saved []download.Item, targets []target) {
+	for _, t := range targets {
+		if err := os.MkdirAll(t.folder, 0o755); err != nil {
+			log.Printf("Unable to create %s: %v", t.folder, err)
+			continue
+		}
+		made, current, failed := 0, 0, 0
+		var files []frameindex.File
+		for _, item := range saved {
+			name := t.spec.Filename(item.Filename)
+			src := filepath.Join(folder, item.Filename)
+			dst := filepath.Join(t.folder, name)
+			if upToDate(src, dst) {
+				files = append(files, t.indexedFile(item, name))
+				current++
+				continue
+			}
+			var err error
+			if lowMemory {
+				// Targets with a size or format are refused in low-memory mode, so
+				// all a copy misses is turning the photo upright
+				err = copyFile(src, dst)
+			} else {
+				err = variant.Make(src, dst, t.spec)
+			}
+			if errors.Is(err, variant.ErrUnsupported) {
+				if t.spec.Format != "" {
+					continue
+				}
+				err = copyFile(src, dst)
+			}
+			if err != nil {
+				log.Printf("Unable to copy %s to %s: %v", item.Filename, t.folder, err)
+				failed++
+				continue
+			}
+			files = append(files, t.indexedFile(item, name))
+			made++
+		}
+		writeIndexes(t.folder, t.indexes, files)
+		report(fmt.Sprintf("%s: %d updated, %d already up to date, %d failed", t.folder, made, current, failed),
+			"Fanned out", "folder", t.folder, "updated", made, "current", current, "failed", failed)
+	}
+}
+
+// indexedFile describes item, saved in the target as name, to its index writers.
+// Resized copies no longer have the original's dimensions, which are left out.
+func (t target) indexedFile(item download.Item, name string) frameindex.File {
+	f := indexedFile(item, name)
+	if t.spec.Width > 0 {
+		f.Width, f.Height = 0, 0
+	}
+	return f
+}
+
+// upToDate reports whether dst exists and is newer than src.
+func upToDate(src, dst string) bool {
+	srcInfo, err := os.Stat(src)
+	if err != nil {
+		return false
+	}
+	dstInfo, err := os.Stat(dst)
+	return err == nil && !dstInfo.ModTime().Before(srcInfo.ModTime())
+}
+
+// copyFile copies src to dst through a .part file, so the frame never shows a
+// half-copied file. Where both are on the same filesystem dst is a hardlink
+// instead, which takes no extra space.
+func copyFile(src, dst string) error {
+	tmp := dst + ".part"
+	os.Remove(tmp)
+	if err := os.Link(src, tmp); err == nil {
+		return os.Rename(tmp, dst)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, dst)
+}
@@ -0,0 +1,113 @@
+// gc.go
+//
+// The gc command, which removes files left behind by interrupted syncs and old
+// versions of replaced files. Frame hosts can run it from cron.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"PhotoSync/pkg/download"
+)
+
+// runGC removes stale .part files from the folder and, with -version-age, kept
+// versions older than that. With -pool it also removes pool files that no folder
+// links to any more.
+func runGC(args []string) {
+	fs := flag.NewFlagSet("gc", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to clean up")
+	partAgePtr := fs.Duration("part-age", 24*time.Hour, "Remove .part files not written to for this long")
+	versionAgePtr := fs.Duration("version-age", 0, "Remove versions kept by -replace-changed that are older than this, e.g. 720h; 0 keeps them")
+	poolPtr := fs.String("pool", "", "Pool of hardlinked photos made by -pool to remove unused files from")
+	dryRunPtr := fs.Bool("dry-run", false, "List what would be removed without removing it")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+
+	// A sync in progress may still be writing its .part files
+	lock, ok := prepareFolder(*folderPtr, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	garbage, err := download.FindGarbage(*folderPtr, time.Now(), *partAgePtr, *versionAgePtr)
+	if err != nil {
+		log.Fatalf("Unable to scan %s: %v", *folderPtr, err)
+	}
+	paths := append(garbage.StaleParts, garbage.OldVersions...)
+	if *poolPtr != "" {
+		unused, err := unusedPoolFiles(*poolPtr)
+		if err != nil {
+			log.Fatalf("Unable to scan %s: %v", *poolPtr, err)
+		}
+		paths = append(paths, unused...)
+	}
+	if len(paths) == 0 {
+		report("Nothing to clean up.", "Nothing to clean up")
+		return
+	}
+
+	if *dryRunPtr {
+		for _, path := range paths {
+			report("Would remove "+path, "Would remove", "file", path)
+		}
+		return
+	}
+
+	finishWrites := common.beginWrites()
+	defer finishWrites()
+	removed := 0
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil {
+			report(fmt.Sprintf("Error removing %s: %v", path, err), "Error removing", "file", path, "err", err)
+			continue
+		}
+		report("Removed "+path, "Removed", "file", path)
+		removed++
+	}
+	report(fmt.Sprintf("Removed %d of %d files.", removed, len(paths)), "Cleanup finished", "removed", removed, "found", len(paths))
+}
+
+// unusedPoolFiles lists the files in pool that are no longer hardlinked into any
+// folder. Only files named as pooled files are listed, and a folder without the
+// pool marker must hold nothing else, so that a folder given as -pool by mistake
+// is left alone.
+func unusedPoolFiles(pool string) ([]string, error) {
+	_, err := os.Stat(filepath.Join(pool, poolMarkerName))
+	marked := err == nil
+	var unused []string
+	err = filepath.WalkDir(pool, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		if !isPoolPath(pool, path) {
+			if marked {
+				return nil
+			}
+			return fmt.Errorf("not a pool: it has no %s file and holds %s", poolMarkerName, path)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if links, ok := linkCount(info); ok && links == 1 {
+			unused = append(unused, path)
+		}
+		return nil
+	})
+	return unused, err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestUnusedPoolFiles(t *testing.T) {
+	pooled := func(digest string) string {
+		return digest[:2] + "/" + digest + ".jpg"
+	}
+	kept, unused := pooled(strings.Repeat("ab", 32)), pooled(strings.Repeat("cd", 32))
+	upper := pooled(strings.Repeat("AB", 32))
+
+	tests := []struct {
+		name   string
+		marked bool
+		// files are in the pool, and linked those of them a frame folder links to
+		files, linked []string
+		want          []string
+		wantErr       bool
+	}{
+		{"unlinked files", true, []string{kept, unused}, []string{kept}, []string{unused}, false},
+		{"marked pool with other files", true, []string{unused, "notes.txt", "ab/holiday.jpg"}, nil, []string{unused}, false},
+		{"unmarked pool", false, []string{kept, unused}, []string{kept}, []string{unused}, false},
+		{"unmarked folder of photos", false, []string{unused, "holiday.jpg"}, nil, nil, true},
+		{"unmarked folder with a near miss", false, []string{upper}, nil, nil, true},
+	}
+	for _, tt := range tests {
+		pool, frame := t.TempDir(), t.TempDir()
+		touch(t, pool, tt.files...)
+		if tt.marked {
+			if err := markPool(pool); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for i, name := range tt.linked {
+			if err := os.Link(filepath.Join(pool, name), filepath.Join(frame, strings.Repeat("x", i+1)+".jpg")); err != nil {
+				t.Skipf("hardlinks are not available: %v", err)
+			}
+		}
+		if info, err := os.Stat(filepath.Join(pool, tt.files[0])); err != nil {
+			t.Fatal(err)
+		} else if _, ok := linkCount(info); !ok {
+			t.Skip("link counts are not available on this platform")
+		}
+
+		files, err := unusedPoolFiles(pool)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: unusedPoolFiles = %q, want an error", tt.name, files)
+			}
+			continue
+		}
+		var got []string
+		for _, file := range files {
+			rel, _ := filepath.Rel(pool, file)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("%s: unusedPoolFiles = %q, %v; want %q", tt.name, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// health.go
+//
+// Checking the storage a sync is about to write to, so that thousands of photos
+// are not written to a card that is full of files or failing. What can be checked
+// depends on the platform: free inodes, a filesystem the kernel has made read-only
+// or recorded errors on, and optionally the SMART health of the disk.
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// inodeMargin is how many inodes beyond one per item a sync needs, for the
+// manifest, index files and partial downloads.
+const inodeMargin = 100
+
+// checkStorage reports problems with the storage holding folder before items are
+// written to it. With -storage-check abort it returns an error if there are any.
+func (p *pipelineFlags) checkStorage(folder string, items int) error {
+	if p.storageCheck == "off" {
+		return nil
+	}
+	problems := storageProblems(folder, items, p.smart)
+	for _, problem := range problems {
+		report("Storage problem: "+problem, "Storage problem", "folder", folder, "problem", problem)
+	}
+	if len(problems) > 0 && p.storageCheck == "abort" {
+		return fmt.Errorf("the storage holding %s is not healthy: %s", folder, strings.Join(problems, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+//go:build linux
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// storageProblems lists what is wrong with the filesystem holding folder, about
+// to receive items files.
+func storageProblems(folder string, items int, smart bool) []string {
+	var problems []string
+	var st syscall.Statfs_t
+	// Filesystems without an inode table, such as btrfs, report no inodes at all
+	if err := syscall.Statfs(folder, &st); err == nil && st.Files > 0 && st.Ffree < uint64(items+inodeMargin) {
+		problems = append(problems, fmt.Sprintf("only %d files can be created, and %d are to be written", st.Ffree, items))
+	}
+
+	m, ok := findMount(folder)
+	if !ok {
+		return problems
+	}
+	if m.readOnly {
+		problems = append(problems, fmt.Sprintf("%s is mounted read-only, which the kernel does after filesystem errors", m.point))
+	}
+	device := m.source
+	if resolved, err := filepath.EvalSymlinks(device); err == nil {
+		device = resolved
+	}
+	if !strings.HasPrefix(device, "/dev/") {
+		return problems
+	}
+	if m.fsType == "ext4" {
+		if data, err := os.ReadFile(filepath.Join("/sys/fs/ext4", filepath.Base(device), "errors_count")); err == nil {
+			if n, _ := strconv.Atoi(strings.TrimSpace(string(data))); n > 0 {
+				problems = append(problems, fmt.Sprintf("%s has recorded %d filesystem errors; check it with fsck", device, n))
+			}
+		}
+	}
+	if smart {
+		if problem := smartHealth(device); problem != "" {
+			problems = append(problems, problem)
+		}
+	}
+	return problems
+}
+
+// mount is a line of /proc/self/mountinfo.
+type mount struct {
+	point, fsType, source string
+	readOnly              bool
+}
+
+// findMount returns the mount holding path.
+func findMount(path string) (mount, bool) {
+	path, err := filepath.Abs(path)
+	if err == nil {
+		path, err = filepath.EvalSymlinks(path)
+	}
+	if err != nil {
+		return mount{}, false
+	}
+	f, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return mount{}, false
+	}
+	defer f.Close()
+
+	var found mount
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// ID PARENT MAJOR:MINOR ROOT POINT OPTIONS [OPTIONAL...] - TYPE SOURCE SUPER
+		before, after, ok := strings.Cut(scanner.Text(), " - ")
+		fields, rest := strings.Fields(before), strings.Fields(after)
+		if !ok || len(fields) < 6 || len(rest) < 2 {
+			continue
+		}
+		point := strings.ReplaceAll(fields[4], `\040`, " ")
+		within := path == point || strings.HasPrefix(path, strings.TrimSuffix(point, "/")+"/")
+		// Later mounts over the same point hide earlier ones
+		if within && len(point) >= len(found.point) {
+			options := strings.Split(fields[5], ",")
+			found = mount{point: point, fsType: rest[0], source: rest[1], readOnly: options[0] == "ro"}
+		}
+	}
+	return found, found.point != ""
+}
+
+// smartHealth asks smartctl whether the disk holding the partition device is
+// failing, returning the problem if it is. Disks that cannot be asked, such as
+// SD cards in built-in slots, are only logged.
+func smartHealth(device string) string {
+	disk := device
+	// A partition's sysfs entry sits inside its disk's
+	if sys, err := filepath.EvalSymlinks(filepath.Join("/sys/class/block", filepath.Base(device))); err == nil {
+		if _, err := os.Stat(filepath.Join(sys, "partition")); err == nil {
+			disk = "/dev/" + filepath.Base(filepath.Dir(sys))
+		}
+	}
+	status, err := smartctl(disk)
+	if err != nil {
+		// USB card readers and enclosures mostly need SCSI-ATA translation
+		status, err = smartctl(disk, "-d", "sat")
+	}
+	if err != nil {
+		log.Printf("Unable to query the SMART health of %s: %v", disk, err)
+		return ""
+	}
+	// Bit 3 of smartctl's exit status is set when the disk reports it is failing
+	if status&8 != 0 {
+		return fmt.Sprintf("%s reports through SMART that it is failing", disk)
+	}
+	return ""
+}
+
+// smartctl runs smartctl -H on disk and returns its exit status, or an error if
+// it could not read the disk's health at all.
+func smartctl(disk string, args ...string) (int, error) {
+	out, err := exec.Command("smartctl", append(append([]string{"-H"}, args...), disk)...).CombinedOutput()
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		status := exit.ExitCode()
+		// Bits 0 to 2 mean the command line, the device or the query failed
+		if status&7 != 0 {
+			return status, fmt.Errorf("smartctl exited with status %d: %s", status, lastLine(out))
+		}
+		return status, nil
+	}
+	return 0, err
+}
+
+// lastLine returns the last non-empty line of out, where tools put their error.
+func lastLine(out []byte) string {
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	return strings.TrimSpace(lines[len(lines)-1])
+}
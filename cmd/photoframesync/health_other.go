@@ -0,0 +1,9 @@
+//go:build !linux
+
+package main
+
+// storageProblems finds nothing wrong: the checks read Linux's view of its
+// filesystems and disks.
+func storageProblems(folder string, items int, smart bool) []string {
+	return nil
+}
@@ -0,0 +1,75 @@
+// index.go
+//
+// Writing the index and marker files a frame model expects, into the synced folder
+// with -index and into fan-out targets with their index= option.
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/frameindex"
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/picker"
+)
+
+// parseIndexes parses a comma-separated list of index formats.
+func parseIndexes(s string) ([]frameindex.Writer, error) {
+	var writers []frameindex.Writer
+	for _, name := range strings.Split(s, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		w, err := frameindex.Lookup(name)
+		if err != nil {
+			return nil, err
+		}
+		writers = append(writers, w)
+	}
+	return writers, nil
+}
+
+// indexedFile describes item, saved under name, to the index writers.
+func indexedFile(item download.Item, name string) frameindex.File {
+	f := frameindex.File{
+		Name:   name,
+		Width:  item.MediaFile.MediaFileMetadata.Width,
+		Height: item.MediaFile.MediaFileMetadata.Height,
+		Video:  item.Type == picker.MediaTypeVideo,
+	}
+	f.Created, _ = time.Parse(time.RFC3339, item.CreateTime)
+	return f
+}
+
+// folderFiles lists the files on show in folder: the saved items, and the files
+// made locally, such as dropped photos and collages.
+func folderFiles(folder string, saved []download.Item) []frameindex.File {
+	var files []frameindex.File
+	for _, item := range saved {
+		files = append(files, indexedFile(item, item.Filename))
+	}
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return files
+	}
+	for _, entry := range m.Items {
+		if entry.Local() && !entry.Archived && entry.Filename != "" {
+			f := frameindex.File{Name: entry.Filename}
+			f.Created, _ = time.Parse(time.RFC3339, entry.CreateTime)
+			files = append(files, f)
+		}
+	}
+	return files
+}
+
+// writeIndexes runs each index writer over folder.
+func writeIndexes(folder string, writers []frameindex.Writer, files []frameindex.File) {
+	for _, w := range writers {
+		if err := w.Write(folder, files); err != nil {
+			log.Printf("Unable to write index files into %s: %v", filepath.Base(folder), err)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// kiosk.go
+//
+// The /kiosk pages of serve, which turn any device with a browser, such as a cheap
+// tablet or a signage player, into a frame. Each device is given a URL with its
+// own token, which picks the folder it shows; the page has no controls, keeps the
+// screen awake and rides out the server restarting or the Wi-Fi dropping. A
+// remote, such as a home automation button, can skip, go back or pause a device
+// by posting to /kiosk/TOKEN/remote/next, prev, pause or resume, and see what it
+// is showing at /kiosk/TOKEN/remote.
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+)
+
+// kioskExtensions are the files a kiosk shows, by extension; the rest of a folder
+// is ignored.
+var kioskExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true,
+	".mp4": true, ".webm": true, ".m4v": true,
+}
+
+// kioskToken matches valid device tokens, which go in URLs and should be hard
+// to guess.
+var kioskToken = regexp.MustCompile(`^[A-Za-z0-9_-]{8,}$`)
+
+// kioskDevices maps device tokens to the folders they show.
+type kioskDevices map[string]string
+
+// parseKioskDevices parses -kiosk values: TOKEN=FOLDER, or a bare TOKEN for the
+// folder being synced.
+func parseKioskDevices(values []string, folder string) (kioskDevices, error) {
+	devices := make(kioskDevices)
+	for _, value := range values {
+		token, dir, ok := strings.Cut(value, "=")
+		if !ok {
+			dir = folder
+		}
+		token = strings.TrimSpace(token)
+		if !kioskToken.MatchString(token) {
+			return nil, fmt.Errorf("invalid token %q: use at least 8 letters, digits, - or _", token)
+		}
+		devices[token] = dir
+	}
+	return devices, nil
+}
+
+// kioskFiles lists the files a kiosk showing dir shows, by name.
+func kioskFiles(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && !strings.HasPrefix(name, ".") && kioskExtensions[strings.ToLower(filepath.Ext(name))] {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// kioskFolder returns the folder of the device in the request's token, writing
+// a 404 if there is none.
+func (s *familyServer) kioskFolder(w http.ResponseWriter, r *http.Request) (string, bool) {
+	dir, ok := s.kiosks[r.PathValue("token")]
+	if !ok {
+		http.NotFound(w, r)
+	}
+	return dir, ok
+}
+
+// showKiosk renders a device's kiosk page.
+func (s *familyServer) showKiosk(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.kioskFolder(w, r); !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	s.kioskPage.Execute(w, map[string]any{
+		"Playlist":      "/kiosk/" + r.PathValue("token") + "/playlist.json",
+		"Remote":        "/kiosk/" + r.PathValue("token") + "/remote",
+		"Interval":      s.kioskInterval.Milliseconds(),
+		"PairPortraits": s.kioskPairPortraits,
+	})
+}
+
+// kioskOrders are the orders a kiosk can show its files in: shuffled afresh on
+// each round, oldest first, or by file name.
+var kioskOrders = []string{"shuffle", "chronological", "name"}
+
+// kioskSlide is one file of a kiosk's playlist, with what it is ordered by.
+type kioskSlide struct {
+	url   string
+	name  string
+	taken time.Time
+}
+
+// orderKioskSlides puts slides in order, which must be one of kioskOrders.
+// Slides with the same time or name keep their order.
+func orderKioskSlides(slides []kioskSlide, order string) {
+	switch order {
+	case "chronological":
+		slices.SortStableFunc(slides, func(a, b kioskSlide) int { return a.taken.Compare(b.taken) })
+	case "name":
+		slices.SortStableFunc(slides, func(a, b kioskSlide) int { return strings.Compare(a.name, b.name) })
+	default:
+		rand.Shuffle(len(slides), func(i, j int) {
+			slides[i], slides[j] = slides[j], slides[i]
+		})
+	}
+}
+
+// takenTime parses a Google Photos create time, returning fallback if there is
+// none.
+func takenTime(createTime string, fallback time.Time) time.Time {
+	if t, err := time.Parse(time.RFC3339, createTime); err == nil {
+		return t
+	}
+	return fallback
+}
+
+// folderSlides returns the slides of a kiosk showing dir. Files are dated by
+// when they were taken, as recorded in the folder's manifest, or failing that by
+// when they were last changed.
+func folderSlides(dir, prefix string) ([]kioskSlide, error) {
+	names, err := kioskFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]string)
+	if m, err := manifest.Load(dir); err == nil {
+		for _, entry := range m.Items {
+			if entry.Filename != "" {
+				created[entry.Filename] = entry.CreateTime
+			}
+		}
+	}
+	slides := make([]kioskSlide, 0, len(names))
+	for _, name := range names {
+		var modTime time.Time
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			modTime = info.ModTime()
+		}
+		slides = append(slides, kioskSlide{
+			url:   prefix + "/files/" + url.PathEscape(name),
+			name:  name,
+			taken: takenTime(created[name], modTime),
+		})
+	}
+	return slides, nil
+}
+
+// kioskPlaylist lists the URLs of the files a device shows, in the -kiosk-order.
+// Shuffled playlists are shuffled afresh on each request, so that every round is
+// in a different order.
+func (s *familyServer) kioskPlaylist(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.kioskFolder(w, r)
+	if !ok {
+		return
+	}
+	prefix := "/kiosk/" + r.PathValue("token")
+	var slides []kioskSlide
+	if s.stream != nil && dir == s.folder {
+		for _, item := range s.stream.items() {
+			if kioskExtensions[strings.ToLower(filepath.Ext(item.Filename))] {
+				slides = append(slides, kioskSlide{
+					url:   prefix + "/stream/" + url.PathEscape(item.ID) + "/" + url.PathEscape(item.Filename),
+					name:  item.Filename,
+					taken: takenTime(item.CreateTime, time.Time{}),
+				})
+			}
+		}
+	} else {
+		var err error
+		slides, err = folderSlides(dir, prefix)
+		if err != nil {
+			log.Printf("Unable to list %s for a kiosk: %v", dir, err)
+			http.Error(w, "Unable to list photos", http.StatusInternalServerError)
+			return
+		}
+	}
+	orderKioskSlides(slides, s.kioskOrder)
+	files := []string{}
+	for _, slide := range slides {
+		files = append(files, slide.url)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"files": files})
+}
+
+// kioskCommands are what a remote can ask of a device.
+var kioskCommands = []string{"next", "prev", "pause", "resume"}
+
+// kioskState is what a device last said it was showing, and the next and prev
+// commands it has yet to pick up.
+type kioskState struct {
+	Showing  string    `json:"showing"`
+	Seen     time.Time `json:"seen"`
+	Paused   bool      `json:"paused"`
+	commands []string
+}
+
+// deviceState returns the state of the device in the request's token. s.remoteMu
+// must be held.
+func (s *familyServer) deviceState(r *http.Request) *kioskState {
+	token := r.PathValue("token")
+	if s.remote == nil {
+		s.remote = make(map[string]*kioskState)
+	}
+	state, ok := s.remote[token]
+	if !ok {
+		state = &kioskState{}
+		s.remote[token] = state
+	}
+	return state
+}
+
+// kioskControl takes a command for a device from a remote, such as a home
+// automation button. The device carries it out when it next checks in, within a
+// few seconds.
+func (s *familyServer) kioskControl(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.kioskFolder(w, r); !ok {
+		return
+	}
+	command := r.PathValue("command")
+	if !slices.Contains(kioskCommands, command) {
+		http.Error(w, "Unknown command: use "+strings.Join(kioskCommands, ", "), http.StatusBadRequest)
+		return
+	}
+	s.remoteMu.Lock()
+	defer s.remoteMu.Unlock()
+	state := s.deviceState(r)
+	switch command {
+	case "pause", "resume":
+		state.Paused = command == "pause"
+	default:
+		// A device that is off should not find a backlog of skips waiting for it
+		if len(state.commands) < 10 {
+			state.commands = append(state.commands, command)
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// kioskNow reports what a device is showing, whether it is paused, and when it
+// last checked in.
+func (s *familyServer) kioskNow(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.kioskFolder(w, r); !ok {
+		return
+	}
+	s.remoteMu.Lock()
+	state := *s.deviceState(r)
+	s.remoteMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(state)
+}
+
+// kioskCheckIn records what a device is showing and hands it the commands
+// waiting for it.
+func (s *familyServer) kioskCheckIn(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.kioskFolder(w, r); !ok {
+		return
+	}
+	// The page sends the URLs of what it shows, two for a pair of portraits
+	var names []string
+	for _, file := range strings.Fields(r.FormValue("showing")) {
+		name, err := url.PathUnescape(path.Base(file))
+		if err != nil {
+			name = file
+		}
+		names = append(names, name)
+	}
+	showing := strings.Join(names, ", ")
+	s.remoteMu.Lock()
+	state := s.deviceState(r)
+	state.Showing = showing
+	state.Seen = time.Now()
+	commands := state.commands
+	state.commands = nil
+	paused := state.Paused
+	s.remoteMu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"commands": commands, "paused": paused})
+}
+
+// kioskFile serves one of the files a device shows.
+func (s *familyServer) kioskFile(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.kioskFolder(w, r)
+	if !ok {
+		return
+	}
+	name := r.PathValue("name")
+	names, err := kioskFiles(dir)
+	if err != nil || !slices.Contains(names, name) {
+		http.NotFound(w, r)
+		return
+	}
+	path := filepath.Join(dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	// -replace-changed rewrites files under the same name, so devices check back
+	// each time; unchanged files cost them a 304 rather than the whole photo
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()))
+	http.ServeFile(w, r, path)
+}
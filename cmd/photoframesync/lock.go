@@ -0,0 +1,91 @@
+// lock.go
+//
+// Instance locking so that concurrent runs (e.g. overlapping cron jobs) cannot race
+// on the same download folder or token file.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const lockFileName = ".photosync.lock"
+
+// errLocked is returned when another live instance holds a lock.
+var errLocked = errors.New("locked by another instance")
+
+type lockInfo struct {
+	PID      int       `json:"pid"`
+	Hostname string    `json:"hostname"`
+	Started  time.Time `json:"started"`
+}
+
+// instanceLock is an exclusive lock held for the duration of a run. The lock is
+// taken by the operating system on the open lock file rather than by the file
+// existing, so it goes with the process however that ends: a crashed run, or a
+// container restarted with the same PID and hostname, never leaves it held. The
+// file itself stays, recording who last held it.
+type instanceLock struct {
+	f *os.File
+}
+
+// acquireFolderLock takes the lock for a download folder, waiting up to wait for
+// another instance to finish.
+func acquireFolderLock(folder string, wait time.Duration) (*instanceLock, error) {
+	return acquireLock(filepath.Join(folder, lockFileName), wait)
+}
+
+// acquireLock locks the file at path, creating it if needed and waiting up to wait
+// for another instance to release it.
+func acquireLock(path string, wait time.Duration) (*instanceLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %v", path, err)
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		err := lockFile(f)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, errLocked) {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %v", path, err)
+		}
+		if time.Now().After(deadline) {
+			holder, _ := readLock(f)
+			f.Close()
+			return nil, fmt.Errorf("%w (pid %d on %s since %s)", errLocked, holder.PID, holder.Hostname, holder.Started.Format(time.RFC3339))
+		}
+		time.Sleep(time.Second)
+	}
+
+	hostname, _ := os.Hostname()
+	data, _ := json.Marshal(lockInfo{PID: os.Getpid(), Hostname: hostname, Started: time.Now()})
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt(append(data, '\n'), 0)
+	}
+	return &instanceLock{f: f}, nil
+}
+
+// Release unlocks the lock file.
+func (l *instanceLock) Release() {
+	unlockFile(l.f)
+	l.f.Close()
+}
+
+// readLock reads who holds the lock from its file.
+func readLock(f *os.File) (lockInfo, error) {
+	var info lockInfo
+	data := make([]byte, 512)
+	n, err := f.ReadAt(data, 0)
+	if n == 0 {
+		return info, err
+	}
+	err = json.Unmarshal(data[:n], &info)
+	return info, err
+}
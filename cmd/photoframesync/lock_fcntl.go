@@ -0,0 +1,28 @@
+//go:build solaris || aix
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+	"syscall"
+)
+
+// lockFile takes an exclusive lock on f without waiting, returning errLocked if
+// another process holds it. These systems have no flock, and fcntl locks belong
+// to the process, so a second lock on the same file from this process succeeds.
+func lockFile(f *os.File) error {
+	lock := syscall.Flock_t{Type: syscall.F_WRLCK, Whence: io.SeekStart}
+	err := syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+	if errors.Is(err, syscall.EAGAIN) || errors.Is(err, syscall.EACCES) {
+		return errLocked
+	}
+	return err
+}
+
+// unlockFile releases the lock on f.
+func unlockFile(f *os.File) error {
+	lock := syscall.Flock_t{Type: syscall.F_UNLCK, Whence: io.SeekStart}
+	return syscall.FcntlFlock(f.Fd(), syscall.F_SETLK, &lock)
+}
@@ -0,0 +1,223 @@
+// main.go
+//
+// This Go app provides a web interface for selecting and downloading photos from Google Photos
+// using the Google Photos Picker API.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
+
+	"PhotoSync/pkg/picker"
+)
+
+func main() {
+	command, args, err := splitCommand(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Commands that hold a lock return their exit code rather than exiting
+	// themselves, so that the lock is released first
+	exitCode := 0
+	switch command {
+	case "sync":
+		exitCode = runSync(args)
+	case "pick":
+		runPick(args)
+	case "download":
+		exitCode = runDownload(args)
+	case "import":
+		runImport(args)
+	case "whoami":
+		runWhoami(args)
+	case "verify":
+		runVerify(args)
+	case "repair":
+		exitCode = runRepair(args)
+	case "gc":
+		runGC(args)
+	case "state":
+		runState(args)
+	case "serve":
+		runServe(args)
+	case "archive":
+		runArchive(args)
+	case "drop":
+		runDrop(args)
+	case "collage":
+		runCollage(args)
+	case "bursts":
+		runBursts(args)
+	case "quality":
+		runQuality(args)
+	case "snapshots":
+		runSnapshots(args)
+	case "export":
+		runExport(args)
+	case "pause":
+		runPause(args, false)
+	case "resume":
+		runPause(args, true)
+	case "audit":
+		runAudit(args)
+	case "purge":
+		runPurge(args)
+	case "update":
+		runUpdate(args)
+	default:
+		log.Fatalf("Unknown command %q. Available commands: %s", command, strings.Join(commands, ", "))
+	}
+	os.Exit(exitCode)
+}
+
+// commands are the commands main runs.
+var commands = []string{"sync", "pick", "download", "import", "whoami", "verify", "repair", "gc", "state", "serve",
+	"archive", "drop", "collage", "bursts", "quality", "snapshots", "export", "pause", "resume", "audit", "purge", "update"}
+
+// splitCommand takes the command out of args. It normally comes first, but flags
+// such as -container may come before it, in which case the one argument naming a
+// command is taken. Without a command the sync command runs.
+func splitCommand(args []string) (string, []string, error) {
+	if len(args) == 0 {
+		return "sync", args, nil
+	}
+	if !strings.HasPrefix(args[0], "-") {
+		return args[0], args[1:], nil
+	}
+	found := -1
+	for i, arg := range args {
+		if !slices.Contains(commands, arg) {
+			continue
+		}
+		if found >= 0 {
+			return "", nil, fmt.Errorf("both %q and %q could be the command; give the command before any flags", args[found], arg)
+		}
+		found = i
+	}
+	if found < 0 {
+		return "sync", args, nil
+	}
+	return args[found], append(slices.Clone(args[:found]), args[found+1:]...), nil
+}
+
+// runSync picks photos and downloads them straight into the target folder,
+// returning the exit code.
+func runSync(args []string) int {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Folder location on your PC where photos will be saved")
+	confirmPtr := fs.Bool("confirm", false, "Ask before downloading once the selection changes have been listed")
+	resumePtr := fs.Bool("resume", false, "Finish the last sync into the folder if it crashed or some items failed, instead of picking again")
+	waitOnlinePtr := fs.Duration("wait-online", 0, "If items fail because the network is down, wait up to this long for it to come back and resume, e.g. 45m")
+	common := registerCommonFlags(fs)
+	pick := registerPickFlags(fs)
+	pipeline := registerPipelineFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+
+	// Stop cleanly on Ctrl+C or when the container runtime asks us to
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	downloadPath := *folderPtr
+	lock, ok := prepareFolder(downloadPath, common.lockWait)
+	if !ok {
+		return 0
+	}
+	defer lock.Release()
+	pauseOnSignal(downloadPath)
+
+	client, ok := authenticate(common.lockWait)
+	if !ok {
+		return 0
+	}
+	downloader, err := pipeline.newDownloader(client, downloadPath)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	targets, err := pipeline.fanOutTargets()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	indexes, err := pipeline.indexWriters()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	var downloadableItems picker.DownloadableMediaItems
+	resumed := false
+	if *resumePtr {
+		downloadableItems, resumed = loadPending(downloadPath)
+	}
+	if !resumed {
+		downloadableItems, ok = pickMediaItems(ctx, common.pickerClient(client), pick)
+		if !ok {
+			return 0
+		}
+		if !reportSelectionChanges(downloadPath, downloadableItems, *confirmPtr) {
+			report("Sync cancelled.", "Sync cancelled")
+			return 0
+		}
+	}
+
+	// Download the downloadable items
+	finishWrites := common.beginWrites(downloader)
+	if err := pipeline.checkStorage(downloadPath, len(downloadableItems.MediaItems)); err != nil {
+		finishWrites()
+		log.Print(err)
+		return 1
+	}
+	if !resumed {
+		savePending(downloadPath, downloadableItems)
+	}
+	result, err := downloader.Download(ctx, downloadableItems)
+	if err == nil && *waitOnlinePtr > 0 {
+		result = resumeWhenOnline(ctx, downloader, downloadableItems, result, *waitOnlinePtr)
+	}
+	if err == nil && !pipeline.rollBack(downloadPath, result) {
+		saveManifest(downloadPath, downloadableItems, result.Saved)
+		if pipeline.sums {
+			writeSums(downloadPath)
+		}
+		linkIntoPool(pipeline.pool, downloadPath, result.Saved)
+		fanOut(downloadPath, result.Saved, targets)
+		feedScreensaver(pipeline.screensaver, pipeline.screensaverSize, downloadPath, result.Saved)
+		writeIndexes(downloadPath, indexes, folderFiles(downloadPath, result.Saved))
+		takeSnapshot(pipeline.snapshots, downloadPath, pipeline.keepSnapshots)
+		if result.Failed == 0 && !result.Interrupted {
+			finishPending(downloadPath)
+		}
+	}
+	finishWrites()
+	if err != nil {
+		log.Printf("Sync aborted: %v; run sync -resume to finish it", err)
+		return 1
+	}
+	printResult(result)
+	if result.Interrupted {
+		report("Run sync -resume to finish the sync.", "Resume to finish the sync")
+		return 1
+	}
+	if result.Failed > 0 {
+		report("Run sync -resume to retry the failed items.", "Resume to retry failed items", "failed", result.Failed)
+		return 1
+	}
+	return 0
+}
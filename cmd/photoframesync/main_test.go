@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args      []string
+		command   string
+		rest      []string
+		ambiguous bool
+	}{
+		{nil, "sync", nil, false},
+		{[]string{"serve", "-folder", "/photos"}, "serve", []string{"-folder", "/photos"}, false},
+		{[]string{"-folder", "/photos"}, "sync", []string{"-folder", "/photos"}, false},
+		{[]string{"-container", "serve"}, "serve", []string{"-container"}, false},
+		{[]string{"-folder", "X", "-state-dir", "Y", "whoami"}, "whoami", []string{"-folder", "X", "-state-dir", "Y"}, false},
+		{[]string{"-folder", "gc", "verify"}, "", nil, true},
+	}
+	for _, tt := range tests {
+		command, rest, err := splitCommand(tt.args)
+		if tt.ambiguous {
+			if err == nil {
+				t.Errorf("splitCommand(%q) = %q, want an error", tt.args, command)
+			}
+			continue
+		}
+		if err != nil || command != tt.command || !slices.Equal(rest, tt.rest) {
+			t.Errorf("splitCommand(%q) = %q, %q, %v; want %q, %q", tt.args, command, rest, err, tt.command, tt.rest)
+		}
+	}
+}
+
+// touch creates each of the files, and the folders they are in, under dir.
+func touch(t *testing.T, dir string, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// present returns which of names exist under dir.
+func present(dir string, names ...string) []string {
+	var found []string
+	for _, name := range names {
+		if _, err := os.Lstat(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+			found = append(found, name)
+		}
+	}
+	return found
+}
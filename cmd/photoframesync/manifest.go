@@ -0,0 +1,176 @@
+// manifest.go
+//
+// Reporting selection changes against the folder's manifest, and updating it after a sync.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/picker"
+)
+
+// reportSelectionChanges prints which items were added to, removed from or changed in
+// the selection since the last sync into folder. With confirm set it then asks
+// whether to carry on, returning false if the user declines.
+func reportSelectionChanges(folder string, items picker.DownloadableMediaItems, confirm bool) bool {
+	previous, err := manifest.Load(folder)
+	if err != nil {
+		log.Printf("Unable to read manifest, skipping change report: %v", err)
+		return !confirm || askYesNo("Continue with the sync?")
+	}
+	diff := previous.Compare(items.MediaItems)
+	if diff.Empty() {
+		report("Selection unchanged since the last sync.", "Selection unchanged")
+		return true
+	}
+
+	report(fmt.Sprintf("Selection changes since the last sync: %d added, %d removed, %d changed",
+		len(diff.Added), len(diff.Removed), len(diff.Changed)),
+		"Selection changed", "added", len(diff.Added), "removed", len(diff.Removed), "changed", len(diff.Changed))
+	for _, entry := range diff.Added {
+		report("  + "+entry.OriginalFilename, "Selection added", "id", entry.ID, "file", entry.OriginalFilename)
+	}
+	for _, entry := range diff.Removed {
+		report("  - "+entry.OriginalFilename, "Selection removed", "id", entry.ID, "file", entry.OriginalFilename)
+	}
+	for _, change := range diff.Changed {
+		report(fmt.Sprintf("  ~ %s (was %s, %s)", change.New.OriginalFilename, change.Old.OriginalFilename, change.Old.CreateTime),
+			"Selection changed item", "id", change.New.ID, "file", change.New.OriginalFilename,
+			"was_file", change.Old.OriginalFilename, "was_created", change.Old.CreateTime)
+	}
+	return !confirm || askYesNo("Continue with the sync?")
+}
+
+// askYesNo asks question on stdin, treating anything but y or yes as no. In plain
+// and JSON mode the question goes to stderr to keep stdout machine-readable.
+func askYesNo(question string) bool {
+	prompt := os.Stdout
+	if plainOutput || jsonOutput {
+		prompt = os.Stderr
+	}
+	fmt.Fprintf(prompt, "%s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// saveManifest records the selection for the next sync to compare against, noting
+// the filenames of the items that are now in folder and the checksums verify checks
+// them against.
+func saveManifest(folder string, items picker.DownloadableMediaItems, saved []download.Item) {
+	previous, err := manifest.Load(folder)
+	if err != nil {
+		log.Printf("Unable to read previous manifest, checksums will be recomputed: %v", err)
+		previous = &manifest.Manifest{}
+	}
+	recorded := make(map[string]manifest.Entry, len(previous.Items))
+	for _, entry := range previous.Items {
+		recorded[entry.ID] = entry
+	}
+	savedByID := make(map[string]download.Item, len(saved))
+	for _, item := range saved {
+		savedByID[item.Id] = item
+	}
+
+	m := &manifest.Manifest{Updated: time.Now()}
+	for _, item := range items.MediaItems {
+		entry := manifest.EntryFor(item)
+		if s, ok := savedByID[item.Id]; ok {
+			entry.Filename, entry.Numbered = s.Filename, s.Numbered
+			entry.Variant = strings.TrimPrefix(s.URL, s.MediaFile.BaseUrl)
+			entry.Size, entry.SHA256 = fileChecksum(folder, s, recorded[item.Id])
+		} else if old := recorded[item.Id]; old.Archived {
+			// Still selected, and still in the archive
+			entry.Filename, entry.Variant, entry.Archived = old.Filename, old.Variant, true
+			entry.Size, entry.SHA256 = old.Size, old.SHA256
+		}
+		m.Items = append(m.Items, entry)
+	}
+	// Files made locally stay until removed by hand
+	for _, entry := range previous.Items {
+		if entry.Local() {
+			m.Items = append(m.Items, entry)
+		}
+	}
+	if err := m.Save(folder); err != nil {
+		log.Printf("Unable to save manifest: %v", err)
+		return
+	}
+	removeSuperseded(folder, previous, m, items)
+}
+
+// removeSuperseded deletes the files previous numbered for -order that m no
+// longer has, since frames playing files alphabetically would show them among
+// the current numbers. Files of items still selected but not saved this time
+// stay until a sync saves them.
+func removeSuperseded(folder string, previous, m *manifest.Manifest, items picker.DownloadableMediaItems) {
+	inUse := make(map[string]bool, len(m.Items))
+	saved := make(map[string]bool, len(m.Items))
+	for _, entry := range m.Items {
+		if entry.Filename != "" {
+			inUse[entry.Filename] = true
+			saved[entry.ID] = true
+		}
+	}
+	selected := make(map[string]bool, len(items.MediaItems))
+	for _, item := range items.MediaItems {
+		selected[item.Id] = true
+	}
+	for _, entry := range previous.Items {
+		if !entry.Numbered || entry.Archived || entry.Filename == "" || inUse[entry.Filename] {
+			continue
+		}
+		if selected[entry.ID] && !saved[entry.ID] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(folder, entry.Filename)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Unable to remove %s: %v", entry.Filename, err)
+			continue
+		}
+		report("Removed superseded "+entry.Filename, "Removed superseded file", "file", entry.Filename)
+	}
+}
+
+// writeSums writes the SHA256SUMS file of folder from its manifest.
+func writeSums(folder string) {
+	m, err := manifest.Load(folder)
+	if err == nil {
+		err = m.WriteSums(folder)
+	}
+	if err != nil {
+		log.Printf("Unable to write %s: %v", manifest.SumsFileName, err)
+	}
+}
+
+// fileChecksum returns the size and checksum to record for a saved item. A file
+// fetched by this run has them already; one left in place keeps those recorded when
+// it was saved, so that later damage is not mistaken for the original. Files that
+// predate checksums are hashed as they are now.
+func fileChecksum(folder string, item download.Item, previous manifest.Entry) (int64, string) {
+	if item.SHA256 != "" {
+		return item.Size, item.SHA256
+	}
+	if previous.Filename == item.Filename && previous.SHA256 != "" {
+		return previous.Size, previous.SHA256
+	}
+	path := filepath.Join(folder, item.Filename)
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, ""
+	}
+	digest, err := manifest.FileDigest(path)
+	if err != nil {
+		log.Printf("Unable to checksum %s: %v", item.Filename, err)
+		return 0, ""
+	}
+	return info.Size(), digest
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/picker"
+)
+
+func TestRemoveSuperseded(t *testing.T) {
+	tests := []struct {
+		name     string
+		previous manifest.Entry
+		current  []manifest.Entry
+		selected bool
+		removed  bool
+	}{
+		{"renumbered", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true},
+			[]manifest.Entry{{ID: "a", Filename: "002-a.jpg", Numbered: true}}, true, true},
+		{"deselected", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true}, nil, false, true},
+		{"-order dropped", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true},
+			[]manifest.Entry{{ID: "a", Filename: "a.jpg"}}, true, true},
+		{"number taken by another item", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true},
+			[]manifest.Entry{{ID: "b", Filename: "001-a.jpg", Numbered: true}}, false, false},
+		{"selected but not saved", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true},
+			[]manifest.Entry{{ID: "a"}}, true, false},
+		{"not numbered", manifest.Entry{ID: "a", Filename: "a.jpg"}, nil, false, false},
+		{"archived", manifest.Entry{ID: "a", Filename: "001-a.jpg", Numbered: true, Archived: true}, nil, false, false},
+	}
+	for _, tt := range tests {
+		folder := t.TempDir()
+		touch(t, folder, tt.previous.Filename)
+		var items picker.DownloadableMediaItems
+		if tt.selected {
+			items.MediaItems = []picker.PickedMediaItem{{Id: tt.previous.ID}}
+		}
+		previous := &manifest.Manifest{Items: []manifest.Entry{tt.previous}}
+		removeSuperseded(folder, previous, &manifest.Manifest{Items: tt.current}, items)
+
+		removed := !slices.Contains(present(folder, tt.previous.Filename), tt.previous.Filename)
+		if removed != tt.removed {
+			t.Errorf("%s: removed %s = %v, want %v", tt.name, tt.previous.Filename, removed, tt.removed)
+		}
+	}
+}
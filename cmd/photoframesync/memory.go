@@ -0,0 +1,96 @@
+// memory.go
+//
+// Memory tuning for small devices such as 256-512MB single board computers.
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// lowMemory is set by -low-memory, for devices with 512MB of RAM or less.
+var lowMemory = false
+
+// lowMemoryLimit is the soft memory limit applied in low-memory mode when neither
+// -memory-limit nor GOMEMLIMIT is set.
+const lowMemoryLimit = 128 << 20
+
+// lowMemoryPageSize is the media item listing page size used in low-memory mode.
+const lowMemoryPageSize = 25
+
+// parseByteSize parses sizes such as "512MiB", "256MB", "1G" or a plain byte count.
+// Suffixes are matched in any case, so "512mib" and "1g" work too.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	units := []struct {
+		suffix     string
+		multiplier int64
+	}{
+		{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30},
+		{"KB", 1000}, {"MB", 1000 * 1000}, {"GB", 1000 * 1000 * 1000},
+		{"K", 1 << 10}, {"M", 1 << 20}, {"G", 1 << 30},
+		{"B", 1},
+	}
+	multiplier := int64(1)
+	upper := strings.ToUpper(s)
+	for _, unit := range units {
+		if strings.HasSuffix(upper, unit.suffix) {
+			s = strings.TrimSpace(s[:len(s)-len(unit.suffix)])
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(n * float64(multiplier)), nil
+}
+
+// formatBytes formats a byte count for people, e.g. "3.2 MB".
+func formatBytes(n int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	v, unit := float64(n), 0
+	for v >= 1000 && unit < len(units)-1 {
+		v /= 1000
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%d B", n)
+	}
+	return fmt.Sprintf("%.1f %s", v, units[unit])
+}
+
+// applyMemorySettings configures the Go runtime. An explicit
+// limit always wins; otherwise low-memory mode sets a conservative soft limit unless
+// GOMEMLIMIT is already in the environment, which the runtime honours by itself.
+func applyMemorySettings(limit string) error {
+	if limit != "" {
+		bytes, err := parseByteSize(limit)
+		if err != nil {
+			return err
+		}
+		debug.SetMemoryLimit(bytes)
+	} else if lowMemory && os.Getenv("GOMEMLIMIT") == "" {
+		debug.SetMemoryLimit(lowMemoryLimit)
+	}
+
+	if lowMemory {
+		// Collect more eagerly to stay well under the limit
+		debug.SetGCPercent(50)
+	}
+	return nil
+}
+
+// refuseInLowMemory returns an error for a feature that decodes whole photos when
+// low-memory mode is on: a 24 megapixel photo takes about 100MB once decoded,
+// more than such a device can spare.
+func refuseInLowMemory(feature string) error {
+	if lowMemory {
+		return fmt.Errorf("%s decodes whole photos, which -low-memory does not allow", feature)
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+// network.go
+//
+// The HTTP client every outbound connection goes through: OAuth, the Picker API
+// and downloads.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/transport"
+)
+
+// debugHTTP logs every request and response, with credentials redacted.
+var debugHTTP = false
+
+// caCertFile is a PEM bundle of extra certificate authorities to trust, e.g. that
+// of an SSL-inspecting corporate proxy.
+var caCertFile = ""
+
+// tlsMinVersion is the oldest TLS version outbound connections accept.
+var tlsMinVersion = "1.2"
+
+// proxyURL is the proxy for all outbound connections; empty means the one given by
+// HTTP_PROXY and HTTPS_PROXY, if any.
+var proxyURL = ""
+
+// userAgent identifies the app to Google, with its version when it was built from
+// a tagged module.
+func userAgent() string {
+	ua := "PhotoFrameSync"
+	if version := currentVersion(); version != "" {
+		ua += "/" + version
+	}
+	return ua + " (+https://github.com/amccormick21/PhotoFrameSync)"
+}
+
+// httpClient returns the base client that authorized clients are built on.
+func httpClient() *http.Client {
+	rt := http.DefaultTransport.(*http.Transport).Clone()
+	tlsConfig, err := newTLSConfig()
+	if err != nil {
+		log.Fatal(err)
+	}
+	rt.TLSClientConfig = tlsConfig
+	if proxyURL != "" {
+		proxy, err := parseProxy(proxyURL)
+		if err != nil {
+			log.Fatalf("Invalid -proxy: %v", err)
+		}
+		noProxy := os.Getenv("NO_PROXY")
+		if noProxy == "" {
+			noProxy = os.Getenv("no_proxy")
+		}
+		rt.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypassProxy(req.URL.Hostname(), noProxy) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	middleware := []transport.Middleware{transport.UserAgent(userAgent())}
+	if debugHTTP {
+		middleware = append(middleware, transport.Log(slog.Default()))
+	}
+	if auditEnabled {
+		middleware = append(middleware, auditRequests())
+	}
+	base := transport.Chain(transport.Transport(rt), middleware...)
+	return &http.Client{Transport: transport.RoundTripper(base)}
+}
+
+// newTLSConfig builds the TLS settings of outbound connections from -tls-min and
+// -ca-cert. Extra authorities are trusted on top of the system's, not instead.
+func newTLSConfig() (*tls.Config, error) {
+	config := &tls.Config{}
+	switch tlsMinVersion {
+	case "1.2":
+		config.MinVersion = tls.VersionTLS12
+	case "1.3":
+		config.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid -tls-min %q: expected 1.2 or 1.3", tlsMinVersion)
+	}
+	if caCertFile == "" {
+		return config, nil
+	}
+	pem, err := os.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read -ca-cert: %v", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		// Minimal systems may have no certificate store at all
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no PEM certificates found in %s", caCertFile)
+	}
+	config.RootCAs = pool
+	return config, nil
+}
+
+// probeURL is requested to tell whether Google can be reached.
+const probeURL = "https://photospicker.googleapis.com/"
+
+// probeInterval is how often waitOnline checks the network.
+const probeInterval = 30 * time.Second
+
+// probeClient is the client online probes with, built on first use, once the
+// flags it depends on are parsed, and then kept so that each probe can reuse its
+// connections.
+var probeClient = sync.OnceValue(httpClient)
+
+// online reports whether Google can be reached through the configured proxy. Any
+// HTTP response counts, whatever its status.
+func online(ctx context.Context) bool {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, probeURL, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := probeClient().Do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// waitOnline waits until Google can be reached, reporting false if deadline passes
+// or ctx is cancelled first.
+func waitOnline(ctx context.Context, deadline time.Time) bool {
+	for time.Now().Before(deadline) {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-time.After(min(probeInterval, time.Until(deadline))):
+		}
+		if online(ctx) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy parses a proxy URL. HTTP, HTTPS and SOCKS5 proxies are supported.
+// SOCKS5 proxies resolve host names themselves, so they also work on networks
+// without DNS of their own.
+func parseProxy(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported scheme in %q: expected http, https, socks5 or socks5h", s)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("no host in %q", s)
+	}
+	return u, nil
+}
+
+// bypassProxy reports whether host matches noProxy, a comma-separated list in the
+// NO_PROXY format: host names, which also match their subdomains, IP addresses,
+// CIDR ranges, or * for every host.
+func bypassProxy(host, noProxy string) bool {
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		switch {
+		case entry == "":
+		case entry == "*":
+			return true
+		case ip != nil:
+			if _, network, err := net.ParseCIDR(entry); err == nil && network.Contains(ip) {
+				return true
+			}
+			if ip.Equal(net.ParseIP(entry)) {
+				return true
+			}
+		default:
+			entry = strings.TrimPrefix(entry, ".")
+			host = strings.ToLower(host)
+			if host == entry || strings.HasSuffix(host, "."+entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
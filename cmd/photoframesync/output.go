@@ -0,0 +1,42 @@
+// output.go
+//
+// Plain and JSON output modes, in which everything a command prints is a key=value
+// line or a JSON object that scripts, log collectors and screen readers can rely on.
+package main
+
+import (
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// plainOutput replaces the human-oriented output with key=value lines on stdout.
+var plainOutput = false
+
+// jsonOutput replaces the human-oriented output with one JSON object per line on
+// stdout, each with the time, level, msg and the message's own keys.
+var jsonOutput = false
+
+// setupOutput routes logging through a key=value or JSON handler in plain or JSON
+// mode. Messages from the log package then come out in the same format.
+func setupOutput() {
+	switch {
+	case plainOutput && jsonOutput:
+		log.Fatal("-plain and -json cannot be used together.")
+	case plainOutput:
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	case jsonOutput:
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stdout, nil)))
+	}
+}
+
+// report prints text, a line for people, or in plain or JSON mode logs msg with
+// the key/value pairs in args instead.
+func report(text string, msg string, args ...any) {
+	if plainOutput || jsonOutput {
+		slog.Info(msg, args...)
+		return
+	}
+	fmt.Println(text)
+}
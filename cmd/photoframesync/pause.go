@@ -0,0 +1,125 @@
+// pause.go
+//
+// Pausing a sync in progress, such as when the frame host needs its bandwidth for
+// a video call. A sync is paused by a marker file in its folder, which the pause
+// command, SIGUSR1 and serve's /sync/pause all create; downloads already under
+// way finish, and the rest wait until the marker is removed. The selection stays
+// recorded in the folder meanwhile, so a paused sync that is stopped can be
+// finished with sync -resume.
+package main
+
+import (
+	"crypto/subtle"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pausedFileName is the marker inside a synced folder that pauses its downloads.
+const pausedFileName = ".photosync-paused"
+
+// pauseRecheck is how often paused downloads check whether they were resumed,
+// much more often than other holds so that resuming takes effect at once.
+const pauseRecheck = 2 * time.Second
+
+// paused reports whether downloads into folder are paused.
+func paused(folder string) bool {
+	_, err := os.Stat(filepath.Join(folder, pausedFileName))
+	return err == nil
+}
+
+// setPaused pauses or resumes downloads into folder.
+func setPaused(folder string, pause bool) error {
+	path := filepath.Join(folder, pausedFileName)
+	if !pause {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(time.Now().Format(time.RFC3339)+"\n"), 0o644)
+}
+
+// runPause pauses, or with resume set resumes, the sync into a folder.
+func runPause(args []string, resume bool) {
+	name := "pause"
+	if resume {
+		name = "resume"
+	}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder whose downloads to "+name)
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify the synced folder with -folder.")
+	}
+	if _, err := os.Stat(*folderPtr); err != nil {
+		log.Fatalf("Unable to use %s: %v", *folderPtr, err)
+	}
+	if err := setPaused(*folderPtr, !resume); err != nil {
+		log.Fatalf("Unable to %s downloads: %v", name, err)
+	}
+	if resume {
+		report(fmt.Sprintf("Resumed downloads into %s.", *folderPtr), "Resumed", "folder", *folderPtr)
+		return
+	}
+	report(fmt.Sprintf("Paused downloads into %s; those under way will finish. Run resume to carry on.", *folderPtr),
+		"Paused", "folder", *folderPtr)
+}
+
+// pauseSync and resumeSync let serve's syncs be paused over HTTP, e.g. from a
+// home automation system.
+func (s *familyServer) pauseSync(w http.ResponseWriter, r *http.Request) {
+	if s.controlAllowed(w, r) {
+		s.setPaused(w, true)
+	}
+}
+
+func (s *familyServer) resumeSync(w http.ResponseWriter, r *http.Request) {
+	if s.controlAllowed(w, r) {
+		s.setPaused(w, false)
+	}
+}
+
+// controlAllowed reports whether r may pause or resume syncs, writing a 403 if
+// not. With a -control-token the request must carry it, as a bearer token or a
+// token parameter; without one only requests from the host itself are allowed.
+func (s *familyServer) controlAllowed(w http.ResponseWriter, r *http.Request) bool {
+	var allowed bool
+	if s.controlToken == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		ip := net.ParseIP(host)
+		allowed = err == nil && ip != nil && ip.IsLoopback()
+	} else {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok {
+			token = r.URL.Query().Get("token")
+		}
+		allowed = subtle.ConstantTimeCompare([]byte(token), []byte(s.controlToken)) == 1
+	}
+	if !allowed {
+		http.Error(w, "Forbidden: pass the -control-token", http.StatusForbidden)
+	}
+	return allowed
+}
+
+func (s *familyServer) setPaused(w http.ResponseWriter, pause bool) {
+	if err := setPaused(s.folder, pause); err != nil {
+		log.Printf("Unable to pause or resume downloads: %v", err)
+		http.Error(w, "Unable to pause or resume downloads", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
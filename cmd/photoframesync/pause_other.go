@@ -0,0 +1,7 @@
+//go:build !unix
+
+package main
+
+// pauseOnSignal does nothing, as there is no SIGUSR1 on this platform; use the
+// pause command instead.
+func pauseOnSignal(folder string) {}
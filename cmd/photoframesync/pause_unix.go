@@ -0,0 +1,27 @@
+//go:build unix
+
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// pauseOnSignal toggles pausing downloads into folder each time the process
+// receives SIGUSR1.
+func pauseOnSignal(folder string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1)
+	go func() {
+		for range signals {
+			pause := !paused(folder)
+			if err := setPaused(folder, pause); err != nil {
+				log.Printf("Unable to pause or resume downloads: %v", err)
+			} else if pause {
+				report("Pausing downloads; those under way will finish.", "Pausing downloads")
+			}
+		}
+	}()
+}
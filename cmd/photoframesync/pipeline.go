@@ -0,0 +1,577 @@
+// pipeline.go
+//
+// Command line configuration of the download pipeline's filters and transforms.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/enhance"
+	"PhotoSync/pkg/frameindex"
+	"PhotoSync/pkg/hooks"
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/retry"
+	"PhotoSync/pkg/transport"
+	"PhotoSync/pkg/variant"
+)
+
+// pipelineFlags holds the options that shape which items are downloaded and how.
+type pipelineFlags struct {
+	mediaType string
+	since     string
+	until     string
+
+	minSize       string
+	minMegapixels float64
+	maxFileSize   string
+
+	orientation     string
+	aspectRatio     string
+	aspectTolerance float64
+
+	limit           int
+	skipScreenshots bool
+
+	order     string
+	orderSeed int64
+
+	expressions stringList
+
+	include stringList
+	exclude stringList
+
+	resize string
+	crop   string
+	rename string
+
+	beforeSync  string
+	afterSync   string
+	beforeItem  string
+	afterItem   string
+	hookTimeout time.Duration
+
+	replaceChanged bool
+	keepVersions   int
+	skipUnchanged  bool
+	toSRGB         bool
+	enhance        bool
+
+	concurrency     int
+	downloadRetries int
+	onFailure       string
+	maxFailures     int
+	window          string
+	avoidMetered    bool
+	meteredMaxSize  string
+
+	targets stringList
+	pool    string
+
+	snapshots     string
+	keepSnapshots int
+	sums          bool
+	indexes       string
+
+	screensaver     string
+	screensaverSize string
+
+	storageCheck string
+	smart        bool
+}
+
+// registerPipelineFlags adds the download pipeline options to fs.
+func registerPipelineFlags(fs *flag.FlagSet) *pipelineFlags {
+	p := &pipelineFlags{}
+	fs.StringVar(&p.mediaType, "type", "all", "Media types to download: photo, video or all")
+	fs.StringVar(&p.since, "since", "", "Only download items captured on or after this date: YYYY-MM-DD or an age such as 30d, 6m or 2y")
+	fs.StringVar(&p.until, "until", "", "Only download items captured before this date: YYYY-MM-DD or an age such as 30d, 6m or 2y")
+	fs.StringVar(&p.minSize, "min-size", "", "Skip items smaller than WIDTHxHEIGHT in either orientation, e.g. 1280x720")
+	fs.Float64Var(&p.minMegapixels, "min-megapixels", 0, "Skip items with fewer megapixels than this")
+	fs.StringVar(&p.maxFileSize, "max-file-size", "", "Skip items larger than this, e.g. 50MB")
+	fs.StringVar(&p.orientation, "orientation", "any", "Only download landscape or portrait items, or any")
+	fs.StringVar(&p.aspectRatio, "aspect-ratio", "", "Only download items close to this aspect ratio, e.g. 16:9 or 1.6")
+	fs.Float64Var(&p.aspectTolerance, "aspect-tolerance", 0.15, "How far, as a fraction, an item's aspect ratio may be from -aspect-ratio")
+	fs.IntVar(&p.limit, "limit", 0, "Only download the N most recently captured items that pass the other filters; 0 means no limit")
+	fs.BoolVar(&p.skipScreenshots, "skip-screenshots", false, "Skip photos that look like screenshots, scanned documents or memes, and list them at the end")
+	fs.StringVar(&p.order, "order", "", "Prefix filenames with a number so frames that sort by name play them in this order: picker, chronological or shuffle")
+	fs.Int64Var(&p.orderSeed, "order-seed", 1, "Seed for -order shuffle; the same seed gives the same order")
+	fs.Var(&p.expressions, "filter", `Only download items matching an expression, e.g. 'type == "PHOTO" && width >= 1600'; may be repeated`)
+	fs.Var(&p.include, "include", "Only download files whose names match this glob, or regular expression if prefixed with re:; may be repeated")
+	fs.Var(&p.exclude, "exclude", "Skip files whose names match this glob, e.g. Screenshot*, or regular expression if prefixed with re:; may be repeated")
+	fs.StringVar(&p.resize, "resize", "", "Have Google scale photos to fit within WIDTHxHEIGHT, e.g. 1920x1080")
+	fs.StringVar(&p.crop, "crop", "", "Have Google scale and crop photos to exactly WIDTHxHEIGHT, e.g. 1920x1080")
+	fs.StringVar(&p.rename, "rename", "", "Rename files with a template, e.g. {{.Date}}_{{.Filename}}")
+	fs.StringVar(&p.beforeSync, "hook-before-sync", "", "Shell command to run before downloading; the sync is aborted if it fails")
+	fs.StringVar(&p.afterSync, "hook-after-sync", "", "Shell command to run after downloading, e.g. to refresh the frame")
+	fs.StringVar(&p.beforeItem, "hook-before-item", "", "Shell command to run before each item; the item is skipped if it fails")
+	fs.StringVar(&p.afterItem, "hook-after-item", "", "Shell command to run after each item, e.g. to convert it")
+	fs.DurationVar(&p.hookTimeout, "hook-timeout", time.Minute, "Maximum run time of each hook command")
+	fs.BoolVar(&p.replaceChanged, "replace-changed", false, "Download existing files again and replace those that changed in Google Photos")
+	fs.IntVar(&p.keepVersions, "keep-versions", 3, "With -replace-changed, how many previous versions of each file to keep in the .versions folder; 0 keeps none")
+	fs.BoolVar(&p.skipUnchanged, "skip-unchanged", false, "With -replace-changed, only download items whose metadata changed since the last sync; edits that keep a photo's size are then missed")
+	fs.BoolVar(&p.toSRGB, "to-srgb", false, "Convert downloaded photos in wide-gamut colour spaces such as Display P3 to sRGB, so they do not look flat on the frame")
+	fs.BoolVar(&p.enhance, "enhance", false, "Correct white balance, levels and saturation of downloaded photos, for frames with washed-out panels")
+	fs.IntVar(&p.concurrency, "concurrency", 1, "Number of items to download at once")
+	fs.IntVar(&p.downloadRetries, "download-retries", 1, "Maximum attempts for each download that fails with a network or server error")
+	fs.StringVar(&p.onFailure, "on-failure", "continue", "What to do when items fail: continue with the rest, abort after -max-failures, or rollback the files the sync added")
+	fs.IntVar(&p.maxFailures, "max-failures", 1, "With -on-failure abort or rollback, how many items may fail before the sync stops")
+	fs.StringVar(&p.window, "download-window", "", "Only download between these local times, e.g. 01:00-06:00; downloads wait for the window to open")
+	fs.BoolVar(&p.avoidMetered, "avoid-metered", false, "Hold downloads larger than -metered-max-size while the connection is metered, e.g. LTE or a phone hotspot, until it is not")
+	fs.StringVar(&p.meteredMaxSize, "metered-max-size", "25MB", "With -avoid-metered, the largest item downloaded over a metered connection, e.g. 10MB")
+	fs.Var(&p.targets, "target", "Another frame folder to copy downloads to, optionally with its size, crop and format, e.g. /mnt/eink,800x480,crop,png; may be repeated")
+	fs.StringVar(&p.pool, "pool", "", "Folder on the same filesystem keeping one copy of each photo, hardlinked into every frame folder synced with the same -pool")
+	fs.StringVar(&p.snapshots, "snapshots", "", "Folder to keep a dated, hardlinked snapshot of the frame folder in after each sync, with a current symlink to the latest")
+	fs.IntVar(&p.keepSnapshots, "keep-snapshots", 0, "With -snapshots, remove all but this many of the newest snapshots; 0 keeps them all")
+	fs.BoolVar(&p.sums, "sha256sums", false, "Write a SHA256SUMS file of the folder's photos after each sync, for sha256sum -c or verify -sums")
+	fs.StringVar(&p.indexes, "index", "", "Index files to write into the folder after each sync for the frame's model, comma separated: "+strings.Join(frameindex.Names(), ", "))
+	fs.StringVar(&p.screensaver, "screensaver", "", "Dedicated folder to keep as a copy of the frame's photos for a desktop screensaver or slideshow; pictures no longer selected are removed from it")
+	fs.StringVar(&p.screensaverSize, "screensaver-size", "", "With -screensaver, scale pictures down to fit the screen, e.g. 1920x1080")
+	fs.StringVar(&p.storageCheck, "storage-check", "warn", "Check the folder's storage for free inodes and filesystem errors before downloading: warn, abort the sync, or off")
+	fs.BoolVar(&p.smart, "smart", false, "With -storage-check, also ask smartctl whether the disk or card reader holding the folder is failing")
+	return p
+}
+
+// newDownloader builds a Downloader for folder with the configured pipeline.
+func (p *pipelineFlags) newDownloader(client transport.Doer, folder string) (*download.Downloader, error) {
+	var filters []download.ItemFilter
+	var transforms []download.ItemTransform
+	var stages []download.SelectionStage
+
+	// Archived items stay in the archive rather than coming back to the frame
+	previous, err := manifest.Load(folder)
+	if err == nil {
+		if archived := previous.ArchivedIDs(); len(archived) > 0 {
+			filters = append(filters, download.FilterFunc(func(item *download.Item) (bool, string) {
+				return !archived[item.Id], "archived"
+			}))
+		}
+	}
+
+	switch p.mediaType {
+	case "all":
+	case "photo":
+		filters = append(filters, download.OnlyTypes(picker.MediaTypePhoto))
+	case "video":
+		filters = append(filters, download.OnlyTypes(picker.MediaTypeVideo))
+	default:
+		return nil, fmt.Errorf("invalid -type %q: expected photo, video or all", p.mediaType)
+	}
+
+	if p.since != "" || p.until != "" {
+		now := time.Now()
+		since, err := parseDateBound(p.since, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -since: %v", err)
+		}
+		until, err := parseDateBound(p.until, now)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -until: %v", err)
+		}
+		filters = append(filters, download.CreatedBetween(since, until))
+	}
+
+	if p.minSize != "" || p.minMegapixels > 0 {
+		var width, height int
+		if p.minSize != "" {
+			var err error
+			width, height, err = parseDimensions(p.minSize)
+			if err != nil {
+				return nil, fmt.Errorf("invalid -min-size: %v", err)
+			}
+		}
+		filters = append(filters, download.MinResolution(width, height, p.minMegapixels))
+	}
+
+	switch p.orientation {
+	case "any":
+	case download.Landscape, download.Portrait:
+		filters = append(filters, download.OnlyOrientation(p.orientation))
+	default:
+		return nil, fmt.Errorf("invalid -orientation %q: expected landscape, portrait or any", p.orientation)
+	}
+	if p.aspectRatio != "" {
+		ratio, err := parseAspectRatio(p.aspectRatio)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -aspect-ratio: %v", err)
+		}
+		filters = append(filters, download.AspectRatio(ratio, p.aspectTolerance))
+	}
+
+	if len(p.include) > 0 || len(p.exclude) > 0 {
+		match, err := download.MatchFilenames(p.include, p.exclude)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, match)
+	}
+
+	for _, src := range p.expressions {
+		filter, err := download.Expression(src)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, filter)
+	}
+	if p.skipScreenshots {
+		filters = append(filters, download.SkipScreenshots())
+	}
+	if p.limit > 0 {
+		stages = append(stages, download.Newest(p.limit))
+	}
+
+	if p.order != "" {
+		order, err := download.NumberedOrder(p.order, p.orderSeed)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -order: %v", err)
+		}
+		stages = append(stages, order)
+	}
+
+	if p.resize != "" && p.crop != "" {
+		return nil, fmt.Errorf("-resize and -crop cannot be used together")
+	}
+	if p.resize != "" {
+		width, height, err := parseDimensions(p.resize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -resize: %v", err)
+		}
+		transforms = append(transforms, download.MaxDimensions(width, height))
+	}
+	if p.crop != "" {
+		width, height, err := parseDimensions(p.crop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -crop: %v", err)
+		}
+		transforms = append(transforms, download.CropTo(width, height))
+	}
+	if p.rename != "" {
+		rename, err := download.Rename(p.rename)
+		if err != nil {
+			return nil, err
+		}
+		transforms = append(transforms, rename)
+	}
+
+	registry := &hooks.Registry{}
+	for stage, command := range map[hooks.Stage]string{
+		hooks.BeforeSync: p.beforeSync,
+		hooks.AfterSync:  p.afterSync,
+		hooks.BeforeItem: p.beforeItem,
+		hooks.AfterItem:  p.afterItem,
+	} {
+		if command != "" {
+			registry.Register(stage, hooks.Command{Command: command, Timeout: p.hookTimeout})
+		}
+	}
+
+	var maxFileSize int64
+	if p.maxFileSize != "" {
+		var err error
+		maxFileSize, err = parseByteSize(p.maxFileSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -max-file-size: %v", err)
+		}
+	}
+
+	if p.keepVersions < 0 {
+		return nil, fmt.Errorf("-keep-versions cannot be negative")
+	}
+	decoding := []struct {
+		flag string
+		on   bool
+	}{{"-to-srgb", p.toSRGB}, {"-enhance", p.enhance}, {"-screensaver", p.screensaver != ""}}
+	for _, feature := range decoding {
+		if !feature.on {
+			continue
+		}
+		if err := refuseInLowMemory(feature.flag); err != nil {
+			return nil, err
+		}
+	}
+	concurrency := p.concurrency
+	if lowMemory && concurrency > 1 {
+		log.Printf("Downloading one item at a time rather than %d, because of -low-memory", concurrency)
+		concurrency = 1
+	}
+
+	opts := []download.Option{
+		download.WithFilters(filters...),
+		download.WithMaxFileSize(maxFileSize),
+		download.WithTransforms(transforms...),
+		download.WithSelectionStages(stages...),
+		download.WithHooks(registry),
+		download.WithConcurrency(concurrency),
+		download.WithRetryPolicy(retry.Policy{Attempts: p.downloadRetries, Backoff: time.Second}),
+	}
+	opts = append(opts, writeOptions()...)
+	switch p.onFailure {
+	case "continue":
+	case "abort", "rollback":
+		if p.maxFailures < 1 {
+			return nil, fmt.Errorf("-max-failures must be at least 1")
+		}
+		opts = append(opts, download.WithMaxFailures(p.maxFailures))
+	default:
+		return nil, fmt.Errorf("invalid -on-failure %q: expected continue, abort or rollback", p.onFailure)
+	}
+	switch p.storageCheck {
+	case "warn", "abort", "off":
+	default:
+		return nil, fmt.Errorf("invalid -storage-check %q: expected warn, abort or off", p.storageCheck)
+	}
+	// Every sync can be paused, so the gate is always there
+	gate := &downloadGate{folder: folder, avoidMetered: p.avoidMetered}
+	if p.avoidMetered {
+		gate.meteredMaxSize, err = parseByteSize(p.meteredMaxSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -metered-max-size: %v", err)
+		}
+	}
+	if p.window != "" {
+		window, err := parseWindow(p.window)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -download-window: %v", err)
+		}
+		gate.window = &window
+	}
+	opts = append(opts, download.WithGate(gate.wait), download.WithSizeGate(gate))
+	if p.replaceChanged {
+		opts = append(opts, download.WithReplaceChanged(p.keepVersions))
+	}
+	// Files numbered by an earlier -order move to their new numbers, or lose them
+	// once -order is dropped. The manifest is read at each sync, since serve keeps
+	// one downloader for many
+	opts = append(opts, download.WithPreviousNames(func() map[string]string { return numberedNames(folder) }))
+	if p.skipUnchanged && previous != nil {
+		opts = append(opts, download.WithUnchanged(unchangedSince(previous, folder)))
+	}
+	// Colours are converted first so that enhancement works on what the frame shows
+	if p.toSRGB {
+		opts = append(opts, download.WithProcessors(download.ProcessFunc(convertToSRGB)))
+	}
+	if p.enhance {
+		opts = append(opts, download.WithProcessors(download.ProcessFunc(enhancePhoto)))
+	}
+	return download.NewDownloader(client, folder, opts...), nil
+}
+
+// rollBack undoes a sync in which items failed when -on-failure is rollback,
+// deleting the files it added, and reports whether it did. Files it replaced keep
+// their new contents; with -keep-versions their previous versions are in the
+// versions folder.
+func (p *pipelineFlags) rollBack(folder string, result download.Result) bool {
+	if p.onFailure != "rollback" || result.Failed == 0 {
+		return false
+	}
+	removed := 0
+	for _, name := range result.Added {
+		if err := os.Remove(filepath.Join(folder, name)); err != nil && !os.IsNotExist(err) {
+			log.Printf("Unable to roll back %s: %v", name, err)
+			continue
+		}
+		removed++
+	}
+	report(fmt.Sprintf("%d items failed, rolled back the sync by removing the %d files it added.", result.Failed, removed),
+		"Rolled back", "failed", result.Failed, "removed", removed)
+	return true
+}
+
+// unchangedSince returns a check for items whose file in folder is the one the
+// manifest recorded: same fingerprint, same variant and still the recorded size.
+func unchangedSince(m *manifest.Manifest, folder string) func(item *download.Item) bool {
+	recorded := make(map[string]manifest.Entry, len(m.Items))
+	for _, entry := range m.Items {
+		recorded[entry.ID] = entry
+	}
+	return func(item *download.Item) bool {
+		entry, ok := recorded[item.Id]
+		if !ok || entry.Fingerprint == "" || entry.Filename != item.Filename {
+			return false
+		}
+		if entry.Fingerprint != manifest.Fingerprint(item.PickedMediaItem) || entry.DownloadURL(item.MediaFile.BaseUrl) != item.URL {
+			return false
+		}
+		info, err := os.Stat(filepath.Join(folder, item.Filename))
+		return err == nil && (entry.Size == 0 || info.Size() == entry.Size)
+	}
+}
+
+// numberedNames returns the names -order gave the items in folder's manifest, by ID.
+func numberedNames(folder string) map[string]string {
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return nil
+	}
+	names := make(map[string]string)
+	for _, entry := range m.Items {
+		if entry.Numbered && !entry.Archived && entry.Filename != "" {
+			names[entry.ID] = entry.Filename
+		}
+	}
+	return names
+}
+
+// fanOutTargets parses the -target flags.
+func (p *pipelineFlags) fanOutTargets() ([]target, error) {
+	var targets []target
+	for _, value := range p.targets {
+		t, err := parseTarget(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -target: %v", err)
+		}
+		if t.spec != (variant.Spec{}) {
+			if err := refuseInLowMemory("-target with a size or format"); err != nil {
+				return nil, err
+			}
+		}
+		targets = append(targets, t)
+	}
+	return targets, nil
+}
+
+// indexWriters parses the -index flag.
+func (p *pipelineFlags) indexWriters() ([]frameindex.Writer, error) {
+	writers, err := parseIndexes(p.indexes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -index: %v", err)
+	}
+	return writers, nil
+}
+
+// stringList is a flag that collects every value it is given.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// parseDimensions parses "WIDTHxHEIGHT".
+func parseDimensions(s string) (int, int, error) {
+	w, h, ok := strings.Cut(strings.ToLower(s), "x")
+	if !ok {
+		return 0, 0, fmt.Errorf("expected WIDTHxHEIGHT, got %q", s)
+	}
+	width, err := strconv.Atoi(w)
+	if err != nil || width <= 0 {
+		return 0, 0, fmt.Errorf("invalid width %q", w)
+	}
+	height, err := strconv.Atoi(h)
+	if err != nil || height <= 0 {
+		return 0, 0, fmt.Errorf("invalid height %q", h)
+	}
+	return width, height, nil
+}
+
+// parseAspectRatio parses "W:H" or a decimal width/height ratio.
+func parseAspectRatio(s string) (float64, error) {
+	var ratio float64
+	if w, h, ok := strings.Cut(s, ":"); ok {
+		width, werr := strconv.ParseFloat(w, 64)
+		height, herr := strconv.ParseFloat(h, 64)
+		if werr == nil && herr == nil && height > 0 {
+			ratio = width / height
+		}
+	} else {
+		ratio, _ = strconv.ParseFloat(s, 64)
+	}
+	if ratio <= 0 || math.IsInf(ratio, 0) || math.IsNaN(ratio) {
+		return 0, fmt.Errorf("expected W:H or a positive number, got %q", s)
+	}
+	return ratio, nil
+}
+
+// parseDateBound parses an absolute date (YYYY-MM-DD, in local time, or RFC 3339)
+// or an age counted back from now: a number followed by d, w, m or y. An empty string
+// is the zero time, i.e. no bound.
+func parseDateBound(s string, now time.Time) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n < 0 {
+		return time.Time{}, fmt.Errorf("expected YYYY-MM-DD or an age such as 30d, got %q", s)
+	}
+	switch s[len(s)-1] {
+	case 'd':
+		return now.AddDate(0, 0, -n), nil
+	case 'w':
+		return now.AddDate(0, 0, -7*n), nil
+	case 'm':
+		return now.AddDate(0, -n, 0), nil
+	case 'y':
+		return now.AddDate(-n, 0, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown age unit in %q: use d, w, m or y", s)
+}
+
+// printResult summarises a download run, listing anything dropped as a screenshot
+// so that false positives are easy to spot.
+func printResult(result download.Result) {
+	var screenshots []download.Skipped
+	for _, skipped := range result.Skipped {
+		if strings.HasPrefix(skipped.Reason, download.ScreenshotReason) {
+			screenshots = append(screenshots, skipped)
+		}
+	}
+	if len(screenshots) > 0 {
+		report(fmt.Sprintf("Excluded %d screenshots and documents:", len(screenshots)), "Excluded screenshots", "count", len(screenshots))
+		for _, skipped := range screenshots {
+			why := strings.TrimPrefix(skipped.Reason, download.ScreenshotReason+": ")
+			report(fmt.Sprintf("  %s (%s)", skipped.Filename, why), "Excluded screenshot", "file", skipped.Filename, "reason", why)
+		}
+	}
+	report(fmt.Sprintf("Done: %d downloaded, %d already present, %d skipped by filters, %d failed",
+		result.Downloaded, result.Existing, result.Filtered, result.Failed),
+		"Done", "downloaded", result.Downloaded, "existing", result.Existing, "filtered", result.Filtered, "failed", result.Failed)
+	if result.Aborted {
+		report(fmt.Sprintf("Stopped early after %d items failed.", result.Failed), "Stopped after failures", "failed", result.Failed)
+	}
+	if result.Interrupted {
+		report("Stopped before every item was downloaded.", "Interrupted")
+	}
+	if result.BytesSaved > 0 {
+		report(fmt.Sprintf("Saved %s of downloads by keeping files already present.", formatBytes(result.BytesSaved)),
+			"Bandwidth saved", "bytes", result.BytesSaved)
+	}
+}
+
+// convertToSRGB converts a downloaded photo to sRGB, passing over photos that are
+// already sRGB or cannot be converted.
+func convertToSRGB(path string) error {
+	if err := enhance.ToSRGB(path); err != nil && !errors.Is(err, enhance.ErrNoConversion) {
+		return err
+	}
+	return nil
+}
+
+// enhancePhoto applies the default corrections to a downloaded photo, passing over
+// videos and formats that cannot be re-encoded.
+func enhancePhoto(path string) error {
+	if err := enhance.File(path, enhance.Default); err != nil && !errors.Is(err, enhance.ErrUnsupported) {
+		return err
+	}
+	return nil
+}
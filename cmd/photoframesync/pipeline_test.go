@@ -0,0 +1,36 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"PhotoSync/pkg/download"
+)
+
+func TestRollBack(t *testing.T) {
+	files := []string{"kept.jpg", "added.jpg", "replaced.jpg"}
+	tests := []struct {
+		name      string
+		onFailure string
+		result    download.Result
+		want      bool
+		left      []string
+	}{
+		{"rollback after failures", "rollback", download.Result{Failed: 1, Added: []string{"added.jpg"}}, true, []string{"kept.jpg", "replaced.jpg"}},
+		{"added file already gone", "rollback", download.Result{Failed: 2, Added: []string{"gone.jpg"}}, true, files},
+		{"nothing failed", "rollback", download.Result{Added: []string{"added.jpg"}}, false, files},
+		{"continue", "continue", download.Result{Failed: 1, Added: []string{"added.jpg"}}, false, files},
+		{"abort", "abort", download.Result{Failed: 1, Added: []string{"added.jpg"}}, false, files},
+	}
+	for _, tt := range tests {
+		folder := t.TempDir()
+		touch(t, folder, files...)
+		p := &pipelineFlags{onFailure: tt.onFailure}
+		if got := p.rollBack(folder, tt.result); got != tt.want {
+			t.Errorf("%s: rollBack = %v, want %v", tt.name, got, tt.want)
+		}
+		if left := present(folder, files...); !slices.Equal(left, tt.left) {
+			t.Errorf("%s: left %q, want %q", tt.name, left, tt.left)
+		}
+	}
+}
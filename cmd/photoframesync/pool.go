@@ -0,0 +1,149 @@
+// pool.go
+//
+// A content-addressed pool shared by several frame folders on one host. Each
+// photo is stored once in the pool, under its checksum, and hardlinked into every
+// folder that shows it, so profiles with overlapping selections cost no extra disk.
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/manifest"
+)
+
+// poolMarkerName is the file marking a folder as a pool, so that gc -pool does not
+// delete from a folder given to it by mistake.
+const poolMarkerName = ".photosync-pool"
+
+// poolPath returns where the file with the given checksum and name is kept in pool.
+// The extension is kept so the pool can be browsed, and files are spread over
+// subfolders by the first byte of their checksum.
+func poolPath(pool, digest, filename string) string {
+	return filepath.Join(pool, digest[:2], digest+strings.ToLower(filepath.Ext(filename)))
+}
+
+// isPoolPath reports whether path, inside pool, is where poolPath puts files.
+func isPoolPath(pool, path string) bool {
+	rel, err := filepath.Rel(pool, path)
+	if err != nil {
+		return false
+	}
+	dir, name := filepath.Split(rel)
+	digest := strings.TrimSuffix(name, filepath.Ext(name))
+	if len(digest) != 64 || strings.ToLower(digest) != digest || filepath.Clean(dir) != digest[:2] {
+		return false
+	}
+	_, err = hex.DecodeString(digest)
+	return err == nil
+}
+
+// markPool creates pool if need be and marks it as a pool.
+func markPool(pool string) error {
+	if err := os.MkdirAll(pool, 0o755); err != nil {
+		return err
+	}
+	marker := filepath.Join(pool, poolMarkerName)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
+	}
+	return os.WriteFile(marker, []byte("PhotoSync pool: gc -pool removes the files here that no frame folder links to any more.\n"), 0o644)
+}
+
+// linkIntoPool makes each saved file in folder a hardlink to its copy in pool,
+// adding those the pool does not have yet. Files are only ever replaced by
+// renaming, never rewritten in place, so a later change in one folder cannot leak
+// into the others.
+func linkIntoPool(pool, folder string, saved []download.Item) {
+	if pool == "" {
+		return
+	}
+	if err := markPool(pool); err != nil {
+		log.Printf("Unable to set up the pool %s: %v", pool, err)
+		return
+	}
+	recorded := make(map[string]manifest.Entry)
+	if m, err := manifest.Load(folder); err == nil {
+		for _, entry := range m.Items {
+			recorded[entry.ID] = entry
+		}
+	}
+
+	added, linked, current := 0, 0, 0
+	for _, item := range saved {
+		_, digest := fileChecksum(folder, item, recorded[item.Id])
+		if digest == "" {
+			continue
+		}
+		path := filepath.Join(folder, item.Filename)
+		pooled := poolPath(pool, digest, item.Filename)
+		outcome, err := poolFile(path, pooled)
+		if err != nil {
+			var linkErr *os.LinkError
+			if errors.As(err, &linkErr) {
+				// Not worth trying the rest: the pool is on another filesystem, or
+				// this one, such as FAT32, has no hardlinks
+				log.Printf("Unable to hardlink %s into the pool, leaving the folder's copies alone: %v", item.Filename, err)
+				break
+			}
+			log.Printf("Unable to pool %s: %v", item.Filename, err)
+			continue
+		}
+		switch outcome {
+		case poolAdded:
+			added++
+		case poolLinked:
+			linked++
+		default:
+			current++
+		}
+	}
+	report(fmt.Sprintf("Pool: %d added, %d linked to existing copies, %d already linked", added, linked, current),
+		"Pooled", "pool", pool, "added", added, "linked", linked, "current", current)
+}
+
+// Outcomes of pooling a file.
+const (
+	poolCurrent = iota
+	poolAdded
+	poolLinked
+)
+
+// poolFile pools the file at path as pooled, replacing it with a link to the pool's
+// copy if the pool already has one.
+func poolFile(path, pooled string) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	pooledInfo, err := os.Stat(pooled)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(filepath.Dir(pooled), 0o755); err != nil {
+			return 0, err
+		}
+		return poolAdded, os.Link(path, pooled)
+	}
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(info, pooledInfo) {
+		return poolCurrent, nil
+	}
+	// Link beside the file and rename over it, so it never goes missing
+	tmp := path + ".part"
+	os.Remove(tmp)
+	if err := os.Link(pooled, tmp); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return poolLinked, nil
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// linkCount returns the number of hardlinks to the file described by info. It is
+// not available on this platform, so unused pool files are never found.
+func linkCount(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
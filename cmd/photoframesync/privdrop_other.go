@@ -0,0 +1,16 @@
+//go:build !unix
+
+package main
+
+import "errors"
+
+// runningAsRoot reports whether the process has root privileges. There is no
+// equivalent notion on this platform.
+func runningAsRoot() bool {
+	return false
+}
+
+// dropPrivileges is not supported on this platform.
+func dropPrivileges(spec string) error {
+	return errors.New("dropping privileges is not supported on this platform")
+}
@@ -0,0 +1,57 @@
+//go:build unix
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// runningAsRoot reports whether the process has root privileges.
+func runningAsRoot() bool {
+	return os.Geteuid() == 0
+}
+
+// dropPrivileges switches the process to the user (and optionally group) given as
+// "user" or "user:group". Supplementary groups are reset to the target group.
+func dropPrivileges(spec string) error {
+	userName, groupName, _ := strings.Cut(spec, ":")
+
+	u, err := user.Lookup(userName)
+	if err != nil {
+		return fmt.Errorf("unknown user %q: %v", userName, err)
+	}
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return fmt.Errorf("unsupported uid %q for user %q", u.Uid, userName)
+	}
+
+	gidString := u.Gid
+	if groupName != "" {
+		g, err := user.LookupGroup(groupName)
+		if err != nil {
+			return fmt.Errorf("unknown group %q: %v", groupName, err)
+		}
+		gidString = g.Gid
+	}
+	gid, err := strconv.Atoi(gidString)
+	if err != nil {
+		return fmt.Errorf("unsupported gid %q", gidString)
+	}
+
+	// Group changes must happen while we are still root
+	if err := syscall.Setgroups([]int{gid}); err != nil {
+		return fmt.Errorf("failed to set supplementary groups: %v", err)
+	}
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set gid %d: %v", gid, err)
+	}
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set uid %d: %v", uid, err)
+	}
+	return nil
+}
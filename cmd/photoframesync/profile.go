@@ -0,0 +1,178 @@
+// profile.go
+//
+// Named profiles in a JSON config file, so one install can sync several frames,
+// each with its own Google account, OAuth client and options. A config file looks like
+//
+//	{
+//	  "profiles": {
+//	    "grandma": {
+//	      "credentials": "grandma-credentials.json",
+//	      "flags": {"folder": "/photos/grandma", "crop": "1024x600", "exclude": ["Screenshot*"]}
+//	    }
+//	  }
+//	}
+//
+// Relative paths are resolved against the config file's folder.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// configFile is the profiles config; empty means photosync.json in the state folder.
+var configFile = ""
+
+// profileName selects a profile from the config file.
+var profileName = ""
+
+// credentialsFile is the OAuth client credentials file; empty means the file named by
+// GOOGLE_OAUTH_CREDENTIALS, or else credentials.json in the state folder.
+var credentialsFile = ""
+
+// googleCredentialsEnv names an OAuth client credentials file, in the style of
+// GOOGLE_APPLICATION_CREDENTIALS, for system-wide installs.
+const googleCredentialsEnv = "GOOGLE_OAUTH_CREDENTIALS"
+
+// tokenFile is where the OAuth token is cached; empty means token.json in the state
+// folder, or token-<profile>.json when a profile is selected.
+var tokenFile = ""
+
+// profile configures one frame.
+type profile struct {
+	// Credentials is the OAuth client credentials file, e.g. from a different
+	// Google Cloud project per household.
+	Credentials string `json:"credentials"`
+	// Token is where the profile's OAuth token is cached.
+	Token string `json:"token"`
+	// Flags sets command line flags by name. Values are strings, numbers, booleans
+	// or, for repeatable flags, lists.
+	Flags map[string]any `json:"flags"`
+}
+
+// config is the layout of the profiles config file.
+type config struct {
+	Profiles map[string]profile `json:"profiles"`
+}
+
+// credentialsPath returns the OAuth client credentials file in use.
+func credentialsPath() string {
+	if credentialsFile != "" {
+		return credentialsFile
+	}
+	if path := os.Getenv(googleCredentialsEnv); path != "" {
+		return path
+	}
+	return filepath.Join(stateDir, "credentials.json")
+}
+
+// configPath returns the profiles config file in use.
+func configPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	return filepath.Join(stateDir, "photosync.json")
+}
+
+// applyProfile loads the selected profile from path and applies it. Its flags only
+// fill in those not already given on the command line or in the environment.
+func applyProfile(fs *flag.FlagSet, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("unable to read config: %v", err)
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	p, ok := cfg.Profiles[profileName]
+	if !ok {
+		return fmt.Errorf("no profile %q in %s", profileName, path)
+	}
+
+	if credentialsFile == "" {
+		credentialsFile = resolveConfigPath(path, p.Credentials)
+	}
+	if tokenFile == "" {
+		tokenFile = resolveConfigPath(path, p.Token)
+	}
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+	for name, value := range p.Flags {
+		if fs.Lookup(name) == nil {
+			// Profiles are shared by all commands, which do not all have every flag
+			continue
+		}
+		if explicit[name] {
+			continue
+		}
+		values, isList := value.([]any)
+		if !isList {
+			values = []any{value}
+		}
+		for _, v := range values {
+			text, err := flagText(v)
+			if err == nil {
+				err = fs.Set(name, text)
+			}
+			if err != nil {
+				return fmt.Errorf("invalid value %v for %s in profile %q: %v", v, name, profileName, err)
+			}
+		}
+	}
+	return nil
+}
+
+// resolveConfigPath resolves a file named in the config at path against the
+// config's folder.
+func resolveConfigPath(path, file string) string {
+	if file == "" || filepath.IsAbs(file) {
+		return file
+	}
+	return filepath.Join(filepath.Dir(path), file)
+}
+
+// profileTokenPaths returns where the token of each profile in the config at
+// path is cached. A host without a config has no profiles.
+func profileTokenPaths(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read config: %v", err)
+	}
+	var cfg config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %s: %v", path, err)
+	}
+	var paths []string
+	for name, p := range cfg.Profiles {
+		if p.Token != "" {
+			paths = append(paths, resolveConfigPath(path, p.Token))
+		} else {
+			paths = append(paths, filepath.Join(stateDir, "token-"+name+".json"))
+		}
+	}
+	return paths, nil
+}
+
+// flagText converts a JSON value to the text form flags parse.
+func flagText(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	}
+	return "", fmt.Errorf("expected a string, number, boolean or list")
+}
@@ -0,0 +1,196 @@
+// purge.go
+//
+// The purge command, for decommissioning a frame host or handing it over: access
+// to the Google account is revoked, and the tokens, the audit log and everything
+// the sync keeps beside the photos are deleted. The photos themselves, and any
+// other folders the host filled, only go when asked for.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"PhotoSync/pkg/auth"
+	"PhotoSync/pkg/manifest"
+)
+
+// permissionsURL is where users can see and remove the app's access themselves.
+const permissionsURL = "https://myaccount.google.com/permissions"
+
+// syncDataNames are the files and folders the sync keeps inside a synced folder
+// beside the photos.
+var syncDataNames = []string{
+	manifest.FileName,
+	manifest.SumsFileName,
+	pendingFileName,
+	pausedFileName,
+	streamFileName,
+	resizedFolder,
+}
+
+// tokenPaths returns the token files auth has written on this host: the one in
+// use, the default one, and those of the profiles in the config. Only these
+// exact files are purged, never whatever else in the state folder looks like one.
+func tokenPaths() ([]string, error) {
+	paths, err := profileTokenPaths(configPath())
+	if err != nil {
+		return nil, err
+	}
+	paths = append(paths, tokenPath(), filepath.Join(stateDir, "token.json"))
+	var tokens []string
+	for _, path := range paths {
+		path = filepath.Clean(path)
+		if _, err := os.Stat(path); err == nil && !slices.Contains(tokens, path) {
+			tokens = append(tokens, path)
+		}
+	}
+	slices.Sort(tokens)
+	return tokens, nil
+}
+
+// runPurge revokes access and deletes local data.
+func runPurge(args []string) {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to remove the sync's own files from")
+	photosPtr := fs.Bool("photos", false, "Also delete everything in -folder, photos included")
+	var also stringList
+	fs.Var(&also, "also", "Another folder this host filled to delete entirely, such as a -snapshots, -screensaver, -stream-cache-dir or export folder; may be repeated")
+	credentialsPtr := fs.Bool("delete-credentials", false, "Also delete the OAuth client credentials file")
+	skipRevokePtr := fs.Bool("skip-revoke", false, "Delete the tokens without revoking them, e.g. when offline; remove access at "+permissionsURL+" instead")
+	yesPtr := fs.Bool("yes", false, "Do not ask for confirmation")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *photosPtr && *folderPtr == "" {
+		log.Fatal("-photos needs the folder to empty given with -folder.")
+	}
+	// Without a prompt, nothing is deleted from a state folder that is only the
+	// default, the current folder
+	stateDirGiven := common.container
+	fs.Visit(func(f *flag.Flag) {
+		stateDirGiven = stateDirGiven || f.Name == "state-dir"
+	})
+	if *yesPtr && !stateDirGiven {
+		log.Fatal("-yes deletes without asking, so give the folder holding the tokens with -state-dir.")
+	}
+
+	tokens, err := tokenPaths()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var remove []string
+	for _, token := range tokens {
+		remove = append(remove, token, token+".lock")
+	}
+	remove = append(remove, auditPath())
+	if *credentialsPtr {
+		remove = append(remove, credentialsPath())
+	}
+	if *folderPtr != "" && !*photosPtr {
+		for _, name := range syncDataNames {
+			remove = append(remove, filepath.Join(*folderPtr, name))
+		}
+	}
+	remove = slices.DeleteFunc(remove, func(path string) bool {
+		_, err := os.Lstat(path)
+		return err != nil
+	})
+	var empty []string
+	if *photosPtr {
+		empty = append(empty, *folderPtr)
+	}
+	empty = append(empty, also...)
+
+	if len(tokens) == 0 && len(remove) == 0 && len(empty) == 0 {
+		report("Nothing to purge.", "Nothing to purge")
+		return
+	}
+	if !*yesPtr {
+		fmt.Println("This will:")
+		if len(tokens) > 0 && !*skipRevokePtr {
+			fmt.Println("  revoke this host's access to the Google account")
+		}
+		for _, path := range remove {
+			fmt.Println("  delete " + path)
+		}
+		for _, dir := range empty {
+			fmt.Println("  delete everything in " + dir)
+		}
+		if !askYesNo("Continue?") {
+			report("Purge cancelled.", "Purge cancelled")
+			return
+		}
+	}
+
+	// A sync writing to the folder meanwhile would bring files back
+	if *folderPtr != "" {
+		if _, err := os.Stat(*folderPtr); err == nil {
+			lock, ok := prepareFolder(*folderPtr, common.lockWait)
+			if !ok {
+				return
+			}
+			defer lock.Release()
+		}
+	}
+
+	if !*skipRevokePtr {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+		for _, token := range tokens {
+			err := auth.Revoke(ctx, httpClient(), token)
+			switch {
+			case errors.Is(err, auth.ErrTokenInvalid):
+				report(fmt.Sprintf("%s was no longer valid.", token), "Token already invalid", "file", token)
+			case err != nil:
+				log.Fatalf("Unable to revoke %s, so nothing was deleted: %v. Try again once online, or use -skip-revoke and remove access at %s.",
+					token, err, permissionsURL)
+			default:
+				report(fmt.Sprintf("Revoked access granted to %s.", token), "Access revoked", "file", token)
+			}
+		}
+	}
+
+	failed := false
+	for _, path := range remove {
+		if err := os.RemoveAll(path); err != nil {
+			log.Printf("Unable to delete %s: %v", path, err)
+			failed = true
+			continue
+		}
+		report("Deleted "+path, "Deleted", "path", path)
+	}
+	for _, dir := range empty {
+		// The folder itself stays, since it may be a mount point or shared
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Unable to list %s: %v", dir, err)
+			failed = true
+		}
+		for _, entry := range entries {
+			// The lock file stays in use until the purge finishes
+			if dir == *folderPtr && entry.Name() == lockFileName {
+				continue
+			}
+			if err := os.RemoveAll(filepath.Join(dir, entry.Name())); err != nil {
+				log.Printf("Unable to delete %s: %v", filepath.Join(dir, entry.Name()), err)
+				failed = true
+			}
+		}
+		report("Emptied "+dir, "Emptied", "path", dir)
+	}
+	if failed {
+		log.Fatal("Some files could not be deleted; see above.")
+	}
+	report("Purge complete.", "Purge complete")
+}
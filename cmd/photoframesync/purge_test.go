@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// useStateDir points the state folder at dir, and clears the config, token and
+// profile settings, until the test ends.
+func useStateDir(t *testing.T, dir string) {
+	savedDir, savedConfig, savedToken, savedProfile := stateDir, configFile, tokenFile, profileName
+	t.Cleanup(func() {
+		stateDir, configFile, tokenFile, profileName = savedDir, savedConfig, savedToken, savedProfile
+	})
+	stateDir, configFile, tokenFile, profileName = dir, "", "", ""
+}
+
+func TestTokenPaths(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   []string
+		config  string
+		profile string
+		want    []string
+	}{
+		{"default token", []string{"token.json", "credentials.json", "audit.jsonl"}, "", "", []string{"token.json"}},
+		{"look-alikes stay", []string{"token.json", "token-backup.json", "tokens.json", "token.json.bak"}, "", "", []string{"token.json"}},
+		{"profiles", []string{"token-dad.json", "token-grandma.json", "token-old.json"}, `{"profiles": {"dad": {}, "grandma": {}}}`, "", []string{"token-dad.json", "token-grandma.json"}},
+		{"profile's own token file", []string{"keys/dad.json", "token-dad.json"}, `{"profiles": {"dad": {"token": "keys/dad.json"}}}`, "", []string{"keys/dad.json"}},
+		{"selected profile", []string{"token-grandma.json"}, "", "grandma", []string{"token-grandma.json"}},
+		{"nothing to purge", []string{"credentials.json"}, `{"profiles": {"dad": {}}}`, "", nil},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		useStateDir(t, dir)
+		profileName = tt.profile
+		touch(t, dir, tt.files...)
+		if tt.config != "" {
+			if err := os.WriteFile(filepath.Join(dir, "photosync.json"), []byte(tt.config), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		tokens, err := tokenPaths()
+		if err != nil {
+			t.Errorf("%s: tokenPaths: %v", tt.name, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens {
+			rel, _ := filepath.Rel(dir, token)
+			got = append(got, filepath.ToSlash(rel))
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s: tokenPaths() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
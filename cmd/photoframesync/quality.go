@@ -0,0 +1,134 @@
+// quality.go
+//
+// The quality command, which finds blurry and badly exposed photos in a synced
+// folder and reports them or takes them off the frame.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/quality"
+)
+
+// qualityThresholds are the limits a photo must stay within to pass.
+type qualityThresholds struct {
+	minSharpness  float64
+	minBrightness float64
+	maxBrightness float64
+	maxClipped    float64
+}
+
+// problem returns why a photo fails the thresholds, or "" if it passes.
+func (t qualityThresholds) problem(a quality.Analysis) string {
+	switch {
+	case a.Sharpness < t.minSharpness:
+		return fmt.Sprintf("blurry (sharpness %.1f)", a.Sharpness)
+	case a.Brightness < t.minBrightness:
+		return fmt.Sprintf("too dark (brightness %.2f)", a.Brightness)
+	case a.Brightness > t.maxBrightness:
+		return fmt.Sprintf("too bright (brightness %.2f)", a.Brightness)
+	case a.Clipped > t.maxClipped:
+		return fmt.Sprintf("badly exposed (%.0f%% clipped)", a.Clipped*100)
+	}
+	return ""
+}
+
+// runQuality analyses every photo on the frame and lists those that fail the
+// thresholds. With -exclude they are moved into the excluded folder and marked
+// archived, so syncs do not download them again.
+func runQuality(args []string) {
+	fs := flag.NewFlagSet("quality", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to check")
+	var t qualityThresholds
+	fs.Float64Var(&t.minSharpness, "min-sharpness", 15, "Photos less sharp than this are blurry; raise it to be stricter")
+	fs.Float64Var(&t.minBrightness, "min-brightness", 0.1, "Photos with a mean brightness below this, from 0 to 1, are too dark")
+	fs.Float64Var(&t.maxBrightness, "max-brightness", 0.9, "Photos with a mean brightness above this, from 0 to 1, are too bright")
+	fs.Float64Var(&t.maxClipped, "max-clipped", 0.25, "Photos with more than this fraction of pure black or white pixels are badly exposed")
+	excludePtr := fs.Bool("exclude", false, "Move the photos that fail into the excluded folder instead of only listing them")
+	dirPtr := fs.String("excluded-dir", ".excluded", "Folder inside -folder to move excluded photos to")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+	if err := refuseInLowMemory("quality"); err != nil {
+		log.Fatal(err)
+	}
+	folder := *folderPtr
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+
+	var failed []int
+	var reasons []string
+	checked := 0
+	for i, entry := range m.Items {
+		if entry.Filename == "" || entry.Archived || entry.Source == manifest.SourceCollage || !decodableExtensions[strings.ToLower(filepath.Ext(entry.Filename))] {
+			continue
+		}
+		analysis, err := quality.Analyze(filepath.Join(folder, entry.Filename))
+		if err != nil {
+			log.Printf("Unable to analyse %s: %v", entry.Filename, err)
+			continue
+		}
+		checked++
+		if why := t.problem(analysis); why != "" {
+			failed = append(failed, i)
+			reasons = append(reasons, why)
+		}
+	}
+
+	summary := fmt.Sprintf("Checked %d photos, %d below the quality thresholds", checked, len(failed))
+	if len(failed) == 0 {
+		report(summary+".", "Quality checked", "checked", checked, "failed", 0)
+		return
+	}
+	report(summary+":", "Quality checked", "checked", checked, "failed", len(failed))
+	for n, i := range failed {
+		report(fmt.Sprintf("  %s: %s", m.Items[i].Filename, reasons[n]), "Below quality thresholds", "file", m.Items[i].Filename, "reason", reasons[n])
+	}
+	if !*excludePtr {
+		report("Run again with -exclude to take them off the frame.", "Run with -exclude to exclude them")
+		return
+	}
+
+	finishWrites := common.beginWrites()
+	defer finishWrites()
+	if err := os.MkdirAll(filepath.Join(folder, *dirPtr), 0o755); err != nil {
+		log.Fatalf("Unable to create %s: %v", *dirPtr, err)
+	}
+	excluded := 0
+	for _, i := range failed {
+		entry := &m.Items[i]
+		moved := filepath.Join(*dirPtr, entry.Filename)
+		if err := os.Rename(filepath.Join(folder, entry.Filename), filepath.Join(folder, moved)); err != nil {
+			report(fmt.Sprintf("Error excluding %s: %v", entry.Filename, err), "Error excluding", "file", entry.Filename, "err", err)
+			continue
+		}
+		entry.Filename, entry.Archived = moved, true
+		excluded++
+	}
+	if err := m.Save(folder); err != nil {
+		log.Fatalf("Unable to save manifest: %v", err)
+	}
+	report(fmt.Sprintf("Moved %d photos to %s.", excluded, *dirPtr), "Excluded", "moved", excluded, "folder", *dirPtr)
+}
@@ -0,0 +1,185 @@
+// repair.go
+//
+// The repair command, which downloads again only the files verify finds damaged or
+// missing, leaving the rest of the folder alone.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/retry"
+)
+
+// runRepair verifies the folder and fetches fresh copies of the damaged files. Their
+// download links come from a recent exported selection if one is given, otherwise
+// the user is asked to pick the affected items again. It returns the exit code.
+func runRepair(args []string) int {
+	fs := flag.NewFlagSet("repair", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to repair")
+	fromPtr := fs.String("from-selection", "", "Selection file written by pick -export-selection whose links may still be valid")
+	retriesPtr := fs.Int("download-retries", 3, "Maximum attempts for each download that fails with a network or server error")
+	common := registerCommonFlags(fs)
+	pick := registerPickFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+	folder := *folderPtr
+
+	m, err := manifest.Load(folder)
+	if err != nil {
+		log.Fatalf("Unable to read manifest: %v", err)
+	}
+	verified, err := m.Verify(folder)
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+	if len(verified.Problems) == 0 {
+		report("No problems found, nothing to repair.", "No problems found")
+		return 0
+	}
+	damaged := make(map[string]manifest.Entry, len(verified.Problems))
+	for _, problem := range verified.Problems {
+		report(fmt.Sprintf("  %-9s %s: %s", problem.Kind, problem.Entry.Filename, problem.Detail),
+			"Problem", "kind", problem.Kind, "file", problem.Entry.Filename, "detail", problem.Detail)
+		switch problem.Entry.Source {
+		case manifest.SourceDrop:
+			report("            merged from a drop folder; delete it and drop merges it again",
+				"Not repairable", "file", problem.Entry.Filename, "source", problem.Entry.Source)
+			continue
+		case manifest.SourceCollage:
+			report("            a collage; it cannot be downloaded again",
+				"Not repairable", "file", problem.Entry.Filename, "source", problem.Entry.Source)
+			continue
+		}
+		damaged[problem.Entry.ID] = problem.Entry
+	}
+	if len(damaged) == 0 {
+		return 0
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lock, ok := prepareFolder(folder, common.lockWait)
+	if !ok {
+		return 0
+	}
+	defer lock.Release()
+
+	client, ok := authenticate(common.lockWait)
+	if !ok {
+		return 0
+	}
+
+	found := make(map[string]picker.PickedMediaItem)
+	if *fromPtr != "" {
+		selection, err := readSelection(*fromPtr)
+		if err != nil {
+			log.Printf("Unable to read selection: %v", err)
+			return 1
+		}
+		if age := time.Since(selection.PickedAt); age > baseURLLifetime {
+			log.Printf("Selection was picked %v ago and its links have expired; ignoring it", age.Round(time.Minute))
+		} else {
+			collectDamaged(found, damaged, selection.MediaItems)
+		}
+	}
+	if len(found) < len(damaged) {
+		report(fmt.Sprintf("\nSelect these %d items in Google Photos; anything else picked is ignored:", len(damaged)-len(found)),
+			"Select damaged items", "count", len(damaged)-len(found))
+		for id, entry := range damaged {
+			if _, ok := found[id]; !ok {
+				report(fmt.Sprintf("  %s (captured %s)", entry.OriginalFilename, entry.CreateTime),
+					"Select", "id", id, "original_file", entry.OriginalFilename, "created", entry.CreateTime)
+			}
+		}
+		picked, ok := pickMediaItems(ctx, common.pickerClient(client), pick)
+		if !ok {
+			return 0
+		}
+		collectDamaged(found, damaged, picked.MediaItems)
+	}
+
+	// Each file goes back under its recorded name and variant, replacing the
+	// damaged copy only once the new one is complete
+	var items picker.DownloadableMediaItems
+	for _, item := range found {
+		items.MediaItems = append(items.MediaItems, item)
+	}
+	restore := download.TransformFunc(func(item *download.Item) error {
+		entry := damaged[item.Id]
+		item.URL = entry.DownloadURL(item.MediaFile.BaseUrl)
+		item.Filename = entry.Filename
+		return nil
+	})
+	opts := []download.Option{
+		download.WithTransforms(restore),
+		download.WithReplaceChanged(0),
+		download.WithRetryPolicy(retry.Policy{Attempts: *retriesPtr, Backoff: time.Second}),
+	}
+	downloader := download.NewDownloader(client, folder, append(opts, writeOptions()...)...)
+
+	finishWrites := common.beginWrites(downloader)
+	result, err := downloader.Download(ctx, items)
+	if err == nil {
+		recordRepairs(folder, m, result.Saved)
+	}
+	finishWrites()
+	if err != nil {
+		log.Printf("Repair aborted: %v", err)
+		return 1
+	}
+
+	report(fmt.Sprintf("Repaired %d of %d damaged files.", result.Downloaded, len(damaged)),
+		"Repair finished", "repaired", result.Downloaded, "damaged", len(damaged))
+	for id, entry := range damaged {
+		if _, ok := found[id]; !ok {
+			report("  Not picked, still damaged: "+entry.Filename, "Still damaged", "file", entry.Filename)
+		}
+	}
+	if result.Downloaded < len(damaged) {
+		return 1
+	}
+	return 0
+}
+
+// collectDamaged adds the items of picked that belong to damaged entries to found.
+func collectDamaged(found map[string]picker.PickedMediaItem, damaged map[string]manifest.Entry, picked []picker.PickedMediaItem) {
+	for _, item := range picked {
+		if _, ok := damaged[item.Id]; ok {
+			found[item.Id] = item
+		}
+	}
+}
+
+// recordRepairs stores the checksums of the repaired files in the manifest.
+func recordRepairs(folder string, m *manifest.Manifest, saved []download.Item) {
+	repaired := make(map[string]download.Item, len(saved))
+	for _, item := range saved {
+		repaired[item.Id] = item
+	}
+	for i, entry := range m.Items {
+		if item, ok := repaired[entry.ID]; ok && item.SHA256 != "" {
+			m.Items[i].Size, m.Items[i].SHA256 = item.Size, item.SHA256
+		}
+	}
+	if err := m.Save(folder); err != nil {
+		log.Printf("Unable to save manifest: %v", err)
+	}
+}
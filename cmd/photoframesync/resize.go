@@ -0,0 +1,201 @@
+// resize.go
+//
+// The /img endpoint of serve, which scales synced photos to the size each client
+// asks for, so frames with very different screens can share one full-resolution
+// library over the network. Scaled copies are kept in a hidden folder and made
+// again only when the photo changes.
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+	"PhotoSync/pkg/variant"
+)
+
+// resizedFolder is the folder inside a synced folder holding scaled copies made
+// for /img.
+const resizedFolder = ".resized"
+
+// maxResizeDimension bounds the sizes clients can ask for, which would otherwise
+// let one request use all of a small host's memory.
+const maxResizeDimension = 4096
+
+// resizeRequest is a parsed /img query.
+type resizeRequest struct {
+	spec variant.Spec
+	fit  string
+}
+
+// parseResizeRequest parses the w, h and fit parameters of an /img request. fit
+// is contain, the default, to fit within w x h, or cover to fill it exactly. With
+// contain either dimension can be left out.
+func parseResizeRequest(r *http.Request) (resizeRequest, error) {
+	req := resizeRequest{fit: r.URL.Query().Get("fit")}
+	dimension := func(name string) (int, error) {
+		value := r.URL.Query().Get(name)
+		if value == "" {
+			return maxResizeDimension, nil
+		}
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 || n > maxResizeDimension {
+			return 0, fmt.Errorf("%s must be between 1 and %d", name, maxResizeDimension)
+		}
+		return n, nil
+	}
+	var err error
+	if req.spec.Width, err = dimension("w"); err != nil {
+		return req, err
+	}
+	if req.spec.Height, err = dimension("h"); err != nil {
+		return req, err
+	}
+	switch req.fit {
+	case "", "contain":
+		req.fit = "contain"
+	case "cover":
+		if r.URL.Query().Get("w") == "" || r.URL.Query().Get("h") == "" {
+			return req, errors.New("fit=cover needs both w and h")
+		}
+		req.spec.Crop = true
+	default:
+		return req, fmt.Errorf("unknown fit %q: expected contain or cover", req.fit)
+	}
+	return req, nil
+}
+
+// resizedKey is the prefix of the scaled copies of the item id. IDs are hashed,
+// since those of local files contain characters some filesystems refuse.
+func resizedKey(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:8])
+}
+
+// resizedPath is where the scaled copy of the item id is kept.
+func resizedPath(folder, id, filename string, req resizeRequest) string {
+	name := fmt.Sprintf("%s-%dx%d-%s%s", resizedKey(id), req.spec.Width, req.spec.Height, req.fit, filepath.Ext(filename))
+	return filepath.Join(folder, resizedFolder, req.spec.Filename(name))
+}
+
+// imageEntry returns the manifest entry of the synced file with the given ID.
+func imageEntry(folder, id string) (manifest.Entry, bool) {
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return manifest.Entry{}, false
+	}
+	for _, entry := range m.Items {
+		if entry.ID == id && entry.Filename != "" {
+			return entry, true
+		}
+	}
+	return manifest.Entry{}, false
+}
+
+// listImages lists the IDs of the synced photos that /img can serve, with their
+// names and capture times, so clients know what to ask for.
+func (s *familyServer) listImages(w http.ResponseWriter, r *http.Request) {
+	type image struct {
+		ID       string `json:"id"`
+		Filename string `json:"filename"`
+		Created  string `json:"createTime,omitempty"`
+	}
+	images := []image{}
+	if m, err := manifest.Load(s.folder); err == nil {
+		for _, entry := range m.Items {
+			if entry.Filename != "" && !entry.Archived && !videoExtensions[strings.ToLower(filepath.Ext(entry.Filename))] {
+				images = append(images, image{entry.ID, filepath.ToSlash(entry.Filename), entry.CreateTime})
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]any{"images": images})
+}
+
+// resizeImage serves a synced photo scaled as the query asks, making the scaled
+// copy if it is missing or older than the photo.
+func (s *familyServer) resizeImage(w http.ResponseWriter, r *http.Request) {
+	req, err := parseResizeRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	entry, ok := imageEntry(s.folder, r.PathValue("id"))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	src := filepath.Join(s.folder, filepath.FromSlash(entry.Filename))
+	// Browsers cannot all show formats such as HEIC, so copies are JPEG, or PNG
+	// for PNGs and GIFs to keep their transparency
+	req.spec.Format = variant.JPEG
+	if ext := strings.ToLower(filepath.Ext(entry.Filename)); ext == ".png" || ext == ".gif" {
+		req.spec.Format = variant.PNG
+	}
+	dst := resizedPath(s.folder, entry.ID, entry.Filename, req)
+
+	// One at a time keeps memory in check on small hosts, and stops two requests
+	// for the same size writing the same file
+	s.resizeMu.Lock()
+	if !upToDate(src, dst) {
+		err = os.MkdirAll(filepath.Dir(dst), 0o755)
+		if err == nil {
+			err = variant.Make(src, dst, req.spec)
+		}
+	}
+	s.resizeMu.Unlock()
+	if errors.Is(err, variant.ErrUnsupported) {
+		http.Error(w, "Only photos can be resized", http.StatusUnsupportedMediaType)
+		return
+	}
+	if errors.Is(err, os.ErrNotExist) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to resize %s: %v", entry.Filename, err)
+		http.Error(w, "Unable to resize the photo", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(time.Hour.Seconds())))
+	http.ServeFile(w, r, dst)
+}
+
+// pruneResized removes the scaled copies of photos no longer in folder's manifest.
+func pruneResized(folder string) {
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return
+	}
+	kept := make(map[string]bool)
+	for _, entry := range m.Items {
+		if entry.Filename != "" {
+			kept[resizedKey(entry.ID)] = true
+		}
+	}
+	dir := filepath.Join(folder, resizedFolder)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	for _, e := range entries {
+		prefix, _, _ := strings.Cut(e.Name(), "-")
+		if kept[prefix] {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+			log.Printf("Unable to remove %s: %v", e.Name(), err)
+		}
+	}
+}
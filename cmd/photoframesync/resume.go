@@ -0,0 +1,88 @@
+// resume.go
+//
+// Keeping the listing of a sync's selection in its folder until every item is
+// downloaded, so that a sync that crashed, partly failed or lost its network can
+// be resumed without picking again or paging through the listing again.
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/picker"
+)
+
+// pendingFileName is the file inside a synced folder holding the selection of a
+// sync that has not finished.
+const pendingFileName = ".photosync-pending.json"
+
+// savePending records the selection being synced into folder.
+func savePending(folder string, items picker.DownloadableMediaItems) {
+	selection := Selection{PickedAt: time.Now(), MediaItems: items.MediaItems}
+	if err := writeSelection(filepath.Join(folder, pendingFileName), selection); err != nil {
+		log.Printf("Unable to record the selection for -resume: %v", err)
+	}
+}
+
+// loadPending returns the selection of the unfinished sync into folder, reporting
+// false if there is none.
+func loadPending(folder string) (picker.DownloadableMediaItems, bool) {
+	selection, err := readSelection(filepath.Join(folder, pendingFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		report("No unfinished sync to resume, picking again.", "No unfinished sync to resume")
+		return picker.DownloadableMediaItems{}, false
+	}
+	if err != nil {
+		log.Printf("Unable to read the unfinished sync, picking again: %v", err)
+		return picker.DownloadableMediaItems{}, false
+	}
+	age := time.Since(selection.PickedAt)
+	report(fmt.Sprintf("Resuming the sync of %d items picked %v ago.", len(selection.MediaItems), age.Round(time.Minute)),
+		"Resuming sync", "items", len(selection.MediaItems), "age", age.Round(time.Second).String())
+	if age > baseURLLifetime {
+		log.Printf("Warning: the download links have probably expired; run without -resume to pick again if downloads fail")
+	}
+	return picker.DownloadableMediaItems{MediaItems: selection.MediaItems}, true
+}
+
+// finishPending forgets the selection once a sync into folder has finished.
+func finishPending(folder string) {
+	if err := os.Remove(filepath.Join(folder, pendingFileName)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Unable to remove %s: %v", pendingFileName, err)
+	}
+}
+
+// resumeWhenOnline retries a sync whose items failed because the network went
+// down, waiting up to wait for it to come back. It gives up once items fail while
+// the network is up, since waiting longer would not help them. The returned result
+// covers the whole sync.
+func resumeWhenOnline(ctx context.Context, downloader *download.Downloader, items picker.DownloadableMediaItems, result download.Result, wait time.Duration) download.Result {
+	deadline := time.Now().Add(wait)
+	for result.Failed > 0 && !result.Aborted && !result.Interrupted && !online(ctx) {
+		report(fmt.Sprintf("Network unreachable, %d items queued until it is back.", result.Failed),
+			"Network unreachable, queued", "failed", result.Failed)
+		if !waitOnline(ctx, deadline) {
+			report("Gave up waiting for the network.", "Gave up waiting for the network")
+			return result
+		}
+		report("Network is back, resuming the sync.", "Network back, resuming")
+		retry, err := downloader.Download(ctx, items)
+		if err != nil {
+			log.Printf("Unable to resume the sync: %v", err)
+			return result
+		}
+		// Files downloaded by the earlier attempt count as downloads, not as
+		// files that were already present
+		retry.Downloaded += result.Downloaded
+		retry.Existing -= result.Downloaded
+		retry.Added = append(result.Added, retry.Added...)
+		result = retry
+	}
+	return result
+}
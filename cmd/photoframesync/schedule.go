@@ -0,0 +1,189 @@
+// schedule.go
+//
+// Holding downloads back outside an allowed time window, while paused, and holding
+// large downloads back while the host is on a metered connection such as LTE or a
+// phone's hotspot.
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/download"
+)
+
+// recheckInterval is how often held downloads check whether they may go ahead.
+const recheckInterval = time.Minute
+
+// downloadWindow is a daily time window, in minutes since midnight. A window whose
+// end is before its start runs over midnight.
+type downloadWindow struct {
+	start, end int
+}
+
+// parseWindow parses "HH:MM-HH:MM".
+func parseWindow(s string) (downloadWindow, error) {
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return downloadWindow{}, fmt.Errorf("expected HH:MM-HH:MM, got %q", s)
+	}
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return downloadWindow{}, fmt.Errorf("invalid start time %q", from)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return downloadWindow{}, fmt.Errorf("invalid end time %q", to)
+	}
+	return downloadWindow{start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute()}, nil
+}
+
+// contains reports whether t's local time of day is inside the window.
+func (w downloadWindow) contains(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	return minute >= w.start || minute < w.end
+}
+
+// downloadGate holds downloads into folder until they are allowed. Downloads over
+// meteredMaxSize are held while the connection is metered if avoidMetered is set;
+// their size is only known once they start, so the gate is also a
+// download.SizeGate.
+type downloadGate struct {
+	folder         string
+	window         *downloadWindow
+	avoidMetered   bool
+	meteredMaxSize int64
+
+	mu        sync.Mutex
+	checked   time.Time
+	isMetered bool
+	waiting   string
+	heldSince time.Time
+}
+
+// wait blocks until item may be downloaded or ctx is cancelled.
+func (g *downloadGate) wait(ctx context.Context, item *download.Item) error {
+	return g.Wait(ctx, item, -1)
+}
+
+// Hold reports whether item, now known to be size bytes, must wait.
+func (g *downloadGate) Hold(item *download.Item, size int64) bool {
+	return g.holdReason(item, size) != ""
+}
+
+// Wait blocks until item, of size bytes or -1 if not yet known, may be downloaded
+// or ctx is cancelled.
+func (g *downloadGate) Wait(ctx context.Context, item *download.Item, size int64) error {
+	for {
+		reason := g.holdReason(item, size)
+		g.mu.Lock()
+		if reason == "" {
+			if g.waiting != "" {
+				g.waiting = ""
+				report("Carrying on with downloads.", "Downloads released")
+				if time.Since(g.heldSince) > baseURLLifetime {
+					log.Printf("Warning: the download links have probably expired while held; run sync -resume afterwards to fetch items that fail")
+				}
+			}
+			g.mu.Unlock()
+			return nil
+		}
+		// Say so once, not once for every item held for the same reason
+		if g.waiting != reason {
+			if g.waiting == "" {
+				g.heldSince = time.Now()
+			}
+			g.waiting = reason
+			report("Holding downloads: "+reason, "Holding downloads", "reason", reason)
+		}
+		g.mu.Unlock()
+		wait := recheckInterval
+		if reason == pausedReason {
+			wait = pauseRecheck
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+// pausedReason is the hold reason of paused downloads.
+const pausedReason = "paused; run resume to carry on"
+
+// holdReason says why item, of size bytes or -1 if not yet known, may not be
+// downloaded yet, or returns "".
+func (g *downloadGate) holdReason(item *download.Item, size int64) string {
+	if paused(g.folder) {
+		return pausedReason
+	}
+	if g.window != nil && !g.window.contains(time.Now()) {
+		return "outside the download window"
+	}
+	if g.avoidMetered && size > g.meteredMaxSize && g.metered() {
+		return fmt.Sprintf("items over %s wait while the connection is metered", formatBytes(g.meteredMaxSize))
+	}
+	return ""
+}
+
+// metered reports whether the host's connection is metered, rechecking at most
+// once per recheckInterval.
+func (g *downloadGate) metered() bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if time.Since(g.checked) >= recheckInterval {
+		g.isMetered, g.checked = connectionMetered(), time.Now()
+	}
+	return g.isMetered
+}
+
+// connectionMetered asks NetworkManager whether the device of the default route
+// is metered. Without NetworkManager it guesses from the interface name: mobile
+// broadband modems and USB tethering are metered.
+func connectionMetered() bool {
+	device := defaultRouteDevice()
+	if device == "" {
+		return false
+	}
+	out, err := exec.Command("nmcli", "-g", "GENERAL.METERED", "device", "show", device).Output()
+	if err == nil {
+		// "yes" or "yes (guessed)" when metered
+		return strings.HasPrefix(strings.TrimSpace(string(out)), "yes")
+	}
+	for _, prefix := range []string{"wwan", "ppp", "rmnet", "usb", "wwp"} {
+		if strings.HasPrefix(device, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// defaultRouteDevice returns the network interface of the IPv4 default route, or
+// "" if it cannot be found, e.g. on systems other than Linux.
+func defaultRouteDevice() string {
+	f, err := os.Open("/proc/net/route")
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		// Iface Destination Gateway ...; the default route has destination 0
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 1 && fields[1] == "00000000" {
+			return fields[0]
+		}
+	}
+	return ""
+}
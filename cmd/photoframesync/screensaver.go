@@ -0,0 +1,89 @@
+// screensaver.go
+//
+// Feeding a desktop screensaver or slideshow, for frames that are really an old
+// laptop: the photo screensavers of macOS, Windows and xscreensaver all show a
+// folder of pictures, so the sync keeps a dedicated folder of plain JPEGs that
+// mirrors the frame folder, and nudges the screensaver to look at it again.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/variant"
+)
+
+// feedScreensaver brings the screensaver folder dir up to date with the items
+// saved in folder, removing pictures no longer selected, then asks the screensaver
+// to reload. Videos and formats screensavers cannot show, such as HEIC, are left
+// out. size is the screen's WIDTHxHEIGHT, or empty to keep the photos' size.
+func feedScreensaver(dir, size, folder string, saved []download.Item) {
+	if dir == "" {
+		return
+	}
+	t := target{folder: dir, spec: variant.Spec{Format: variant.JPEG}}
+	if size != "" {
+		width, height, err := parseDimensions(size)
+		if err != nil {
+			log.Printf("Invalid -screensaver-size: %v", err)
+			return
+		}
+		t.spec.Width, t.spec.Height = width, height
+	}
+	fanOut(folder, saved, []target{t})
+
+	wanted := make(map[string]bool, len(saved))
+	for _, item := range saved {
+		wanted[t.spec.Filename(item.Filename)] = true
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		log.Printf("Unable to list %s: %v", dir, err)
+		return
+	}
+	removed := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !entry.Type().IsRegular() || wanted[name] || strings.HasPrefix(name, ".") {
+			continue
+		}
+		if err := os.Remove(filepath.Join(dir, name)); err != nil {
+			log.Printf("Unable to remove %s from the screensaver folder: %v", name, err)
+			continue
+		}
+		removed++
+	}
+	if removed > 0 {
+		report(fmt.Sprintf("Removed %d pictures no longer selected from the screensaver folder.", removed),
+			"Screensaver pruned", "removed", removed)
+	}
+	refreshScreensaver()
+}
+
+// refreshScreensaver asks the running screensaver to pick up the changed folder.
+// macOS's photo screensaver lists its folder each time it starts, so needs nothing.
+func refreshScreensaver() {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "windows":
+		// Has Explorer reload the per-user desktop settings, slideshow included
+		cmd = exec.Command("rundll32.exe", "user32.dll,UpdatePerUserSystemParameters")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		if _, err := exec.LookPath("xscreensaver-command"); err != nil {
+			return
+		}
+		// Makes glslideshow and friends load the folder afresh
+		cmd = exec.Command("xscreensaver-command", "-restart")
+	default:
+		return
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		log.Printf("Unable to refresh the screensaver: %v: %s", err, strings.TrimSpace(string(out)))
+	}
+}
@@ -0,0 +1,231 @@
+// selection.go
+//
+// Split workflow for frames on isolated networks: photos are picked and exported on an
+// internet-connected machine, downloaded into a bundle folder, and the bundle is then
+// imported on the offline frame host.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"PhotoSync/pkg/picker"
+)
+
+// bundleManifestName is the file inside a download bundle listing its contents.
+const bundleManifestName = "photosync-bundle.json"
+
+// baseURLLifetime is how long the Picker API keeps media item baseUrls valid.
+const baseURLLifetime = 60 * time.Minute
+
+// Selection is a set of picked media items saved to disk.
+type Selection struct {
+	PickedAt   time.Time                `json:"pickedAt"`
+	MediaItems []picker.PickedMediaItem `json:"mediaItems"`
+}
+
+func readSelection(path string) (Selection, error) {
+	var selection Selection
+	f, err := os.Open(path)
+	if err != nil {
+		return selection, err
+	}
+	defer f.Close()
+	// Decode straight from the file rather than loading it whole first
+	if err := json.NewDecoder(f).Decode(&selection); err != nil {
+		return selection, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return selection, nil
+}
+
+func writeSelection(path string, selection Selection) error {
+	data, err := json.MarshalIndent(selection, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+// runPick creates a Picker session and exports the selected items without downloading them.
+func runPick(args []string) {
+	fs := flag.NewFlagSet("pick", flag.ExitOnError)
+	exportPtr := fs.String("export-selection", "", "File to write the selected media items to")
+	common := registerCommonFlags(fs)
+	pick := registerPickFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *exportPtr == "" {
+		log.Fatal("You must specify an output file using the -export-selection flag.")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	client, ok := authenticate(common.lockWait)
+	if !ok {
+		return
+	}
+
+	items, ok := pickMediaItems(ctx, common.pickerClient(client), pick)
+	if !ok {
+		return
+	}
+
+	selection := Selection{PickedAt: time.Now(), MediaItems: items.MediaItems}
+	if err := writeSelection(*exportPtr, selection); err != nil {
+		log.Fatalf("Unable to write selection to %s: %v", *exportPtr, err)
+	}
+	report(fmt.Sprintf("Exported %d items to %s. Download them within %v, before their links expire.",
+		len(selection.MediaItems), *exportPtr, baseURLLifetime),
+		"Exported selection", "items", len(selection.MediaItems), "file", *exportPtr, "expires", selection.PickedAt.Add(baseURLLifetime))
+}
+
+// runDownload downloads a previously exported selection into a bundle folder that can
+// be carried to the frame host and imported there. It returns the exit code.
+func runDownload(args []string) int {
+	fs := flag.NewFlagSet("download", flag.ExitOnError)
+	fromPtr := fs.String("from-selection", "", "Selection file written by pick -export-selection")
+	folderPtr := fs.String("folder", "", "Folder to download the bundle into")
+	common := registerCommonFlags(fs)
+	pipeline := registerPipelineFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *fromPtr == "" || *folderPtr == "" {
+		log.Fatal("You must specify both -from-selection and -folder.")
+	}
+
+	selection, err := readSelection(*fromPtr)
+	if err != nil {
+		log.Fatalf("Unable to read selection: %v", err)
+	}
+	if age := time.Since(selection.PickedAt); age > baseURLLifetime {
+		log.Printf("Warning: selection was picked %v ago; its download links have probably expired", age.Round(time.Minute))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	lock, ok := prepareFolder(*folderPtr, common.lockWait)
+	if !ok {
+		return 0
+	}
+	defer lock.Release()
+	pauseOnSignal(*folderPtr)
+
+	client, ok := authenticate(common.lockWait)
+	if !ok {
+		return 0
+	}
+	downloader, err := pipeline.newDownloader(client, *folderPtr)
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+	targets, err := pipeline.fanOutTargets()
+	if err != nil {
+		log.Print(err)
+		return 1
+	}
+
+	finishWrites := common.beginWrites(downloader)
+	defer finishWrites()
+	if err := pipeline.checkStorage(*folderPtr, len(selection.MediaItems)); err != nil {
+		log.Print(err)
+		return 1
+	}
+	result, err := downloader.Download(ctx, picker.DownloadableMediaItems{MediaItems: selection.MediaItems})
+	if err != nil {
+		log.Printf("Download aborted: %v", err)
+		return 1
+	}
+	printResult(result)
+	if pipeline.rollBack(*folderPtr, result) {
+		return 1
+	}
+	linkIntoPool(pipeline.pool, *folderPtr, result.Saved)
+	fanOut(*folderPtr, result.Saved, targets)
+
+	// Record only the items that made it to disk so import never expects missing files
+	bundle := Selection{PickedAt: selection.PickedAt}
+	for _, saved := range result.Saved {
+		item := saved.PickedMediaItem
+		item.MediaFile.Filename = saved.Filename
+		bundle.MediaItems = append(bundle.MediaItems, item)
+	}
+	if err := writeSelection(filepath.Join(*folderPtr, bundleManifestName), bundle); err != nil {
+		log.Printf("Unable to write bundle manifest: %v", err)
+		return 1
+	}
+	report(fmt.Sprintf("Bundle of %d of %d items written to %s", len(bundle.MediaItems), len(selection.MediaItems), *folderPtr),
+		"Bundle written", "items", len(bundle.MediaItems), "selected", len(selection.MediaItems), "folder", *folderPtr)
+	return 0
+}
+
+// runImport copies the files of a download bundle into the frame folder, skipping
+// files that are already present. It never touches the network.
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Folder location where photos will be imported")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	common.defaultFolder(folderPtr)
+
+	if fs.NArg() != 1 || *folderPtr == "" {
+		log.Fatal("Usage: import -folder <frame folder> <bundle folder>")
+	}
+	bundleDir := fs.Arg(0)
+
+	bundle, err := readSelection(filepath.Join(bundleDir, bundleManifestName))
+	if err != nil {
+		log.Fatalf("Unable to read bundle: %v", err)
+	}
+
+	lock, ok := prepareFolder(*folderPtr, common.lockWait)
+	if !ok {
+		return
+	}
+	defer lock.Release()
+
+	writer := folderWriter(*folderPtr)
+	finishWrites := common.beginWrites(writer)
+	defer finishWrites()
+
+	imported := 0
+	for _, item := range bundle.MediaItems {
+		name := item.MediaFile.Filename
+		// The bundle is only data, so a name must not reach outside either folder
+		if name != filepath.Base(name) || strings.HasPrefix(name, ".") {
+			report(fmt.Sprintf("Refusing to import %q: not a plain file name.", name), "Bad file name", "file", name)
+			continue
+		}
+		dst := filepath.Join(*folderPtr, name)
+		if _, err := os.Stat(dst); err == nil {
+			report(fmt.Sprintf("File %s already exists, skipping import.", name), "Already present", "file", name)
+			continue
+		}
+		if err := writer.CopyFile(filepath.Join(bundleDir, name), dst); err != nil {
+			report(fmt.Sprintf("Error importing %s: %v", name, err), "Error importing", "file", name, "err", err)
+			continue
+		}
+		report("Imported: "+name, "Imported", "file", name)
+		imported++
+	}
+	report(fmt.Sprintf("Imported %d of %d items from %s", imported, len(bundle.MediaItems), bundleDir),
+		"Import finished", "imported", imported, "items", len(bundle.MediaItems), "folder", bundleDir)
+}
@@ -0,0 +1,354 @@
+// serve.go
+//
+// The serve command, which runs a small web server with a one-button "family mode"
+// page. Pressing the button starts a Picker session, sends the browser straight to
+// Google Photos, and syncs the folder once the selection is done, so relatives can
+// refresh the frame without a terminal.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/frameindex"
+	"PhotoSync/pkg/picker"
+)
+
+// familyServer runs one pick-and-sync at a time for the serve command.
+type familyServer struct {
+	ctx        context.Context
+	common     *commonFlags
+	picker     *picker.PickerClient
+	downloader *download.Downloader
+	folder     string
+	targets    []target
+	indexes    []frameindex.Writer
+	pipeline   *pipelineFlags
+	pick       *pickFlags
+
+	// familyPage and kioskPage are the pages served, built in or from -theme.
+	familyPage *template.Template
+	kioskPage  *template.Template
+	// lang and text are the language of the family page and its text, by key.
+	lang string
+	text map[string]string
+
+	// controlToken must be passed to /sync/pause and /sync/resume; without
+	// one they only answer the host itself.
+	controlToken string
+
+	kiosks        kioskDevices
+	kioskInterval time.Duration
+	kioskOrder    string
+	// kioskPairPortraits shows two portraits side by side on landscape kiosks.
+	kioskPairPortraits bool
+
+	// remote holds each kiosk's state for remote control, by token.
+	remoteMu sync.Mutex
+	remote   map[string]*kioskState
+
+	// stream, if set, streams selections from Google Photos rather than saving
+	// them in folder.
+	stream *streamer
+
+	// resize is set when /img serves scaled copies of the folder's photos, made
+	// one at a time under resizeMu.
+	resize   bool
+	resizeMu sync.Mutex
+
+	mu sync.Mutex
+	// pickerURI is the session being picked in, if any, and status describes the
+	// current or last refresh.
+	pickerURI string
+	status    string
+	// syncs are the latest syncs run, for /stats.
+	syncs []syncStats
+}
+
+// runServe serves the family mode page until interrupted.
+func runServe(args []string) {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Folder location where photos will be saved")
+	listenPtr := fs.String("listen", ":8090", "Address to serve the family mode page on")
+	controlTokenPtr := fs.String("control-token", "", "Token other hosts must pass to POST /sync/pause and /sync/resume, as a bearer token or ?token=; without one only this host may")
+	var kiosks stringList
+	fs.Var(&kiosks, "kiosk", "Serve a full-screen slideshow at /kiosk/TOKEN for a tablet or signage player, showing the synced folder or, as TOKEN=FOLDER, another; may be repeated")
+	kioskIntervalPtr := fs.Duration("kiosk-interval", 30*time.Second, "How long kiosks show each photo")
+	kioskPairsPtr := fs.Bool("kiosk-pair-portraits", false, "On landscape kiosks, show two portrait photos in a row side by side rather than each between black bars")
+	kioskOrderPtr := fs.String("kiosk-order", "shuffle", "Order kiosks show photos in: shuffle, chronological (oldest first) or name")
+	imgPtr := fs.Bool("img", false, "Serve synced photos scaled for each client at /img/ID?w=WIDTH&h=HEIGHT&fit=contain|cover, with the IDs listed at /img/")
+	streamPtr := fs.Bool("stream", false, "Stream the selection to kiosks straight from Google Photos rather than saving it in the folder, for hosts with almost no storage")
+	streamCachePtr := fs.String("stream-cache", "64MB", "With -stream, how much of the recently shown photos to keep rather than fetch again")
+	langPtr := fs.String("lang", "en", "Language of the family mode page: "+strings.Join(languages, ", "))
+	themePtr := fs.String("theme", "", "Folder of pages to serve in place of the built-in pick.html and kiosk.html")
+	streamCacheDirPtr := fs.String("stream-cache-dir", "", "With -stream, keep the cache in this folder rather than in memory")
+	common := registerCommonFlags(fs)
+	pick := registerPickFlags(fs)
+	pipeline := registerPipelineFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+	if *imgPtr {
+		if err := refuseInLowMemory("-img"); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	familyPage, err := loadPage(*themePtr, "pick.html")
+	if err != nil {
+		log.Fatalf("Unable to load the family mode page: %v", err)
+	}
+	kioskPage, err := loadPage(*themePtr, "kiosk.html")
+	if err != nil {
+		log.Fatalf("Unable to load the kiosk page: %v", err)
+	}
+	text, err := loadMessages(*themePtr, *langPtr)
+	if err != nil {
+		log.Fatalf("Invalid -lang: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	pauseOnSignal(*folderPtr)
+
+	client, ok := authenticate(common.lockWait)
+	if !ok {
+		return
+	}
+	downloader, err := pipeline.newDownloader(client, *folderPtr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	targets, err := pipeline.fanOutTargets()
+	if err != nil {
+		log.Fatal(err)
+	}
+	indexes, err := pipeline.indexWriters()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *controlTokenPtr != "" && !kioskToken.MatchString(*controlTokenPtr) {
+		log.Fatal("Invalid -control-token: use at least 8 letters, digits, - or _")
+	}
+	devices, err := parseKioskDevices(kiosks, *folderPtr)
+	if err != nil {
+		log.Fatalf("Invalid -kiosk: %v", err)
+	}
+	if !slices.Contains(kioskOrders, *kioskOrderPtr) {
+		log.Fatalf("Invalid -kiosk-order %q: use %s", *kioskOrderPtr, strings.Join(kioskOrders, ", "))
+	}
+	s := &familyServer{
+		ctx:        ctx,
+		common:     common,
+		picker:     common.pickerClient(client),
+		downloader: downloader,
+		folder:     *folderPtr,
+		targets:    targets,
+		indexes:    indexes,
+		pipeline:   pipeline,
+		pick:       pick,
+		familyPage: familyPage,
+		kioskPage:  kioskPage,
+		lang:       *langPtr,
+		text:       text,
+
+		controlToken: *controlTokenPtr,
+
+		kiosks:             devices,
+		kioskInterval:      *kioskIntervalPtr,
+		kioskOrder:         *kioskOrderPtr,
+		kioskPairPortraits: *kioskPairsPtr,
+		resize:             *imgPtr,
+	}
+	if *streamPtr {
+		if !slices.Contains(slices.Collect(maps.Values(devices)), *folderPtr) {
+			log.Fatal("-stream shows the selection on kiosks: add a -kiosk TOKEN for the folder.")
+		}
+		limit, err := parseByteSize(*streamCachePtr)
+		if err != nil {
+			log.Fatalf("Invalid -stream-cache: %v", err)
+		}
+		cache, err := newStreamCache(*streamCacheDirPtr, limit)
+		if err != nil {
+			log.Fatalf("Unable to use -stream-cache-dir: %v", err)
+		}
+		s.stream = newStreamer(client, s.picker, *folderPtr, cache)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/pick", http.StatusFound)
+	})
+	mux.HandleFunc("GET /pick", s.showPage)
+	mux.HandleFunc("POST /pick", s.startPick)
+	// A short link for a QR code or NFC tag on the frame, which goes straight
+	// to the Picker without the button
+	mux.HandleFunc("GET /p", s.startPick)
+	mux.HandleFunc("GET /stats", s.showStats)
+	mux.HandleFunc("POST /sync/pause", s.pauseSync)
+	mux.HandleFunc("POST /sync/resume", s.resumeSync)
+	if len(devices) > 0 {
+		mux.HandleFunc("GET /kiosk/{token}", s.showKiosk)
+		mux.HandleFunc("GET /kiosk/{token}/playlist.json", s.kioskPlaylist)
+		mux.HandleFunc("GET /kiosk/{token}/files/{name}", s.kioskFile)
+		mux.HandleFunc("GET /kiosk/{token}/remote", s.kioskNow)
+		mux.HandleFunc("POST /kiosk/{token}/remote", s.kioskCheckIn)
+		mux.HandleFunc("POST /kiosk/{token}/remote/{command}", s.kioskControl)
+		if s.stream != nil {
+			// The name is only there for the page to tell videos apart
+			mux.HandleFunc("GET /kiosk/{token}/stream/{id}/{name}", s.kioskStream)
+		}
+	}
+
+	if s.resize {
+		mux.HandleFunc("GET /img/{$}", s.listImages)
+		mux.HandleFunc("GET /img/{id}", s.resizeImage)
+	}
+
+	server := &http.Server{Addr: *listenPtr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+	log.Printf("Family mode page at http://%s/pick, and straight to the Picker at http://%s/p", *listenPtr, *listenPtr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+}
+
+// say returns the family page's text for key, filled in with args.
+func (s *familyServer) say(key string, args ...any) string {
+	return fmt.Sprintf(s.text[key], args...)
+}
+
+// showPage renders the button and the status of the current or last refresh.
+func (s *familyServer) showPage(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	data := struct {
+		PickerURI, Status, Lang string
+		Text                    map[string]string
+	}{s.pickerURI, s.status, s.lang, s.text}
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	s.familyPage.Execute(w, data)
+}
+
+// startPick sends the browser to the Picker, creating a session unless one is
+// already waiting for a selection.
+func (s *familyServer) startPick(w http.ResponseWriter, r *http.Request) {
+	// Where this leads changes with every session, so it must not be remembered
+	w.Header().Set("Cache-Control", "no-store")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pickerURI != "" {
+		http.Redirect(w, r, s.pickerURI, http.StatusSeeOther)
+		return
+	}
+
+	var opts []picker.SessionOption
+	if s.pick.maxItems > 0 {
+		opts = append(opts, picker.MaxItemCount(s.pick.maxItems))
+	}
+	session, err := s.picker.CreateSession(r.Context(), opts...)
+	if err != nil {
+		log.Printf("Failed to create picker session: %v", err)
+		s.status = s.say("unreachable")
+		http.Redirect(w, r, "/pick", http.StatusSeeOther)
+		return
+	}
+	s.pickerURI = session.PickerURI
+	s.status = s.say("waiting")
+	go s.syncSelection(session)
+	http.Redirect(w, r, session.PickerURI, http.StatusSeeOther)
+}
+
+// syncSelection waits for the selection made in session and downloads it.
+func (s *familyServer) syncSelection(session picker.PickingSession) {
+	status := s.refresh(session)
+	s.mu.Lock()
+	s.pickerURI = ""
+	s.status = status
+	s.mu.Unlock()
+}
+
+// refresh runs a sync of session's selection and returns the status to show.
+func (s *familyServer) refresh(session picker.PickingSession) string {
+	items, err := s.picker.WaitForSelection(s.ctx, session)
+	if err != nil {
+		log.Printf("Failed while waiting for photo selection: %v", err)
+		return s.say("noneChosen")
+	}
+	if s.stream != nil {
+		planned := s.downloader.Plan(items)
+		if err := s.stream.use(session.ID, planned); err != nil {
+			log.Printf("Unable to record the streamed selection: %v", err)
+			return s.say("streamFailed")
+		}
+		return s.say("streaming", time.Now().Format("15:04"), len(planned))
+	}
+
+	lock, ok := prepareFolder(s.folder, s.common.lockWait)
+	if !ok {
+		return s.say("busy")
+	}
+	defer lock.Release()
+
+	reportSelectionChanges(s.folder, items, false)
+	finishWrites := s.common.beginWrites(s.downloader)
+	if err := s.pipeline.checkStorage(s.folder, len(items.MediaItems)); err != nil {
+		finishWrites()
+		log.Print(err)
+		return s.say("storageFailing")
+	}
+	// Kept so that a sync paused or cut short here can be finished with sync -resume
+	savePending(s.folder, items)
+	result, err := s.downloader.Download(s.ctx, items)
+	if err == nil && !s.pipeline.rollBack(s.folder, result) {
+		saveManifest(s.folder, items, result.Saved)
+		if s.pipeline.sums {
+			writeSums(s.folder)
+		}
+		linkIntoPool(s.pipeline.pool, s.folder, result.Saved)
+		fanOut(s.folder, result.Saved, s.targets)
+		feedScreensaver(s.pipeline.screensaver, s.pipeline.screensaverSize, s.folder, result.Saved)
+		writeIndexes(s.folder, s.indexes, folderFiles(s.folder, result.Saved))
+		takeSnapshot(s.pipeline.snapshots, s.folder, s.pipeline.keepSnapshots)
+		if s.resize {
+			pruneResized(s.folder)
+		}
+		if result.Failed == 0 && !result.Interrupted {
+			finishPending(s.folder)
+		}
+	}
+	finishWrites()
+	if err != nil {
+		log.Printf("Sync aborted: %v", err)
+		return s.say("saveFailed")
+	}
+	printResult(result)
+	s.recordSync(result)
+	return s.say("done",
+		time.Now().Format("15:04"), result.Downloaded, result.Existing)
+}
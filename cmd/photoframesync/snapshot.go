@@ -0,0 +1,212 @@
+// snapshot.go
+//
+// Dated snapshots of a frame folder, in the style of rsnapshot: after each sync
+// the folder's photos are hardlinked into a new snapshot, and a "current" symlink
+// is pointed at it. A frame that shows "current" can be rolled back to an earlier
+// selection instantly with the snapshots command.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// currentSnapshot is the symlink, inside the snapshots folder, to the snapshot
+// frames should show.
+const currentSnapshot = "current"
+
+// snapshotLayout names snapshots by the time they were taken, so that they sort
+// by name.
+const snapshotLayout = "2006-01-02T150405"
+
+// takeSnapshot hardlinks the photos in folder into a new snapshot in dir, points
+// current at it, and removes all but the newest keep snapshots. Hidden files and
+// folders, such as the manifest and the archive, are left out.
+func takeSnapshot(dir, folder string, keep int) {
+	if dir == "" {
+		return
+	}
+	name := time.Now().Format(snapshotLayout)
+	// Build the snapshot under a temporary name so a half-made one is never used
+	tmp := filepath.Join(dir, name+".part")
+	os.RemoveAll(tmp)
+	if err := os.MkdirAll(tmp, 0o755); err != nil {
+		log.Printf("Unable to snapshot %s: %v", folder, err)
+		return
+	}
+	files := 0
+	err := filepath.WalkDir(folder, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == folder {
+			return nil
+		}
+		if path == dir {
+			// The snapshots themselves may be kept inside the folder
+			return filepath.SkipDir
+		}
+		if strings.HasPrefix(d.Name(), ".") || strings.HasSuffix(d.Name(), ".part") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(folder, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(tmp, rel), 0o755)
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if err := os.MkdirAll(filepath.Dir(filepath.Join(tmp, rel)), 0o755); err != nil {
+			return err
+		}
+		files++
+		return copyFile(path, filepath.Join(tmp, rel))
+	})
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(dir, name))
+	}
+	if err == nil {
+		err = useSnapshot(dir, name)
+	}
+	if err != nil {
+		os.RemoveAll(tmp)
+		log.Printf("Unable to snapshot %s: %v", folder, err)
+		return
+	}
+	report(fmt.Sprintf("Snapshot %s taken of %d files.", name, files), "Snapshot taken", "snapshot", name, "files", files)
+	pruneSnapshots(dir, keep)
+}
+
+// listSnapshots returns the names of the snapshots in dir, oldest first.
+func listSnapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if _, err := time.Parse(snapshotLayout, entry.Name()); err == nil {
+			names = append(names, entry.Name())
+		}
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// inUse returns the name of the snapshot current points to, or "" if none.
+func inUse(dir string) string {
+	target, err := os.Readlink(filepath.Join(dir, currentSnapshot))
+	if err != nil {
+		return ""
+	}
+	return filepath.Base(target)
+}
+
+// useSnapshot points current at the named snapshot. The link is replaced by a
+// rename, so frames never find it missing.
+func useSnapshot(dir, name string) error {
+	link := filepath.Join(dir, currentSnapshot)
+	tmp := link + ".part"
+	os.Remove(tmp)
+	if err := os.Symlink(name, tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, link); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir, never removing
+// the one in use. A keep of 0 or less keeps them all.
+func pruneSnapshots(dir string, keep int) {
+	if keep <= 0 {
+		return
+	}
+	names, err := listSnapshots(dir)
+	if err != nil {
+		log.Printf("Unable to list snapshots: %v", err)
+		return
+	}
+	current := inUse(dir)
+	for _, name := range names[:max(len(names)-keep, 0)] {
+		if name == current {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+			log.Printf("Unable to remove snapshot %s: %v", name, err)
+			continue
+		}
+		report("Removed snapshot "+name, "Removed snapshot", "snapshot", name)
+	}
+}
+
+// runSnapshots lists the snapshots of a frame folder or, with -use, rolls the
+// frame back (or forward) to one of them.
+func runSnapshots(args []string) {
+	fs := flag.NewFlagSet("snapshots", flag.ExitOnError)
+	dirPtr := fs.String("snapshots", "", "Folder holding the snapshots taken by sync -snapshots")
+	usePtr := fs.String("use", "", "Point current at this snapshot, e.g. 2024-05-01T030000, or \"previous\" for the one before the current one")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if *dirPtr == "" {
+		log.Fatal("You must specify the snapshots folder using the -snapshots flag.")
+	}
+	names, err := listSnapshots(*dirPtr)
+	if err != nil {
+		log.Fatalf("Unable to list snapshots: %v", err)
+	}
+	current := inUse(*dirPtr)
+
+	if *usePtr == "" {
+		if len(names) == 0 {
+			report("No snapshots yet.", "No snapshots")
+			return
+		}
+		for _, name := range names {
+			marker := "  "
+			if name == current {
+				marker = "* "
+			}
+			report(marker+name, "Snapshot", "snapshot", name, "current", name == current)
+		}
+		return
+	}
+
+	name := *usePtr
+	if name == "previous" {
+		i := slices.Index(names, current)
+		if i <= 0 {
+			log.Fatal("There is no snapshot before the current one.")
+		}
+		name = names[i-1]
+	}
+	if !slices.Contains(names, name) {
+		log.Fatalf("No snapshot %q in %s", name, *dirPtr)
+	}
+	if err := useSnapshot(*dirPtr, name); err != nil {
+		log.Fatalf("Unable to switch snapshots: %v", err)
+	}
+	report(fmt.Sprintf("Frame now shows snapshot %s.", name), "Snapshot in use", "snapshot", name, "previous", current)
+}
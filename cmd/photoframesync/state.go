@@ -0,0 +1,308 @@
+// state.go
+//
+// The state command, which moves a sync set-up to new hardware. state export bundles
+// the profiles config, a folder's manifest and optionally the OAuth tokens into one
+// archive, and state import puts them in place on the new host. Unless the tokens
+// are included, flags that may hold secrets are left out of the archived config.
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/manifest"
+)
+
+// Names of the files inside a state archive.
+const (
+	stateConfigName      = "config.json"
+	stateManifestName    = "manifest.json"
+	stateCredentialsName = "credentials.json"
+	stateTokensDir       = "tokens"
+)
+
+// runState dispatches to state export or state import.
+func runState(args []string) {
+	if len(args) == 0 {
+		log.Fatal("Usage: state export|import [flags] <archive>")
+	}
+	switch args[0] {
+	case "export":
+		runStateExport(args[1:])
+	case "import":
+		runStateImport(args[1:])
+	default:
+		log.Fatalf("Unknown state command %q. Available commands: export, import", args[0])
+	}
+}
+
+// stateFile is a file to put in a state archive.
+type stateFile struct {
+	name string
+	path string
+	mode int64
+	// data, if set, is archived in place of the file's contents.
+	data []byte
+}
+
+// stateSecretFlags are the config flags left out of a state archive made without
+// the tokens, as well as any with token, secret or password in their name:
+// -kiosk values are device tokens, -proxy may carry a password, and hooks are
+// often commands with a webhook's key in them.
+var stateSecretFlags = []string{"kiosk", "proxy", "hook-before-sync", "hook-after-sync", "hook-before-item", "hook-after-item"}
+
+// secretFlag reports whether the flag called name may hold a secret.
+func secretFlag(name string) bool {
+	for _, word := range []string{"token", "secret", "password"} {
+		if strings.Contains(name, word) {
+			return true
+		}
+	}
+	return slices.Contains(stateSecretFlags, name)
+}
+
+// redactConfig returns a profiles config without the flags that may hold
+// secrets, and the profile.flag names of those it left out. Whatever else the
+// file holds is kept as it is.
+func redactConfig(data []byte) ([]byte, []string, error) {
+	var cfg map[string]any
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, nil, fmt.Errorf("invalid config: %v", err)
+	}
+	var removed []string
+	profiles, _ := cfg["profiles"].(map[string]any)
+	for name, p := range profiles {
+		p, _ := p.(map[string]any)
+		flags, _ := p["flags"].(map[string]any)
+		for flag := range flags {
+			if secretFlag(flag) {
+				delete(flags, flag)
+				removed = append(removed, name+"."+flag)
+			}
+		}
+	}
+	slices.Sort(removed)
+	out, err := json.MarshalIndent(cfg, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(out, '\n'), removed, nil
+}
+
+// runStateExport writes the state archive.
+func runStateExport(args []string) {
+	fs := flag.NewFlagSet("state export", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder whose manifest to include")
+	tokensPtr := fs.Bool("include-tokens", false, "Also include the OAuth tokens and the client credentials they were issued to; keep the archive private")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	common.defaultFolder(folderPtr)
+
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: state export [-folder <folder>] [-include-tokens] <archive>")
+	}
+
+	var files []stateFile
+	add := func(name, path string, mode int64) {
+		if _, err := os.Stat(path); err == nil {
+			files = append(files, stateFile{name: name, path: path, mode: mode})
+		}
+	}
+	if data, err := os.ReadFile(configPath()); err == nil {
+		if !*tokensPtr {
+			var removed []string
+			data, removed, err = redactConfig(data)
+			if err != nil {
+				log.Fatalf("Unable to export %s: %v", configPath(), err)
+			}
+			for _, name := range removed {
+				report(fmt.Sprintf("Left %s out of the config; it may hold a secret, so set it again on the new host", name),
+					"Left out of the config", "flag", name)
+			}
+		}
+		files = append(files, stateFile{name: stateConfigName, path: configPath(), mode: 0o600, data: data})
+	}
+	if *folderPtr != "" {
+		add(stateManifestName, filepath.Join(*folderPtr, manifest.FileName), 0o644)
+	}
+	if *tokensPtr {
+		add(stateCredentialsName, credentialsPath(), 0o600)
+		tokens, _ := filepath.Glob(filepath.Join(stateDir, "token*.json"))
+		if !slices.Contains(tokens, tokenPath()) {
+			tokens = append(tokens, tokenPath())
+		}
+		for _, token := range tokens {
+			add(path.Join(stateTokensDir, filepath.Base(token)), token, 0o600)
+		}
+	}
+	if len(files) == 0 {
+		log.Fatal("Nothing to export: no config, manifest or tokens found.")
+	}
+
+	if err := writeStateArchive(fs.Arg(0), files); err != nil {
+		log.Fatalf("Unable to write %s: %v", fs.Arg(0), err)
+	}
+	for _, f := range files {
+		report("Exported "+f.path, "Exported", "file", f.path)
+	}
+	report("State written to "+fs.Arg(0), "State written", "file", fs.Arg(0))
+}
+
+// writeStateArchive writes files into a gzipped tar at archivePath.
+func writeStateArchive(archivePath string, files []stateFile) error {
+	out, err := os.OpenFile(archivePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+	for _, f := range files {
+		data := f.data
+		if data == nil {
+			var err error
+			if data, err = os.ReadFile(f.path); err != nil {
+				return err
+			}
+		}
+		header := &tar.Header{Name: f.name, Mode: f.mode, Size: int64(len(data)), ModTime: time.Now()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// runStateImport unpacks a state archive, putting each file where this host's
+// flags say it belongs. Existing files are kept unless -force is given. Every
+// file is written readable by its owner alone, whatever the archive says, since
+// the archive may come from anywhere.
+func runStateImport(args []string) {
+	fs := flag.NewFlagSet("state import", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to restore the manifest into")
+	forcePtr := fs.Bool("force", false, "Replace files that already exist")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	common.defaultFolder(folderPtr)
+
+	if fs.NArg() != 1 {
+		log.Fatal("Usage: state import [-folder <folder>] [-force] <archive>")
+	}
+
+	in, err := os.Open(fs.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	gz, err := gzip.NewReader(in)
+	if err != nil {
+		log.Fatalf("Invalid state archive: %v", err)
+	}
+	tr := tar.NewReader(gz)
+
+	// The manifest must not change under a sync into the folder
+	if *folderPtr != "" {
+		lock, ok := prepareFolder(*folderPtr, common.lockWait)
+		if !ok {
+			return
+		}
+		defer lock.Release()
+	}
+
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			log.Fatalf("Invalid state archive: %v", err)
+		}
+		dst, ok := stateDestination(header.Name, *folderPtr)
+		if !ok {
+			report("Skipping "+header.Name, "Skipping", "file", header.Name)
+			continue
+		}
+		if _, err := os.Stat(dst); err == nil && !*forcePtr {
+			report(fmt.Sprintf("Keeping existing %s; use -force to replace it", dst), "Keeping existing", "file", dst)
+			continue
+		}
+		if err := importStateFile(dst, tr, common.lockWait); err != nil {
+			log.Fatalf("Unable to write %s: %v", dst, err)
+		}
+		report("Imported "+dst, "Imported", "file", dst)
+	}
+}
+
+// stateDestination returns where an archived file goes on this host. It reports
+// false for files it does not recognise, including a manifest when no folder was
+// given.
+func stateDestination(name, folder string) (string, bool) {
+	switch name {
+	case stateConfigName:
+		return configPath(), true
+	case stateCredentialsName:
+		return credentialsPath(), true
+	case stateManifestName:
+		return filepath.Join(folder, manifest.FileName), folder != ""
+	}
+	dir, base := path.Split(name)
+	if dir == stateTokensDir+"/" && strings.HasSuffix(base, ".json") {
+		return filepath.Join(stateDir, base), true
+	}
+	return "", false
+}
+
+// importStateFile writes an archived file to dst, holding the token's lock if it
+// is a token so that a refresh in another process cannot interleave with it.
+func importStateFile(dst string, r io.Reader, lockWait time.Duration) error {
+	if filepath.Dir(dst) == filepath.Clean(stateDir) && strings.HasPrefix(filepath.Base(dst), "token") {
+		lock, err := acquireLock(dst+".lock", lockWait)
+		if err != nil {
+			return err
+		}
+		defer lock.Release()
+	}
+	return writeStateFile(dst, r)
+}
+
+// writeStateFile writes r to dst with only its owner able to read it, creating
+// its folder if needed. A file that already exists is narrowed to the owner too.
+func writeStateFile(dst string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := out.Chmod(0o600); err != nil {
+		out.Close()
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
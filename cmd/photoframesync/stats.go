@@ -0,0 +1,134 @@
+// stats.go
+//
+// The /stats endpoint of serve, which sums up what is on the frame from the
+// folder's manifest, and how the syncs serve has run since it started went, as
+// JSON for a home dashboard to chart.
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/manifest"
+)
+
+// maxSyncStats is how many syncs /stats remembers.
+const maxSyncStats = 100
+
+// syncStats is the outcome of one sync run by serve.
+type syncStats struct {
+	Finished    time.Time `json:"finished"`
+	Downloaded  int       `json:"downloaded"`
+	Existing    int       `json:"existing"`
+	Filtered    int       `json:"filtered"`
+	Failed      int       `json:"failed"`
+	Interrupted bool      `json:"interrupted,omitempty"`
+	// Bytes is the size of the frame's files once the sync finished.
+	Bytes int64 `json:"bytes"`
+}
+
+// frameStats sums up a folder's manifest and the syncs into it.
+type frameStats struct {
+	Updated time.Time `json:"updated"`
+	// Selected counts the items picked, OnFrame those with a file the frame
+	// shows, and NotOnFrame those filtered out or that failed to download.
+	Selected   int `json:"selected"`
+	OnFrame    int `json:"onFrame"`
+	NotOnFrame int `json:"notOnFrame"`
+	Archived   int `json:"archived"`
+	// Local counts files made on the host, such as drops and collages.
+	Local int `json:"local"`
+	// ByYear counts the files on the frame by the year they were taken.
+	ByYear map[string]int `json:"byYear"`
+	Bytes  int64          `json:"bytes"`
+
+	// Syncs are the latest syncs run by serve, oldest first, and FailureRate
+	// is the share of their downloads that failed.
+	Syncs       []syncStats `json:"syncs"`
+	FailureRate float64     `json:"failureRate"`
+}
+
+// folderStats sums up the manifest of folder.
+func folderStats(folder string) (frameStats, error) {
+	m, err := manifest.Load(folder)
+	if err != nil {
+		return frameStats{}, err
+	}
+	stats := frameStats{Updated: m.Updated, ByYear: make(map[string]int)}
+	for _, entry := range m.Items {
+		stats.Selected++
+		switch {
+		case entry.Filename == "":
+			stats.NotOnFrame++
+			continue
+		case entry.Archived:
+			stats.Archived++
+			continue
+		}
+		stats.OnFrame++
+		if entry.Local() {
+			stats.Local++
+		}
+		if taken, err := time.Parse(time.RFC3339, entry.CreateTime); err == nil {
+			stats.ByYear[taken.Format("2006")]++
+		}
+		if info, err := os.Stat(filepath.Join(folder, entry.Filename)); err == nil {
+			stats.Bytes += info.Size()
+		}
+	}
+	return stats, nil
+}
+
+// recordSync remembers the outcome of a sync for /stats.
+func (s *familyServer) recordSync(result download.Result) {
+	stats := syncStats{
+		Finished:    time.Now(),
+		Downloaded:  result.Downloaded,
+		Existing:    result.Existing,
+		Filtered:    result.Filtered,
+		Failed:      result.Failed,
+		Interrupted: result.Interrupted,
+	}
+	if folder, err := folderStats(s.folder); err == nil {
+		stats.Bytes = folder.Bytes
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.syncs = append(s.syncs, stats)
+	if len(s.syncs) > maxSyncStats {
+		s.syncs = slices.Delete(s.syncs, 0, len(s.syncs)-maxSyncStats)
+	}
+}
+
+// showStats writes the frame's statistics.
+func (s *familyServer) showStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := folderStats(s.folder)
+	if err != nil {
+		log.Printf("Unable to read the manifest for /stats: %v", err)
+		http.Error(w, "Unable to read the manifest", http.StatusInternalServerError)
+		return
+	}
+	s.mu.Lock()
+	stats.Syncs = slices.Clone(s.syncs)
+	s.mu.Unlock()
+	if stats.Syncs == nil {
+		stats.Syncs = []syncStats{}
+	}
+	attempted, failed := 0, 0
+	for _, run := range stats.Syncs {
+		attempted += run.Downloaded + run.Failed
+		failed += run.Failed
+	}
+	if attempted > 0 {
+		stats.FailureRate = float64(failed) / float64(attempted)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,64 @@
+// storage.go
+//
+// Preparing the target storage for a sync: optional read-write remounting of the
+// SD card and the final flush of everything written.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os/exec"
+
+	"PhotoSync/pkg/download"
+)
+
+// sdFriendly writes files the SD-card friendly way described at
+// download.WithSDFriendly.
+var sdFriendly = false
+
+// writeOptions are the download options for how files are written.
+func writeOptions() []download.Option {
+	if sdFriendly {
+		return []download.Option{download.WithSDFriendly()}
+	}
+	return nil
+}
+
+// folderWriter returns a downloader that only copies local files into folder,
+// writing them the same way as downloads.
+func folderWriter(folder string) *download.Downloader {
+	return download.NewDownloader(nil, folder, writeOptions()...)
+}
+
+// remount remounts the filesystem at mountPoint with the given mode ("ro" or "rw").
+// This needs root, so it is typically combined with running as root without -run-as.
+func remount(mountPoint string, mode string) error {
+	out, err := exec.Command("mount", "-o", "remount,"+mode, mountPoint).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("mount -o remount,%s %s: %v: %s", mode, mountPoint, err, out)
+	}
+	return nil
+}
+
+// beginWrites prepares the target storage for writing and returns a function that
+// flushes everything written by writers and, if requested, returns the card to
+// read-only.
+func (c *commonFlags) beginWrites(writers ...*download.Downloader) func() {
+	if c.remountReadOnly != "" {
+		if err := remount(c.remountReadOnly, "rw"); err != nil {
+			log.Fatalf("Unable to make %s writable: %v", c.remountReadOnly, err)
+		}
+	}
+	return func() {
+		for _, writer := range writers {
+			if err := writer.FlushOutputFiles(); err != nil {
+				log.Printf("Error flushing files: %v", err)
+			}
+		}
+		if c.remountReadOnly != "" {
+			if err := remount(c.remountReadOnly, "ro"); err != nil {
+				log.Printf("Unable to return %s to read-only: %v", c.remountReadOnly, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,424 @@
+// stream.go
+//
+// Streaming mode of serve, for hosts with almost no storage such as a router or a
+// board with a small SD card: the selection is remembered rather than downloaded,
+// and each photo is fetched from Google Photos as a kiosk asks for it. Recently
+// shown photos are kept in a cache of limited size, in memory or on disk, and the
+// selection's baseUrls are listed afresh before they expire.
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/picker"
+)
+
+// streamFileName is the file inside the served folder holding the selection being
+// streamed, so that a restarted server carries on while its session lasts.
+const streamFileName = ".photosync-stream.json"
+
+// streamRefreshMargin is how long before they expire the selection's baseUrls are
+// listed afresh.
+const streamRefreshMargin = 5 * time.Minute
+
+// errNotStreamed is returned for items that are not in the streamed selection.
+var errNotStreamed = errors.New("not in the streamed selection")
+
+// streamItem is a streamed item, with what is needed to fetch it again once its
+// baseUrl has been listed afresh.
+type streamItem struct {
+	ID       string `json:"id"`
+	Filename string `json:"filename"`
+	MimeType string `json:"mimeType"`
+	BaseURL  string `json:"baseUrl"`
+	// Variant is the suffix appended to BaseURL to fetch the file, e.g. "=d".
+	Variant string `json:"variant"`
+	// CreateTime is when the item was taken, for -kiosk-order chronological.
+	CreateTime string `json:"createTime,omitempty"`
+}
+
+// streamState is the selection being streamed and when its baseUrls were listed.
+type streamState struct {
+	SessionID string       `json:"sessionId"`
+	ListedAt  time.Time    `json:"listedAt"`
+	Items     []streamItem `json:"items"`
+}
+
+// streamer serves the items of a selection straight from Google Photos.
+type streamer struct {
+	client *http.Client
+	picker *picker.PickerClient
+	cache  *streamCache
+	path   string
+
+	mu    sync.Mutex
+	state streamState
+}
+
+// newStreamer returns a streamer keeping its selection in folder, carrying on with
+// the one streamed before a restart if there is one.
+func newStreamer(client *http.Client, pickerClient *picker.PickerClient, folder string, cache *streamCache) *streamer {
+	s := &streamer{client: client, picker: pickerClient, cache: cache, path: filepath.Join(folder, streamFileName)}
+	data, err := os.ReadFile(s.path)
+	if err == nil {
+		err = json.Unmarshal(data, &s.state)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		log.Printf("Unable to read the streamed selection, choose photos again: %v", err)
+	}
+	return s
+}
+
+// use starts streaming the items planned from session's selection.
+func (s *streamer) use(sessionID string, planned []*download.Item) error {
+	items := make([]streamItem, len(planned))
+	for i, item := range planned {
+		items[i] = streamItem{
+			ID:         item.Id,
+			Filename:   item.Filename,
+			MimeType:   item.MediaFile.MimeType,
+			BaseURL:    item.MediaFile.BaseUrl,
+			Variant:    strings.TrimPrefix(item.URL, item.MediaFile.BaseUrl),
+			CreateTime: item.CreateTime,
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = streamState{SessionID: sessionID, ListedAt: time.Now(), Items: items}
+	return s.save()
+}
+
+// save writes the state to the folder. s.mu must be held.
+func (s *streamer) save() error {
+	data, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(s.path, data, 0o644)
+}
+
+// items returns the streamed selection.
+func (s *streamer) items() []streamItem {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.state.Items)
+}
+
+// item returns the streamed item id with a baseUrl that has not expired, listing
+// the session afresh first if they are about to, or if relist is set because one
+// was refused.
+func (s *streamer) item(ctx context.Context, id string, relist bool) (streamItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	age := time.Since(s.state.ListedAt)
+	// A burst of refusals lists the session once, not once per request
+	if age > baseURLLifetime-streamRefreshMargin || (relist && age > time.Minute) {
+		if err := s.relist(ctx); err != nil {
+			return streamItem{}, err
+		}
+	}
+	for _, item := range s.state.Items {
+		if item.ID == id {
+			return item, nil
+		}
+	}
+	return streamItem{}, errNotStreamed
+}
+
+// relist updates the baseUrls of the streamed items from a fresh listing of the
+// session. s.mu must be held.
+func (s *streamer) relist(ctx context.Context) error {
+	listed, err := s.picker.ListMediaItems(ctx, s.state.SessionID)
+	if err != nil {
+		return fmt.Errorf("unable to list the selection afresh: %v", err)
+	}
+	baseURLs := make(map[string]string, len(listed.MediaItems))
+	for _, picked := range listed.MediaItems {
+		baseURLs[picked.Id] = picked.MediaFile.BaseUrl
+	}
+	for i, item := range s.state.Items {
+		if baseURL, ok := baseURLs[item.ID]; ok {
+			s.state.Items[i].BaseURL = baseURL
+		}
+	}
+	s.state.ListedAt = time.Now()
+	if err := s.save(); err != nil {
+		log.Printf("Unable to record the streamed selection: %v", err)
+	}
+	return nil
+}
+
+// fetch starts fetching item from Google Photos, asking for rangeHeader if set.
+// A refused fetch is tried once more with the item's baseUrl listed afresh.
+func (s *streamer) fetch(ctx context.Context, item streamItem, rangeHeader string) (*http.Response, error) {
+	for attempt := 1; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, item.BaseURL+item.Variant, nil)
+		if err != nil {
+			return nil, err
+		}
+		if rangeHeader != "" {
+			req.Header.Set("Range", rangeHeader)
+		}
+		resp, err := s.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		// Expired baseUrls are refused rather than redirected
+		if (resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusNotFound) && attempt == 1 {
+			resp.Body.Close()
+			if item, err = s.item(ctx, item.ID, true); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", item.Filename, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// serve writes the streamed item id, from the cache if it is there.
+func (s *streamer) serve(w http.ResponseWriter, r *http.Request, id string) {
+	item, err := s.item(r.Context(), id, false)
+	if errors.Is(err, errNotStreamed) {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		log.Printf("Unable to stream %s: %v", id, err)
+		http.Error(w, "Unable to reach Google Photos", http.StatusBadGateway)
+		return
+	}
+	if item.MimeType != "" {
+		w.Header().Set("Content-Type", item.MimeType)
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("max-age=%d", int(time.Hour.Seconds())))
+	key := item.ID + item.Variant
+	if content, ok := s.cache.get(key); ok {
+		defer content.Close()
+		http.ServeContent(w, r, "", time.Time{}, content)
+		return
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	resp, err := s.fetch(r.Context(), item, rangeHeader)
+	if err != nil {
+		log.Printf("Unable to stream %s: %v", item.Filename, err)
+		http.Error(w, "Unable to reach Google Photos", http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	head, err := io.ReadAll(io.LimitReader(resp.Body, s.cache.entryLimit()+1))
+	if err != nil {
+		log.Printf("Unable to stream %s: %v", item.Filename, err)
+		http.Error(w, "Unable to reach Google Photos", http.StatusBadGateway)
+		return
+	}
+	if rangeHeader == "" && int64(len(head)) <= s.cache.entryLimit() {
+		s.cache.put(key, head)
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(head))
+		return
+	}
+
+	// Too big to cache, such as most videos, or only part of the file: pass it on
+	for _, name := range []string{"Content-Length", "Content-Range", "Accept-Ranges"} {
+		if value := resp.Header.Get(name); value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	if item.MimeType == "" {
+		w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, io.MultiReader(bytes.NewReader(head), resp.Body))
+}
+
+// kioskStream serves a streamed item to a kiosk showing the served folder.
+func (s *familyServer) kioskStream(w http.ResponseWriter, r *http.Request) {
+	dir, ok := s.kioskFolder(w, r)
+	if !ok {
+		return
+	}
+	if dir != s.folder {
+		http.NotFound(w, r)
+		return
+	}
+	s.stream.serve(w, r, r.PathValue("id"))
+}
+
+// streamCache keeps recently streamed files up to a total size, in memory or, if
+// dir is set, as files in dir, dropping the least recently used first.
+type streamCache struct {
+	dir   string
+	limit int64
+
+	mu      sync.Mutex
+	size    int64
+	order   *list.List // of *cacheEntry, most recently used at the front
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached file, named by the hash of its key.
+type cacheEntry struct {
+	name string
+	size int64
+	// data is nil for entries kept on disk.
+	data []byte
+}
+
+// memoryFile lets a cached file held in memory be served like one on disk.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error { return nil }
+
+// newStreamCache returns a cache of limit bytes. Files left in dir by an earlier
+// run are kept, the most recently written first. Only files named as the cache
+// names them are taken as its own, so a dir shared with other files never has
+// them evicted.
+func newStreamCache(dir string, limit int64) (*streamCache, error) {
+	c := &streamCache{dir: dir, limit: limit, order: list.New(), entries: make(map[string]*list.Element)}
+	if dir == "" {
+		return c, nil
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type found struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var files []found
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || !isCacheName(entry.Name()) {
+			continue
+		}
+		files = append(files, found{entry.Name(), info.Size(), info.ModTime()})
+	}
+	slices.SortFunc(files, func(a, b found) int { return b.modTime.Compare(a.modTime) })
+	for _, file := range files {
+		c.entries[file.name] = c.order.PushBack(&cacheEntry{name: file.name, size: file.size})
+		c.size += file.size
+	}
+	c.evict()
+	return c, nil
+}
+
+// entryLimit is the size of the largest file cached, kept to a quarter of the
+// cache so that one video cannot push out every photo.
+func (c *streamCache) entryLimit() int64 {
+	return c.limit / 4
+}
+
+// name returns the name of key's entry, which is also its file name on disk.
+func (c *streamCache) name(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// isCacheName reports whether name is one name gives entries: a hex SHA-256.
+func isCacheName(name string) bool {
+	sum, err := hex.DecodeString(name)
+	return err == nil && len(sum) == sha256.Size && name == strings.ToLower(name)
+}
+
+// get returns the cached file for key, reporting false if it is not cached.
+func (c *streamCache) get(key string) (io.ReadSeekCloser, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[c.name(key)]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	entry := element.Value.(*cacheEntry)
+	if entry.data != nil {
+		return memoryFile{bytes.NewReader(entry.data)}, true
+	}
+	f, err := os.Open(filepath.Join(c.dir, entry.name))
+	if err != nil {
+		c.remove(element)
+		return nil, false
+	}
+	return f, true
+}
+
+// put caches data for key, unless it is larger than entryLimit.
+func (c *streamCache) put(key string, data []byte) {
+	size := int64(len(data))
+	if size > c.entryLimit() {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	name := c.name(key)
+	if _, ok := c.entries[name]; ok {
+		return
+	}
+	entry := &cacheEntry{name: name, size: size}
+	if c.dir == "" {
+		entry.data = data
+	} else {
+		path := filepath.Join(c.dir, name)
+		if err := os.WriteFile(path+".part", data, 0o644); err != nil {
+			log.Printf("Unable to cache a streamed file: %v", err)
+			return
+		}
+		if err := os.Rename(path+".part", path); err != nil {
+			log.Printf("Unable to cache a streamed file: %v", err)
+			return
+		}
+	}
+	c.entries[name] = c.order.PushFront(entry)
+	c.size += size
+	c.evict()
+}
+
+// evict drops the least recently used entries until the cache fits its limit.
+// c.mu must be held.
+func (c *streamCache) evict() {
+	for c.size > c.limit && c.order.Len() > 0 {
+		c.remove(c.order.Back())
+	}
+}
+
+// remove drops an entry, deleting its file if it is on disk. c.mu must be held.
+func (c *streamCache) remove(element *list.Element) {
+	entry := c.order.Remove(element).(*cacheEntry)
+	delete(c.entries, entry.name)
+	c.size -= entry.size
+	if c.dir != "" {
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Printf("Unable to remove %s from the stream cache: %v", entry.name, err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+// update.go
+//
+// The update command, which replaces the running binary with the latest release
+// from GitHub, for frames at relatives' homes that nobody will update by hand.
+// Releases carry a SHA256SUMS file, which the download is checked against, and a
+// signature of that file made with the release key. A checksum from the same
+// release says nothing about who published it, so without a key to check the
+// signature against update refuses unless told -insecure.
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// releasesURL is the GitHub API endpoint of the latest release.
+const releasesURL = "https://api.github.com/repos/amccormick21/PhotoFrameSync/releases/latest"
+
+// releasePublicKey is the base64 Ed25519 key release checksums are signed with.
+// Release builds set it with -ldflags "-X main.releasePublicKey=...".
+var releasePublicKey = ""
+
+// version is the release the binary was built from. Release builds set it with
+// -ldflags "-X main.version=v1.2.3".
+var version = ""
+
+// release is the part of a GitHub release update uses.
+type release struct {
+	Tag    string `json:"tag_name"`
+	Assets []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// asset returns the download URL of the named asset, or "" if there is none.
+func (r release) asset(name string) string {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL
+		}
+	}
+	return ""
+}
+
+// binaryAsset is the name of the release asset built for this platform.
+func binaryAsset() string {
+	name := fmt.Sprintf("photoframesync-%s-%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// currentVersion is the version of the running binary, or "" if it was neither
+// given one at build time nor built from a tagged module, e.g. with go install.
+func currentVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return ""
+}
+
+// newerVersion reports whether version a is newer than b, comparing vMAJOR.MINOR.PATCH
+// numerically. Anything after the patch number, such as a pre-release, is ignored.
+func newerVersion(a, b string) bool {
+	parse := func(v string) [3]int {
+		var parts [3]int
+		fields := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+		for i, field := range fields {
+			field, _, _ = strings.Cut(field, "-")
+			parts[i], _ = strconv.Atoi(field)
+		}
+		return parts
+	}
+	pa, pb := parse(a), parse(b)
+	for i := range pa {
+		if pa[i] != pb[i] {
+			return pa[i] > pb[i]
+		}
+	}
+	return false
+}
+
+// runUpdate installs the latest release if it is newer than the running binary.
+func runUpdate(args []string) {
+	fs := flag.NewFlagSet("update", flag.ExitOnError)
+	checkPtr := fs.Bool("check", false, "Only report whether an update is available")
+	forcePtr := fs.Bool("force", false, "Install the latest release even if it is not newer, e.g. over a development build")
+	keyPtr := fs.String("public-key", releasePublicKey, "Base64 Ed25519 key the release's SHA256SUMS must be signed with")
+	insecurePtr := fs.Bool("insecure", false, "Install without a -public-key to check the release's signature, trusting the checksums it ships with")
+	restartPtr := fs.String("restart", "", "Command to run once updated to restart the running service, e.g. \"systemctl restart photosync\"")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	if common.container {
+		log.Fatal("Running in a container: pull the new image instead of updating the binary.")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+	client := httpClient()
+
+	var latest release
+	if err := getJSON(ctx, client, releasesURL, &latest); err != nil {
+		log.Fatalf("Unable to check for updates: %v", err)
+	}
+	current := currentVersion()
+	if !*forcePtr && (current == "" || !newerVersion(latest.Tag, current)) {
+		if current == "" {
+			report(fmt.Sprintf("This is a development build; the latest release is %s. Use -force to install it.", latest.Tag),
+				"Development build", "latest", latest.Tag)
+		} else {
+			report(fmt.Sprintf("Up to date: %s is the latest release.", current), "Up to date", "version", current)
+		}
+		return
+	}
+	if *checkPtr {
+		report(fmt.Sprintf("Update available: %s (running %s).", latest.Tag, current), "Update available", "latest", latest.Tag, "current", current)
+		return
+	}
+
+	binaryURL, sumsURL := latest.asset(binaryAsset()), latest.asset("SHA256SUMS")
+	if binaryURL == "" || sumsURL == "" {
+		log.Fatalf("Release %s has no %s and SHA256SUMS to update from.", latest.Tag, binaryAsset())
+	}
+	sums, err := getBytes(ctx, client, sumsURL)
+	if err != nil {
+		log.Fatalf("Unable to download SHA256SUMS: %v", err)
+	}
+	if *keyPtr != "" {
+		if err := verifySignature(ctx, client, latest, sums, *keyPtr); err != nil {
+			log.Fatalf("Not updating, the release's signature is bad: %v", err)
+		}
+	} else if !*insecurePtr {
+		log.Fatal("Not updating: this build has no release key to check the download was published by the project. Give one with -public-key, or use -insecure to trust the release's checksums alone.")
+	} else {
+		log.Printf("Warning: installing %s without checking its signature", latest.Tag)
+	}
+	want := expectedDigest(sums, binaryAsset())
+	if want == "" {
+		log.Fatalf("SHA256SUMS of %s has no checksum for %s.", latest.Tag, binaryAsset())
+	}
+
+	exe, err := os.Executable()
+	if err == nil {
+		exe, err = filepath.EvalSymlinks(exe)
+	}
+	if err != nil {
+		log.Fatalf("Unable to find the running binary: %v", err)
+	}
+	if err := downloadBinary(ctx, client, binaryURL, want, exe); err != nil {
+		log.Fatalf("Update failed: %v", err)
+	}
+	report(fmt.Sprintf("Updated to %s.", latest.Tag), "Updated", "version", latest.Tag, "previous", current)
+
+	if *restartPtr != "" {
+		if err := runRestart(*restartPtr); err != nil {
+			log.Fatalf("Updated, but unable to restart: %v", err)
+		}
+	}
+}
+
+// getJSON fetches url and decodes its JSON body into v.
+func getJSON(ctx context.Context, client *http.Client, url string, v any) error {
+	data, err := getBytes(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// getBytes fetches url, failing on any status but 200.
+func getBytes(ctx context.Context, client *http.Client, url string) ([]byte, error) {
+	resp, err := get(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// get starts fetching url, failing on any status but 200.
+func get(ctx context.Context, client *http.Client, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return resp, nil
+}
+
+// verifySignature checks sums against the release's SHA256SUMS.sig, a base64
+// Ed25519 signature, made with the private half of publicKey.
+func verifySignature(ctx context.Context, client *http.Client, r release, sums []byte, publicKey string) error {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("invalid public key")
+	}
+	sigURL := r.asset("SHA256SUMS.sig")
+	if sigURL == "" {
+		return errors.New("the release is not signed")
+	}
+	encoded, err := getBytes(ctx, client, sigURL)
+	if err != nil {
+		return err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || !ed25519.Verify(key, sums, sig) {
+		return errors.New("SHA256SUMS.sig does not match")
+	}
+	return nil
+}
+
+// expectedDigest returns the checksum sums lists for name, or "".
+func expectedDigest(sums []byte, name string) string {
+	for _, line := range strings.Split(string(sums), "\n") {
+		digest, file, ok := strings.Cut(strings.TrimSpace(line), " ")
+		if ok && strings.TrimLeft(file, " *") == name {
+			return strings.ToLower(digest)
+		}
+	}
+	return ""
+}
+
+// downloadBinary downloads the new binary beside exe, checks it against digest
+// and renames it over exe. Windows cannot replace a running binary, but can
+// rename it out of the way first.
+func downloadBinary(ctx context.Context, client *http.Client, url, digest, exe string) error {
+	resp, err := get(ctx, client, url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".*")
+	if err != nil {
+		return fmt.Errorf("unable to write beside %s: %v", exe, err)
+	}
+	defer os.Remove(tmp.Name())
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(tmp, h), resp.Body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if got := hex.EncodeToString(h.Sum(nil)); got != digest {
+		return fmt.Errorf("checksum mismatch: got %s, expected %s", got, digest)
+	}
+	if err := os.Chmod(tmp.Name(), 0o755); err != nil {
+		return err
+	}
+
+	if runtime.GOOS == "windows" {
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp.Name(), exe); err != nil {
+			os.Rename(old, exe)
+			return err
+		}
+		return nil
+	}
+	return os.Rename(tmp.Name(), exe)
+}
+
+// runRestart runs the -restart command through the shell.
+func runRestart(command string) error {
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.Command("cmd", "/C", command)
+	} else {
+		cmd = exec.Command("sh", "-c", command)
+	}
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPublic, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, otherKey := base64.StdEncoding.EncodeToString(public), base64.StdEncoding.EncodeToString(otherPublic)
+	sums := []byte("0123abcd  photoframesync-linux-arm64\n")
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(private, sums)) + "\n"
+
+	var served string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(served))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name   string
+		key    string
+		sums   []byte
+		sig    string
+		signed bool
+		ok     bool
+	}{
+		{"signed by the key", key, sums, sig, true, true},
+		{"signed by another key", otherKey, sums, sig, true, false},
+		{"sums changed", key, []byte("ffff0000  photoframesync-linux-arm64\n"), sig, true, false},
+		{"unsigned release", key, sums, "", false, false},
+		{"signature not base64", key, sums, "not a signature", true, false},
+		{"invalid key", "c2hvcnQ=", sums, sig, true, false},
+	}
+	for _, tt := range tests {
+		served = tt.sig
+		var r release
+		if tt.signed {
+			r.Assets = append(r.Assets, struct {
+				Name string `json:"name"`
+				URL  string `json:"browser_download_url"`
+			}{"SHA256SUMS.sig", server.URL + "/SHA256SUMS.sig"})
+		}
+		err := verifySignature(context.Background(), server.Client(), r, tt.sums, tt.key)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: verifySignature = %v, want ok = %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestExpectedDigest(t *testing.T) {
+	sums := strings.Join([]string{
+		"AAAA1111  photoframesync-linux-amd64",
+		"bbbb2222 *photoframesync-windows-amd64.exe",
+		"cccc3333  photoframesync-linux-arm64.sig",
+	}, "\n")
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"photoframesync-linux-amd64", "aaaa1111"},
+		{"photoframesync-windows-amd64.exe", "bbbb2222"},
+		{"photoframesync-linux-arm64", ""},
+		{"photoframesync-darwin-arm64", ""},
+	}
+	for _, tt := range tests {
+		if got := expectedDigest([]byte(sums), tt.name); got != tt.want {
+			t.Errorf("expectedDigest(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
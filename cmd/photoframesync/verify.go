@@ -0,0 +1,87 @@
+// verify.go
+//
+// The verify command, which checks the files of a synced folder against the
+// checksums in its manifest, or in its SHA256SUMS file where there is no manifest.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"PhotoSync/pkg/manifest"
+)
+
+// runVerify re-hashes the files in the folder, lists any that are missing,
+// truncated or corrupted along with the items to download again, and exits with
+// status 1 if there were any.
+func runVerify(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	folderPtr := fs.String("folder", "", "Synced folder to verify")
+	sumsPtr := fs.Bool("sums", false, "Check against the folder's SHA256SUMS file instead of its manifest, e.g. on a copy of the frame's SD card")
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+	common.defaultFolder(folderPtr)
+
+	if *folderPtr == "" {
+		log.Fatal("You must specify a folder location using the -folder flag.")
+	}
+
+	var m *manifest.Manifest
+	var err error
+	if !*sumsPtr {
+		m, err = manifest.Load(*folderPtr)
+		if err != nil {
+			log.Fatalf("Unable to read manifest: %v", err)
+		}
+		if len(m.Items) == 0 {
+			if _, err := os.Stat(filepath.Join(*folderPtr, manifest.SumsFileName)); err != nil {
+				log.Fatalf("No manifest in %s; sync into it first.", *folderPtr)
+			}
+			report("No manifest, checking against "+manifest.SumsFileName, "Checking against checksums file", "file", manifest.SumsFileName)
+			*sumsPtr = true
+		}
+	}
+	if *sumsPtr {
+		m, err = manifest.LoadSums(*folderPtr)
+		if err != nil {
+			log.Fatalf("Unable to read %s: %v", manifest.SumsFileName, err)
+		}
+	}
+	result, err := m.Verify(*folderPtr)
+	if err != nil {
+		log.Fatalf("Verification failed: %v", err)
+	}
+
+	summary := fmt.Sprintf("Verified %d files", result.Checked)
+	if result.Unverifiable > 0 {
+		summary += fmt.Sprintf(", %d present without a recorded checksum", result.Unverifiable)
+	}
+	report(summary, "Verified", "checked", result.Checked, "unverifiable", result.Unverifiable, "problems", len(result.Problems))
+	if len(result.Problems) == 0 {
+		report("No problems found.", "No problems found")
+		return
+	}
+
+	report(fmt.Sprintf("%d problems found:", len(result.Problems)), "Problems found", "count", len(result.Problems))
+	for _, problem := range result.Problems {
+		report(fmt.Sprintf("  %-9s %s: %s", problem.Kind, problem.Entry.Filename, problem.Detail),
+			"Problem", "kind", problem.Kind, "file", problem.Entry.Filename, "detail", problem.Detail)
+	}
+	if *sumsPtr {
+		// The checksums file does not say which items the files came from
+		report("Run verify and repair on the host that syncs this folder to download them again.", "Repair plan")
+		os.Exit(1)
+	}
+	report("Repair plan: run repair to download these items again", "Repair plan")
+	for _, problem := range result.Problems {
+		report(fmt.Sprintf("  %s (%s) -> %s", problem.Entry.OriginalFilename, problem.Entry.ID, problem.Entry.Filename),
+			"Repair", "id", problem.Entry.ID, "original_file", problem.Entry.OriginalFilename, "file", problem.Entry.Filename)
+	}
+	os.Exit(1)
+}
@@ -0,0 +1,68 @@
+// web.go
+//
+// The pages serve renders, and their text in each language, which are built into
+// the binary so that copying it is a complete install. A -theme folder replaces
+// any of the files by name:
+//
+//   - pick.html is the whole of the family mode page at /pick.
+//   - kiosk.html is the whole of a device's /kiosk page. It is written for the
+//     oldest browsers still found on tablets, without ES6 or fetch, and keeps
+//     showing the photos it has whenever the server cannot be reached.
+//   - lang/LANG.json holds the family page's text and statuses in the language
+//     chosen with -lang. Text missing from a language is shown in English.
+package main
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//go:embed web/*.html web/lang/*.json
+var webFiles embed.FS
+
+// languages are the -lang values with built-in text.
+var languages = []string{"en", "de", "fr", "es"}
+
+// readWebFile reads the file called name from theme, or the built-in file if
+// theme is empty or has no such file.
+func readWebFile(theme, name string) ([]byte, error) {
+	if theme != "" {
+		data, err := os.ReadFile(filepath.Join(theme, filepath.FromSlash(name)))
+		if !os.IsNotExist(err) {
+			return data, err
+		}
+	}
+	return webFiles.ReadFile("web/" + name)
+}
+
+// loadPage parses the page called name.
+func loadPage(theme, name string) (*template.Template, error) {
+	data, err := readWebFile(theme, name)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(name).Parse(string(data))
+}
+
+// loadMessages returns the text of the family page in lang, by key.
+func loadMessages(theme, lang string) (map[string]string, error) {
+	messages := make(map[string]string)
+	for _, name := range []string{"en", lang} {
+		data, err := readWebFile(theme, "lang/"+name+".json")
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("unknown language %q: use %s, or add lang/%s.json to a -theme folder", lang, strings.Join(languages, ", "), lang)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unable to read the text for %s: %v", name, err)
+		}
+		if err := json.Unmarshal(data, &messages); err != nil {
+			return nil, fmt.Errorf("invalid %s.json: %v", name, err)
+		}
+	}
+	return messages, nil
+}
@@ -0,0 +1,43 @@
+// whoami.go
+//
+// The whoami command, which shows the Google account the saved token belongs to.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+
+	"PhotoSync/pkg/auth"
+)
+
+// runWhoami prints the Google account photos are picked from, signing in first if
+// there is no usable token.
+func runWhoami(args []string) {
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	common := registerCommonFlags(fs)
+	common.parse(fs, args)
+	if fs.NArg() > 0 {
+		log.Fatalf("unexpected argument %q", fs.Arg(0))
+	}
+
+	// Only this command needs to see the account, so only it asks for the scope
+	client, ok := authenticate(common.lockWait, auth.ProfileScope)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), common.requestTimeout)
+	defer cancel()
+	info, err := auth.FetchUserInfo(ctx, client)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	text := fmt.Sprintf("Signed in as: %s\n", info.Name)
+	if info.Email != "" {
+		text += fmt.Sprintf("Email:        %s\n", info.Email)
+	}
+	text += fmt.Sprintf("Account ID:   %s\nToken file:   %s", info.Subject, tokenPath())
+	report(text, "Signed in", "name", info.Name, "email", info.Email, "account_id", info.Subject, "token_file", tokenPath())
+}
@@ -0,0 +1,349 @@
+// pickertest.go
+//
+// Package pickertest runs a fake Google Photos Picker API on an httptest.Server, so
+// the whole pick-and-download pipeline can be exercised without talking to Google.
+// It serves the sessions and mediaItems endpoints and the media baseUrls they hand
+// out, with a configurable selection, page size, link lifetime and rate limiting.
+package pickertest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/clock"
+	"PhotoSync/pkg/picker"
+)
+
+// Item is a media item in the fake user's selection.
+type Item struct {
+	ID         string
+	Filename   string
+	Type       picker.MediaType
+	CreateTime time.Time
+	MimeType   string
+	Width      int
+	Height     int
+	// Content is served for the item's baseUrl. Defaults to a few bytes derived
+	// from the ID.
+	Content []byte
+}
+
+// Server is a fake Picker API. Point a picker.PickerClient at it with
+// picker.WithBaseURL(server.BaseURL()).
+type Server struct {
+	*httptest.Server
+
+	clock            clock.Clock
+	items            []Item
+	maxPageSize      int
+	pollsUntilPicked int
+	pollInterval     time.Duration
+	sessionTimeout   time.Duration
+	linkLifetime     time.Duration
+
+	mu          sync.Mutex
+	sessions    map[string]*session
+	nextSession int
+	rateLimited int
+	downloads   map[string]int
+}
+
+type session struct {
+	polls    int
+	maxItems int
+}
+
+// Option configures a Server.
+type Option func(*Server)
+
+// WithItems sets the items the fake user picks in every session.
+func WithItems(items ...Item) Option {
+	return func(s *Server) {
+		s.items = append(s.items, items...)
+	}
+}
+
+// WithMaxPageSize caps the number of items returned per mediaItems page, whatever
+// page size the client asks for. Defaults to 100, the real API's maximum.
+func WithMaxPageSize(n int) Option {
+	return func(s *Server) {
+		s.maxPageSize = n
+	}
+}
+
+// WithPollsUntilPicked sets how many session polls report the selection as still in
+// progress before it is complete. Defaults to 0, so the first poll sees it complete.
+func WithPollsUntilPicked(n int) Option {
+	return func(s *Server) {
+		s.pollsUntilPicked = n
+	}
+}
+
+// WithPollingConfig sets the poll interval and timeout advertised for new sessions.
+// Defaults to 1s and 5m.
+func WithPollingConfig(interval, timeout time.Duration) Option {
+	return func(s *Server) {
+		s.pollInterval = interval
+		s.sessionTimeout = timeout
+	}
+}
+
+// WithLinkLifetime sets how long a baseUrl stays valid after the mediaItems call
+// that returned it. Expired links get 403 Forbidden. Defaults to 60 minutes.
+func WithLinkLifetime(d time.Duration) Option {
+	return func(s *Server) {
+		s.linkLifetime = d
+	}
+}
+
+// WithClock sets the clock that link expiry is measured against. Use a
+// *clock.Fake to expire links without waiting.
+func WithClock(c clock.Clock) Option {
+	return func(s *Server) {
+		s.clock = c
+	}
+}
+
+// NewServer starts a fake Picker API. Close it when done.
+func NewServer(opts ...Option) *Server {
+	s := &Server{
+		clock:          clock.Real,
+		maxPageSize:    100,
+		pollInterval:   time.Second,
+		sessionTimeout: 5 * time.Minute,
+		linkLifetime:   60 * time.Minute,
+		sessions:       make(map[string]*session),
+		downloads:      make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/sessions", s.createSession)
+	mux.HandleFunc("GET /v1/sessions/{id}", s.getSession)
+	mux.HandleFunc("GET /v1/mediaItems", s.listMediaItems)
+	mux.HandleFunc("GET /media/{link...}", s.serveMedia)
+	s.Server = httptest.NewServer(s.rateLimit(mux))
+	return s
+}
+
+// BaseURL returns the root of the fake API, for use with picker.WithBaseURL.
+func (s *Server) BaseURL() string {
+	return s.URL + "/v1"
+}
+
+// RateLimit makes the next n requests, to any endpoint, fail with 429 Too Many Requests.
+func (s *Server) RateLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rateLimited = n
+}
+
+// Downloads reports how many times the media for item id has been served successfully.
+func (s *Server) Downloads(id string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.downloads[id]
+}
+
+// rateLimitedBody mimics the error body of a Google API over its request quota.
+const rateLimitedBody = `{"error": {
+  "code": 429,
+  "message": "Quota exceeded for quota metric 'Read requests' of service 'photospicker.googleapis.com'.",
+  "status": "RESOURCE_EXHAUSTED",
+  "details": [
+    {"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED",
+     "metadata": {"quota_metric": "photospicker.googleapis.com/read_requests", "service": "photospicker.googleapis.com"}},
+    {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1s"}
+  ]
+}}`
+
+func (s *Server) rateLimit(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		limited := s.rateLimited > 0
+		if limited {
+			s.rateLimited--
+		}
+		s.mu.Unlock()
+		if limited {
+			w.Header().Set("Retry-After", "1")
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(rateLimitedBody))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *Server) createSession(w http.ResponseWriter, r *http.Request) {
+	var request picker.PickingSession
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, "invalid session", http.StatusBadRequest)
+			return
+		}
+	}
+	sess := &session{}
+	if config := request.PickingConfig; config != nil && config.MaxItemCount != "" {
+		n, err := strconv.Atoi(config.MaxItemCount)
+		if err != nil || n < 1 {
+			http.Error(w, "invalid maxItemCount", http.StatusBadRequest)
+			return
+		}
+		sess.maxItems = n
+	}
+
+	s.mu.Lock()
+	s.nextSession++
+	id := fmt.Sprintf("session-%d", s.nextSession)
+	s.sessions[id] = sess
+	s.mu.Unlock()
+
+	writeJSON(w, picker.PickingSession{
+		ID:        id,
+		PickerURI: s.URL + "/pick/" + id,
+		PollingConfig: picker.PollingConfig{
+			PollInterval: s.pollInterval.String(),
+			TimeoutIn:    s.sessionTimeout.String(),
+		},
+		PickingConfig: request.PickingConfig,
+	})
+}
+
+func (s *Server) getSession(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.mu.Lock()
+	sess, ok := s.sessions[id]
+	var picked bool
+	if ok {
+		sess.polls++
+		picked = sess.polls > s.pollsUntilPicked
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	writeJSON(w, picker.PickingSession{
+		ID:            id,
+		MediaItemsSet: picked,
+		PickerURI:     s.URL + "/pick/" + id,
+		PollingConfig: picker.PollingConfig{
+			PollInterval: s.pollInterval.String(),
+			TimeoutIn:    s.sessionTimeout.String(),
+		},
+	})
+}
+
+func (s *Server) listMediaItems(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	s.mu.Lock()
+	sess, ok := s.sessions[query.Get("sessionId")]
+	picked := ok && sess.polls > s.pollsUntilPicked
+	// The fake user picks as many of the items as the session allows
+	items := s.items
+	if ok && sess.maxItems > 0 && sess.maxItems < len(items) {
+		items = items[:sess.maxItems]
+	}
+	s.mu.Unlock()
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if !picked {
+		// The real API refuses to list items before the user has finished picking
+		http.Error(w, "FAILED_PRECONDITION", http.StatusBadRequest)
+		return
+	}
+
+	pageSize := s.maxPageSize
+	if n, err := strconv.Atoi(query.Get("pageSize")); err == nil && n > 0 && n < pageSize {
+		pageSize = n
+	}
+	offset := 0
+	if token := query.Get("pageToken"); token != "" {
+		n, err := strconv.Atoi(token)
+		if err != nil || n < 0 || n > len(items) {
+			http.Error(w, "invalid page token", http.StatusBadRequest)
+			return
+		}
+		offset = n
+	}
+	end := min(offset+pageSize, len(items))
+
+	issued := s.clock.Now().UnixNano()
+	var page picker.MediaItemsList
+	for _, item := range items[offset:end] {
+		page.MediaItems = append(page.MediaItems, picker.PickedMediaItem{
+			Id:         item.ID,
+			CreateTime: item.CreateTime.UTC().Format(time.RFC3339),
+			Type:       item.Type,
+			MediaFile: picker.MediaFile{
+				BaseUrl:  fmt.Sprintf("%s/media/%s/%d", s.URL, item.ID, issued),
+				MimeType: item.MimeType,
+				Filename: item.Filename,
+				MediaFileMetadata: picker.MediaFileMetadata{
+					Width:  item.Width,
+					Height: item.Height,
+				},
+			},
+		})
+	}
+	if end < len(items) {
+		page.NextPageToken = strconv.Itoa(end)
+	}
+	writeJSON(w, page)
+}
+
+// serveMedia serves /media/{id}/{issued}={params}, where params are the size or
+// download options the client appended to the baseUrl.
+func (s *Server) serveMedia(w http.ResponseWriter, r *http.Request) {
+	link, _, _ := strings.Cut(r.PathValue("link"), "=")
+	id, issuedText, ok := strings.Cut(link, "/")
+	issued, err := strconv.ParseInt(issuedText, 10, 64)
+	if !ok || err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	if s.clock.Now().Sub(time.Unix(0, issued)) > s.linkLifetime {
+		http.Error(w, "link expired", http.StatusForbidden)
+		return
+	}
+
+	var item *Item
+	for i := range s.items {
+		if s.items[i].ID == id {
+			item = &s.items[i]
+			break
+		}
+	}
+	if item == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	content := item.Content
+	if content == nil {
+		content = []byte("media:" + item.ID)
+	}
+	s.mu.Lock()
+	s.downloads[id]++
+	s.mu.Unlock()
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
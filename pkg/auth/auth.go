@@ -0,0 +1,405 @@
+// auth.go
+//
+// Package auth obtains, caches and refreshes the OAuth2 tokens used to call the
+// Google Photos APIs.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+
+	"PhotoSync/pkg/retry"
+)
+
+// OAuth scopes used by the app.
+const (
+	// PickerScope is all that picking and downloading photos needs.
+	PickerScope = "https://www.googleapis.com/auth/photospicker.mediaitems.readonly"
+	// ProfileScope lets the app see which account it is signed in to.
+	ProfileScope = "https://www.googleapis.com/auth/userinfo.profile"
+)
+
+// DefaultScopes keeps the consent screen minimal.
+var DefaultScopes = []string{PickerScope}
+
+// Ways of obtaining a new token.
+const (
+	// FlowWeb runs a local callback server that Google redirects the browser to.
+	FlowWeb = "web"
+	// FlowDevice uses the device authorization flow and needs no inbound connection.
+	FlowDevice = "device"
+)
+
+// Authenticator produces authorized HTTP clients, caching the token in a file and
+// running an OAuth2 flow whenever there is no usable cached token.
+type Authenticator struct {
+	config           *oauth2.Config
+	tokenFile        string
+	flow             string
+	callbackAddr     string
+	callbackListener net.Listener
+	retry            retry.Policy
+	logger           *slog.Logger
+	httpClient       *http.Client
+	lock             func() (unlock func(), err error)
+}
+
+// Option configures an Authenticator.
+type Option func(*Authenticator)
+
+// WithFlow selects how new tokens are obtained: FlowWeb (the default) or FlowDevice.
+func WithFlow(flow string) Option {
+	return func(a *Authenticator) {
+		a.flow = flow
+	}
+}
+
+// WithCallbackAddr sets the address the web flow's callback server listens on.
+// Defaults to ":8080".
+func WithCallbackAddr(addr string) Option {
+	return func(a *Authenticator) {
+		a.callbackAddr = addr
+	}
+}
+
+// WithCallbackListener serves the web flow's callback on an already bound listener
+// instead of binding the callback address. This lets the caller bind a privileged
+// port before dropping root.
+func WithCallbackListener(listener net.Listener) Option {
+	return func(a *Authenticator) {
+		a.callbackListener = listener
+	}
+}
+
+// WithRetryPolicy controls retries of device authorization and token exchange.
+// Defaults to retry.DefaultPolicy.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(a *Authenticator) {
+		a.retry = policy
+	}
+}
+
+// WithLogger sends the flow's prompts and warnings to handler instead of
+// slog.Default(). The prompts carry the URL and code the user needs, so the handler
+// should write somewhere the user can see.
+func WithLogger(handler slog.Handler) Option {
+	return func(a *Authenticator) {
+		a.logger = slog.New(handler)
+	}
+}
+
+// WithHTTPClient sends token requests, and builds authorized clients on top of,
+// client instead of http.DefaultClient, e.g. to add logging or a proxy.
+func WithHTTPClient(client *http.Client) Option {
+	return func(a *Authenticator) {
+		a.httpClient = client
+	}
+}
+
+// WithTokenLock has clients take lock around refreshing and saving the token, so
+// that processes sharing the token file take turns. Client itself does not take
+// it: callers should hold it while Client runs, since it may save a new token.
+func WithTokenLock(lock func() (unlock func(), err error)) Option {
+	return func(a *Authenticator) {
+		a.lock = lock
+	}
+}
+
+// NewAuthenticator returns an Authenticator for config that caches its token in tokenFile.
+func NewAuthenticator(config *oauth2.Config, tokenFile string, opts ...Option) *Authenticator {
+	a := &Authenticator{
+		config:       config,
+		tokenFile:    tokenFile,
+		flow:         FlowWeb,
+		callbackAddr: ":8080",
+		retry:        retry.DefaultPolicy,
+		logger:       slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(a)
+	}
+	if a.retry.Logger == nil {
+		a.retry.Logger = a.logger
+	}
+	return a
+}
+
+// LoadConfig reads an OAuth2 client credentials file downloaded from the Google Cloud console.
+func LoadConfig(credentialsFile string, scope ...string) (*oauth2.Config, error) {
+	creds, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read credentials file: %v", err)
+	}
+	config, err := google.ConfigFromJSON(creds, scope...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse credentials file to config: %v", err)
+	}
+	return config, nil
+}
+
+// Client retrieves an authenticated HTTP client using OAuth2 credentials. A new
+// token is requested if the cached one is missing, cannot be refreshed or was
+// granted for fewer scopes than the config asks for. The client refreshes the
+// token as it runs, so it can be used for as long as the refresh token lasts.
+func (a *Authenticator) Client() (*http.Client, *oauth2.Token, error) {
+	tok, granted, err := tokenFromFile(a.tokenFile)
+	usable := err == nil && coversScopes(granted, a.config.Scopes)
+	source := &refreshingSource{
+		ctx:       a.context(),
+		config:    a.config,
+		tokenFile: a.tokenFile,
+		scopes:    granted,
+		logger:    a.logger,
+		tok:       tok,
+	}
+	if usable {
+		// An expired token is refreshed rather than authorized again, for as long
+		// as Google accepts its refresh token
+		if _, err := source.Token(); err != nil {
+			a.logger.Warn("Unable to refresh the saved token, authorizing again", "err", err)
+			usable = false
+		}
+	}
+	if !usable {
+		tok, err = a.getNewTokenAndSave()
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to retrieve token: %v", err)
+		}
+		source.tok, source.scopes = tok, grantedScopes(tok, a.config.Scopes)
+	}
+	// Set only now, since the caller holds the lock while Client runs
+	source.lock = a.lock
+
+	base := http.DefaultTransport
+	if a.httpClient != nil && a.httpClient.Transport != nil {
+		base = a.httpClient.Transport
+	}
+	return &http.Client{Transport: &refreshingTransport{source: source, base: base}}, source.tok, nil
+}
+
+// context returns the context OAuth2 calls are made with, carrying the HTTP
+// client they should use.
+func (a *Authenticator) context() context.Context {
+	if a.httpClient == nil {
+		return context.Background()
+	}
+	return context.WithValue(context.Background(), oauth2.HTTPClient, a.httpClient)
+}
+
+// savedToken is the token file format: the token plus the scopes it was granted for.
+type savedToken struct {
+	oauth2.Token
+	Scopes []string `json:"scopes,omitempty"`
+}
+
+// tokenFromFile retrieves an OAuth2 token and its scopes from a file. Token files
+// written before scopes were recorded have none.
+func tokenFromFile(file string) (*oauth2.Token, []string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	var saved savedToken
+	err = json.NewDecoder(f).Decode(&saved)
+	return &saved.Token, saved.Scopes, err
+}
+
+// coversScopes reports whether a token granted for granted can be used for wanted.
+// Tokens with no recorded scopes were granted for the old default, which
+// included every scope the app used at the time.
+func coversScopes(granted, wanted []string) bool {
+	if granted == nil {
+		return true
+	}
+	for _, scope := range wanted {
+		if !slices.Contains(granted, scope) {
+			return false
+		}
+	}
+	return true
+}
+
+// grantedScopes returns the scopes Google says it granted tok, falling back to the
+// requested ones if it did not say. Users can untick scopes on the consent screen.
+func grantedScopes(tok *oauth2.Token, requested []string) []string {
+	if scope, ok := tok.Extra("scope").(string); ok && scope != "" {
+		return strings.Fields(scope)
+	}
+	return requested
+}
+
+// saveToken writes the OAuth2 token and the scopes it was granted for to a specified
+// file path, readable only by its owner. The token is written beside the file and
+// renamed over it, so a crash or another process reading it never sees half a
+// token.
+func saveToken(path string, token *oauth2.Token, scopes []string, logger *slog.Logger) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to cache token: %v", err)
+	}
+	defer os.Remove(f.Name())
+	// CreateTemp already makes it private, but umasks and Windows vary
+	if err := f.Chmod(0o600); err != nil {
+		logger.Warn("Unable to restrict token file permissions", "path", path, "err", err)
+	}
+	err = json.NewEncoder(f).Encode(savedToken{Token: *token, Scopes: scopes})
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to cache token: %v", err)
+	}
+	return nil
+}
+
+// getTokenFromWeb initiates an OAuth2 web flow to retrieve a new token. The
+// callback server stops once the authorization code has arrived, freeing its port.
+func (a *Authenticator) getTokenFromWeb() (*oauth2.Token, error) {
+	authCodeChannel := make(chan callbackResult, 1)
+
+	// Start a web server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		postHandler(w, r, authCodeChannel)
+	})
+	server := &http.Server{Addr: a.callbackAddr, Handler: mux}
+
+	go func() {
+		a.logger.Info("Starting OAuth callback server", "addr", a.callbackAddr)
+		var err error
+		if a.callbackListener != nil {
+			err = server.Serve(a.callbackListener)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			a.logger.Error("Error starting OAuth callback server", "err", err)
+			return
+		}
+	}()
+
+	authURL := a.config.AuthCodeURL("state-token", oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("include_granted_scopes", "true"))
+	a.logger.Info("Go to the following link in your browser to authorize access", "url", authURL)
+
+	result := <-authCodeChannel
+
+	// Let the browser receive its reply before the server goes away
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		a.logger.Warn("Error stopping OAuth callback server", "err", err)
+	}
+	if result.err != "" {
+		return nil, fmt.Errorf("authorization was not granted: %s", result.err)
+	}
+
+	var tok *oauth2.Token
+	err := a.retry.Do(a.context(), "Token exchange", func() error {
+		var err error
+		tok, err = a.config.Exchange(a.context(), result.code)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from web: %v", err)
+	}
+	return tok, nil
+}
+
+// callbackResult is what the OAuth redirect brought back: an authorization code, or
+// the error Google gave, e.g. access_denied if the user declined.
+type callbackResult struct {
+	code string
+	err  string
+}
+
+// postHandler passes the outcome of the OAuth redirect to authCodeChannel. Other
+// requests, such as for a favicon, are ignored, as is anything after the first
+// outcome.
+func postHandler(w http.ResponseWriter, r *http.Request, authCodeChannel chan<- callbackResult) {
+	if r.Method != http.MethodGet {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		http.Error(w, "Error parsing form data", http.StatusBadRequest)
+		return
+	}
+	result := callbackResult{code: r.FormValue("code"), err: r.FormValue("error")}
+	if result.code == "" && result.err == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	select {
+	case authCodeChannel <- result:
+	default:
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if result.err != "" {
+		fmt.Fprintln(w, "Authorization was not granted. You can close this window.")
+		return
+	}
+	fmt.Fprintln(w, "Authorization code received. You can close this window.")
+}
+
+// getTokenFromDevice runs the OAuth2 device authorization flow. The user approves
+// access on any other device, so no callback server needs to be reachable.
+func (a *Authenticator) getTokenFromDevice() (*oauth2.Token, error) {
+	a.config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+
+	var deviceAuth *oauth2.DeviceAuthResponse
+	err := a.retry.Do(a.context(), "Device authorization", func() error {
+		var err error
+		deviceAuth, err = a.config.DeviceAuth(a.context(), oauth2.AccessTypeOffline)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to start device authorization: %v", err)
+	}
+
+	a.logger.Info("Go to the verification URL and enter the code", "url", deviceAuth.VerificationURI, "code", deviceAuth.UserCode)
+	return a.config.DeviceAccessToken(a.context(), deviceAuth)
+}
+
+func (a *Authenticator) getNewTokenAndSave() (*oauth2.Token, error) {
+	var tok *oauth2.Token
+	var err error
+	switch a.flow {
+	case FlowWeb:
+		tok, err = a.getTokenFromWeb()
+	case FlowDevice:
+		tok, err = a.getTokenFromDevice()
+	default:
+		return nil, fmt.Errorf("unknown auth flow %q", a.flow)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := saveToken(a.tokenFile, tok, grantedScopes(tok, a.config.Scopes), a.logger); err != nil {
+		return nil, err
+	}
+	return tok, nil
+}
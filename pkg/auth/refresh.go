@@ -0,0 +1,135 @@
+// refresh.go
+//
+// Keeping a token usable through syncs and servers that run for hours: the access
+// token is refreshed a few minutes before it expires, each new one is saved for
+// the next run, and a request Google refuses with 401 is sent once more with a
+// freshly refreshed token.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// refreshEarly is how long before it expires the access token is refreshed, so
+// that it cannot expire between being handed out and reaching Google.
+const refreshEarly = 5 * time.Minute
+
+// refreshingSource hands out the current token, refreshing it when it is about to
+// expire or Google has refused it.
+type refreshingSource struct {
+	ctx       context.Context
+	config    *oauth2.Config
+	tokenFile string
+	scopes    []string
+	logger    *slog.Logger
+	// lock, if set, is held while the token is refreshed and saved.
+	lock func() (unlock func(), err error)
+
+	mu  sync.Mutex
+	tok *oauth2.Token
+	// refused is the access token Google last refused, which must not be taken
+	// back from the file.
+	refused string
+}
+
+// Token returns a token with at least refreshEarly left, refreshing it first if
+// needed.
+func (s *refreshingSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.AccessToken != "" && time.Until(s.tok.Expiry) > refreshEarly {
+		return s.tok, nil
+	}
+	return s.refresh()
+}
+
+// refresh exchanges the refresh token for a new access token and saves it. If
+// another process sharing the token file has just done so, its token is used
+// instead. s.mu must be held.
+func (s *refreshingSource) refresh() (*oauth2.Token, error) {
+	if s.lock != nil {
+		unlock, err := s.lock()
+		if err != nil {
+			return nil, fmt.Errorf("unable to lock the token file to refresh it: %w", err)
+		}
+		defer unlock()
+		saved, scopes, err := tokenFromFile(s.tokenFile)
+		if err == nil && saved.AccessToken != s.tok.AccessToken && saved.AccessToken != s.refused && time.Until(saved.Expiry) > refreshEarly {
+			s.tok, s.scopes = saved, scopes
+			return saved, nil
+		}
+	}
+	if s.tok.RefreshToken == "" {
+		return nil, errors.New("the access token has expired and there is no refresh token; run with -reauth")
+	}
+	// A token with only the refresh token is never valid, so the config's source
+	// always asks Google for a new one
+	tok, err := s.config.TokenSource(s.ctx, &oauth2.Token{RefreshToken: s.tok.RefreshToken}).Token()
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	s.logger.Debug("Refreshed access token", "expiry", tok.Expiry)
+	if err := saveToken(s.tokenFile, tok, s.scopes, s.logger); err != nil {
+		s.logger.Warn("Unable to save the refreshed token", "err", err)
+	}
+	return tok, nil
+}
+
+// invalidate forgets refused, so the next call to Token refreshes it. Tokens
+// refused after another request has already replaced them are ignored.
+func (s *refreshingSource) invalidate(refused *oauth2.Token) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tok.AccessToken == refused.AccessToken {
+		s.tok = &oauth2.Token{RefreshToken: s.tok.RefreshToken}
+		s.refused = refused.AccessToken
+	}
+}
+
+// refreshingTransport authorizes requests with the source's token, sending a
+// request refused with 401 once more with a new token.
+type refreshingTransport struct {
+	source *refreshingSource
+	base   http.RoundTripper
+}
+
+func (t *refreshingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tok, err := t.source.Token()
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.send(req, tok)
+	// Requests whose body has been read cannot be sent again
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	resp.Body.Close()
+	t.source.invalidate(tok)
+	if tok, err = t.source.Token(); err != nil {
+		return nil, err
+	}
+	retry := req.Clone(req.Context())
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return nil, err
+		}
+	}
+	return t.send(retry, tok)
+}
+
+// send sends a copy of req carrying tok, leaving req itself unchanged as a
+// RoundTripper must.
+func (t *refreshingTransport) send(req *http.Request, tok *oauth2.Token) (*http.Response, error) {
+	authorized := req.Clone(req.Context())
+	tok.SetAuthHeader(authorized)
+	return t.base.RoundTrip(authorized)
+}
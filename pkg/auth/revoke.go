@@ -0,0 +1,54 @@
+// revoke.go
+//
+// Revoking a saved token with Google, so that a host being decommissioned or
+// handed over keeps no access to the account even if a copy of the token survives.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// revokeURL is Google's token revocation endpoint.
+const revokeURL = "https://oauth2.googleapis.com/revoke"
+
+// ErrTokenInvalid is returned by Revoke for tokens Google no longer accepts, such
+// as those already revoked, which need no revoking.
+var ErrTokenInvalid = errors.New("token already revoked or expired")
+
+// Revoke revokes the token saved in tokenFile, sending the request with client.
+// Revoking the refresh token also revokes every access token issued from it.
+func Revoke(ctx context.Context, client *http.Client, tokenFile string) error {
+	tok, _, err := tokenFromFile(tokenFile)
+	if err != nil {
+		return err
+	}
+	token := tok.RefreshToken
+	if token == "" {
+		token = tok.AccessToken
+	}
+	form := url.Values{"token": {token}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, revokeURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		return nil
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode == http.StatusBadRequest && strings.Contains(string(body), "invalid_token") {
+		return ErrTokenInvalid
+	}
+	return fmt.Errorf("revocation failed: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+}
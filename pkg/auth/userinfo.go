@@ -0,0 +1,48 @@
+// userinfo.go
+//
+// Looking up which Google account a token belongs to.
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"PhotoSync/pkg/transport"
+)
+
+// UserInfoURL is Google's OpenID Connect userinfo endpoint.
+const UserInfoURL = "https://openidconnect.googleapis.com/v1/userinfo"
+
+// UserInfo describes the Google account a token was granted by. Email is only
+// filled in if the token carries the email scope.
+type UserInfo struct {
+	Subject string `json:"sub"`
+	Name    string `json:"name"`
+	Email   string `json:"email"`
+	Picture string `json:"picture"`
+}
+
+// FetchUserInfo asks Google which account client's token belongs to. It needs the
+// userinfo.profile scope.
+func FetchUserInfo(ctx context.Context, client transport.Doer) (UserInfo, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, UserInfoURL, nil)
+	if err != nil {
+		return UserInfo{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return UserInfo{}, fmt.Errorf("failed to fetch user info: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return UserInfo{}, fmt.Errorf("failed to fetch user info: status %d", resp.StatusCode)
+	}
+
+	var info UserInfo
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return UserInfo{}, fmt.Errorf("failed to decode user info: %v", err)
+	}
+	return info, nil
+}
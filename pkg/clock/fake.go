@@ -0,0 +1,130 @@
+// fake.go
+//
+// A manually advanced clock for tests and simulations.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Fake is a Clock whose time only moves when Advance is called. Timers and tickers
+// fire as Advance passes their deadlines, and Sleep blocks until it does.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*fakeWaiter
+}
+
+// NewFake returns a Fake clock set to start.
+func NewFake(start time.Time) *Fake {
+	return &Fake{now: start}
+}
+
+type fakeWaiter struct {
+	clock    *Fake
+	deadline time.Time
+	period   time.Duration // zero for one-shot timers
+	c        chan time.Time
+	stopped  bool
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// NewTimer returns a timer that fires once Advance passes now+d.
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	return fakeTimer{f.add(d, 0)}
+}
+
+// NewTicker returns a ticker that fires every d of advanced time.
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	return fakeTicker{f.add(d, d)}
+}
+
+// Sleep blocks until another goroutine advances the clock by at least d.
+func (f *Fake) Sleep(d time.Duration) {
+	<-f.NewTimer(d).C()
+}
+
+// Advance moves the clock forward by d, firing every timer and ticker that falls due.
+// Like real tickers, a ticker whose previous tick has not been received drops ticks.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+
+	remaining := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.stopped {
+			continue
+		}
+		for !w.deadline.After(f.now) {
+			select {
+			case w.c <- w.deadline:
+			default:
+			}
+			if w.period == 0 {
+				w.stopped = true
+				break
+			}
+			w.deadline = w.deadline.Add(w.period)
+		}
+		if !w.stopped {
+			remaining = append(remaining, w)
+		}
+	}
+	f.waiters = remaining
+}
+
+// Waiters returns the number of pending timers and tickers, which lets a test wait
+// until the code under test has started waiting before advancing the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, w := range f.waiters {
+		if !w.stopped {
+			n++
+		}
+	}
+	return n
+}
+
+func (f *Fake) add(d time.Duration, period time.Duration) *fakeWaiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &fakeWaiter{clock: f, deadline: f.now.Add(d), period: period, c: make(chan time.Time, 1)}
+	if d <= 0 && period == 0 {
+		w.c <- f.now
+		w.stopped = true
+		return w
+	}
+	f.waiters = append(f.waiters, w)
+	return w
+}
+
+func (f *Fake) stop(w *fakeWaiter) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	wasActive := !w.stopped
+	w.stopped = true
+	return wasActive
+}
+
+type fakeTimer struct{ w *fakeWaiter }
+
+func (t fakeTimer) C() <-chan time.Time { return t.w.c }
+func (t fakeTimer) Stop() bool          { return t.w.clock.stop(t.w) }
+
+type fakeTicker struct{ w *fakeWaiter }
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.c }
+func (t fakeTicker) Stop()               { t.w.clock.stop(t.w) }
@@ -0,0 +1,106 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func fired(c <-chan time.Time) (time.Time, bool) {
+	select {
+	case t := <-c:
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+func TestFakeTimer(t *testing.T) {
+	f := NewFake(start)
+	timer := f.NewTimer(10 * time.Second)
+	if f.Waiters() != 1 {
+		t.Fatalf("Waiters() = %d, want 1", f.Waiters())
+	}
+
+	f.Advance(9 * time.Second)
+	if _, ok := fired(timer.C()); ok {
+		t.Fatal("timer fired before its deadline")
+	}
+	f.Advance(time.Second)
+	if got, ok := fired(timer.C()); !ok || !got.Equal(start.Add(10*time.Second)) {
+		t.Fatalf("timer fired = %v at %v, want it to fire at its deadline", ok, got)
+	}
+	if f.Waiters() != 0 {
+		t.Errorf("Waiters() = %d after the timer fired, want 0", f.Waiters())
+	}
+	if timer.Stop() {
+		t.Error("Stop() = true for a timer that already fired")
+	}
+	if !f.Now().Equal(start.Add(10 * time.Second)) {
+		t.Errorf("Now() = %v, want start + 10s", f.Now())
+	}
+}
+
+func TestFakeTimerStop(t *testing.T) {
+	f := NewFake(start)
+	timer := f.NewTimer(time.Second)
+	if !timer.Stop() {
+		t.Error("Stop() = false for a pending timer")
+	}
+	f.Advance(time.Minute)
+	if _, ok := fired(timer.C()); ok {
+		t.Error("stopped timer fired")
+	}
+
+	if _, ok := fired(f.NewTimer(0).C()); !ok {
+		t.Error("timer with no duration did not fire at once")
+	}
+}
+
+func TestFakeTicker(t *testing.T) {
+	f := NewFake(start)
+	ticker := f.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	f.Advance(time.Second)
+	if got, ok := fired(ticker.C()); !ok || !got.Equal(start.Add(time.Second)) {
+		t.Fatalf("first tick = %v at %v, want it after a second", ok, got)
+	}
+	// Ticks nobody received are dropped rather than queued
+	f.Advance(5 * time.Second)
+	if got, ok := fired(ticker.C()); !ok || !got.Equal(start.Add(2*time.Second)) {
+		t.Fatalf("tick after a long advance = %v at %v, want the first missed tick", ok, got)
+	}
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("missed ticks were queued")
+	}
+	f.Advance(time.Second)
+	if got, ok := fired(ticker.C()); !ok || !got.Equal(start.Add(7*time.Second)) {
+		t.Errorf("tick = %v at %v, want the ticker to keep its period", ok, got)
+	}
+
+	ticker.Stop()
+	f.Advance(time.Minute)
+	if _, ok := fired(ticker.C()); ok {
+		t.Error("stopped ticker fired")
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	f := NewFake(start)
+	done := make(chan struct{})
+	go func() {
+		f.Sleep(time.Hour)
+		close(done)
+	}()
+	for f.Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	f.Advance(time.Hour)
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Sleep did not return once the clock passed its duration")
+	}
+}
@@ -0,0 +1,135 @@
+// collage.go
+//
+// Package collage composes several small photos into a single slide the size of the
+// frame, so that low-resolution pictures are shown sharp and small rather than
+// blurry and stretched.
+package collage
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"os"
+
+	// Register the formats Compose can read
+	_ "image/gif"
+	_ "image/png"
+)
+
+// Size reads the dimensions of the image at path without decoding it.
+func Size(path string) (int, int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return config.Width, config.Height, nil
+}
+
+// Compose lays the images at paths out in a grid on a black width x height canvas.
+// Each is scaled to fit its cell, keeping its aspect ratio, and never enlarged by
+// more than maxUpscale. Two or three images go side by side on a landscape canvas
+// or stacked on a portrait one; four make a 2x2 grid.
+func Compose(paths []string, width, height int, maxUpscale float64) (image.Image, error) {
+	if len(paths) < 2 || len(paths) > 4 {
+		return nil, fmt.Errorf("a collage takes 2 to 4 images, got %d", len(paths))
+	}
+	canvas := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(color.Black), image.Point{}, draw.Src)
+
+	cols, rows := len(paths), 1
+	if len(paths) == 4 {
+		cols, rows = 2, 2
+	} else if height > width {
+		cols, rows = 1, len(paths)
+	}
+	cellW, cellH := width/cols, height/rows
+
+	for i, path := range paths {
+		img, err := decode(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		cell := image.Rect(0, 0, cellW, cellH).Add(image.Pt((i%cols)*cellW, (i/cols)*cellH))
+		drawFitted(canvas, cell, img, maxUpscale)
+	}
+	return canvas, nil
+}
+
+// Save writes img to path as a JPEG.
+func Save(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: 90}); err != nil {
+		f.Close()
+		os.Remove(path)
+		return err
+	}
+	return f.Close()
+}
+
+func decode(path string) (image.Image, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	return img, err
+}
+
+// drawFitted scales src to fit inside cell and draws it centred there.
+func drawFitted(dst *image.RGBA, cell image.Rectangle, src image.Image, maxUpscale float64) {
+	sb := src.Bounds()
+	scale := min(float64(cell.Dx())/float64(sb.Dx()), float64(cell.Dy())/float64(sb.Dy()), maxUpscale)
+	w, h := max(1, int(float64(sb.Dx())*scale)), max(1, int(float64(sb.Dy())*scale))
+	origin := image.Pt(cell.Min.X+(cell.Dx()-w)/2, cell.Min.Y+(cell.Dy()-h)/2)
+
+	// Bilinear sampling; photos this small are cheap to scale pixel by pixel
+	for y := 0; y < h; y++ {
+		sy := (float64(y)+0.5)/scale - 0.5
+		for x := 0; x < w; x++ {
+			sx := (float64(x)+0.5)/scale - 0.5
+			dst.Set(origin.X+x, origin.Y+y, bilinear(src, sb, sx, sy))
+		}
+	}
+}
+
+// bilinear samples src at the fractional point (sx, sy) relative to bounds.Min.
+func bilinear(src image.Image, bounds image.Rectangle, sx, sy float64) color.Color {
+	clamp := func(v, hi int) int { return max(0, min(v, hi-1)) }
+	x0, y0 := int(sx), int(sy)
+	if sx < 0 {
+		x0 = -1
+	}
+	if sy < 0 {
+		y0 = -1
+	}
+	fx, fy := sx-float64(x0), sy-float64(y0)
+
+	var sum [4]float64
+	for _, p := range []struct {
+		x, y int
+		w    float64
+	}{
+		{x0, y0, (1 - fx) * (1 - fy)},
+		{x0 + 1, y0, fx * (1 - fy)},
+		{x0, y0 + 1, (1 - fx) * fy},
+		{x0 + 1, y0 + 1, fx * fy},
+	} {
+		r, g, b, a := src.At(bounds.Min.X+clamp(p.x, bounds.Dx()), bounds.Min.Y+clamp(p.y, bounds.Dy())).RGBA()
+		sum[0] += p.w * float64(r)
+		sum[1] += p.w * float64(g)
+		sum[2] += p.w * float64(b)
+		sum[3] += p.w * float64(a)
+	}
+	return color.RGBA64{uint16(sum[0]), uint16(sum[1]), uint16(sum[2]), uint16(sum[3])}
+}
@@ -0,0 +1,229 @@
+// download.go
+//
+// Package download fetches picked media items from Google Photos into a local folder.
+package download
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"PhotoSync/pkg/clock"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/transport"
+)
+
+// DownloadMediaItem downloads a media item from Google Photos by appending "=d" to the baseUrl.
+// If ctx is cancelled mid-download the partially written file is removed.
+func DownloadMediaItem(ctx context.Context, item picker.MediaFile, folder string, client transport.Doer) error {
+	_, err := fetchToFolder(ctx, client, item.BaseUrl+"=d", folder, item.Filename, fetchOptions{logger: slog.Default()})
+	return err
+}
+
+// DownloadItems downloads every item into folder, reporting failures and carrying on
+// with the remaining items. It stops early if ctx is cancelled.
+func DownloadItems(ctx context.Context, client transport.Doer, items picker.DownloadableMediaItems, folder string) {
+	// A Downloader without hooks cannot fail to start
+	NewDownloader(client, folder).Download(ctx, items)
+}
+
+// HTTPError reports a download that failed with an unexpected HTTP status.
+type HTTPError struct {
+	Filename   string
+	StatusCode int
+	// RetryDelay is the wait the server asked for in a Retry-After header, if any.
+	RetryDelay time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	return fmt.Sprintf("failed to download file %s, HTTP status %d", e.Filename, e.StatusCode)
+}
+
+// HTTPStatus returns the status code, letting retry policies classify the error.
+func (e *HTTPError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// RetryAfter returns the wait the server asked for, letting retry policies honour it.
+func (e *HTTPError) RetryAfter() time.Duration {
+	return e.RetryDelay
+}
+
+// TooLargeError reports a download skipped because the file exceeds the size limit.
+// Size is -1 if the file was cut off before its full size was known.
+type TooLargeError struct {
+	Filename string
+	Size     int64
+	Limit    int64
+}
+
+func (e *TooLargeError) Error() string {
+	if e.Size < 0 {
+		return fmt.Sprintf("%s is larger than the %d byte limit", e.Filename, e.Limit)
+	}
+	return fmt.Sprintf("%s is %d bytes, over the %d byte limit", e.Filename, e.Size, e.Limit)
+}
+
+// heldError reports a download dropped once its size was known, because the size
+// gate held it back.
+type heldError struct {
+	size int64
+}
+
+func (e *heldError) Error() string {
+	return fmt.Sprintf("held back at %d bytes", e.size)
+}
+
+// fetchOptions control how fetchToFolder saves a file.
+type fetchOptions struct {
+	logger *slog.Logger
+	// maxSize is the largest file saved, in bytes; zero means no limit.
+	maxSize int64
+	// hold, if set, reports whether a download of size bytes, or -1 if unknown,
+	// must wait, in which case the response is dropped.
+	hold func(size int64) bool
+	// replace re-downloads files that already exist, replacing them if their
+	// contents changed. The previous file is kept in the versions folder unless
+	// keepVersions is zero.
+	replace      bool
+	keepVersions int
+	clock        clock.Clock
+	// processors rewrite the file after it is downloaded and before it is moved
+	// into place.
+	processors []FileProcessor
+	// unflushed collects files written in SD-card friendly mode.
+	unflushed *flushList
+}
+
+// fetched describes the outcome of fetchToFolder. Digest is the hex SHA-256 of the
+// file's contents, known only if it was downloaded. Replaced is set when a
+// download took the place of an existing file.
+type fetched struct {
+	downloaded bool
+	replaced   bool
+	bytes      int64
+	digest     string
+}
+
+// fetchToFolder downloads downloadUrl into folder/filename unless that file already
+// exists and opts.replace is unset.
+func fetchToFolder(ctx context.Context, client transport.Doer, downloadUrl string, folder string, filename string, opts fetchOptions) (fetched, error) {
+	filePath := filepath.Join(folder, filename)
+	logger := opts.logger
+
+	exists := false
+	if _, err := os.Stat(filePath); err == nil {
+		if !opts.replace {
+			logger.Info("File already exists, skipping download", "file", filename)
+			return fetched{}, nil
+		}
+		exists = true
+	} else if !os.IsNotExist(err) {
+		return fetched{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, downloadUrl, nil)
+	if err != nil {
+		return fetched{}, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fetched{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		httpErr := &HTTPError{Filename: filename, StatusCode: resp.StatusCode}
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			httpErr.RetryDelay = time.Duration(seconds) * time.Second
+		}
+		return fetched{}, httpErr
+	}
+	var body io.Reader = resp.Body
+	if opts.maxSize > 0 {
+		if resp.ContentLength > opts.maxSize {
+			return fetched{}, &TooLargeError{Filename: filename, Size: resp.ContentLength, Limit: opts.maxSize}
+		}
+		// The length may be unknown, so stop reading one byte past the limit
+		body = io.LimitReader(resp.Body, opts.maxSize+1)
+	}
+	if opts.hold != nil && opts.hold(resp.ContentLength) {
+		return fetched{}, &heldError{size: resp.ContentLength}
+	}
+
+	// Replacements and files still to be processed are staged, so that the folder
+	// never holds a half-written or unprocessed file
+	out, err := openOutputFile(filePath, exists || len(opts.processors) > 0, opts.unflushed)
+	if err != nil {
+		return fetched{}, err
+	}
+
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(out, hash), body)
+	if err != nil {
+		out.Abort()
+		return fetched{}, err
+	}
+	if opts.maxSize > 0 && written > opts.maxSize {
+		out.Abort()
+		return fetched{}, &TooLargeError{Filename: filename, Size: -1, Limit: opts.maxSize}
+	}
+	digest := hex.EncodeToString(hash.Sum(nil))
+	if len(opts.processors) > 0 {
+		for _, processor := range opts.processors {
+			if err := processor.Process(out.Name()); err != nil {
+				out.Abort()
+				return fetched{}, fmt.Errorf("failed to process %s: %v", filename, err)
+			}
+		}
+		sum, err := fileDigest(out.Name())
+		if err != nil {
+			out.Abort()
+			return fetched{}, err
+		}
+		info, err := os.Stat(out.Name())
+		if err != nil {
+			out.Abort()
+			return fetched{}, err
+		}
+		digest, written = hex.EncodeToString(sum), info.Size()
+	}
+
+	if exists {
+		same, err := sameContents(out.Name(), filePath)
+		if err != nil {
+			out.Abort()
+			return fetched{}, err
+		}
+		if same {
+			// Leave the existing file alone rather than rewrite identical bytes
+			out.Abort()
+			logger.Info("File unchanged, keeping it", "file", filename)
+			return fetched{bytes: written, digest: digest}, nil
+		}
+		if opts.keepVersions > 0 {
+			if err := keepVersion(folder, filename, clock.OrReal(opts.clock).Now(), opts.keepVersions); err != nil {
+				out.Abort()
+				return fetched{}, fmt.Errorf("failed to keep previous version of %s: %v", filename, err)
+			}
+		}
+	}
+	if err := out.Commit(); err != nil {
+		return fetched{}, err
+	}
+
+	if exists {
+		logger.Info("Replaced changed file", "file", filename, "bytes", written)
+	} else {
+		logger.Info("Downloaded", "file", filename, "bytes", written)
+	}
+	return fetched{downloaded: true, replaced: exists, bytes: written, digest: digest}, nil
+}
@@ -0,0 +1,589 @@
+// downloader.go
+//
+// Downloader runs picked media items through a configurable pipeline of filters and
+// transforms and saves the survivors into a folder.
+package download
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"PhotoSync/pkg/clock"
+	"PhotoSync/pkg/events"
+	"PhotoSync/pkg/hooks"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/retry"
+	"PhotoSync/pkg/transport"
+)
+
+// Downloader downloads picked media items into a folder.
+type Downloader struct {
+	client       transport.Doer
+	folder       string
+	filters      []ItemFilter
+	transforms   []ItemTransform
+	stages       []SelectionStage
+	hooks        *hooks.Registry
+	events       *events.Bus
+	concurrency  int
+	retry        retry.Policy
+	clock        clock.Clock
+	logger       *slog.Logger
+	maxFileSize  int64
+	replace      bool
+	keepVersions int
+	processors   []FileProcessor
+	unchanged    func(item *Item) bool
+	maxFailures  int
+	gate         func(ctx context.Context, item *Item) error
+	sizeGate     SizeGate
+	previous     func() map[string]string
+	// unflushed is set in SD-card friendly mode.
+	unflushed *flushList
+}
+
+// Option configures a Downloader.
+type Option func(*Downloader)
+
+// WithFilters appends filters, which run in order; the first to reject an item wins.
+func WithFilters(filters ...ItemFilter) Option {
+	return func(d *Downloader) {
+		d.filters = append(d.filters, filters...)
+	}
+}
+
+// WithTransforms appends transforms, which run in order on every item that passes
+// the filters.
+func WithTransforms(transforms ...ItemTransform) Option {
+	return func(d *Downloader) {
+		d.transforms = append(d.transforms, transforms...)
+	}
+}
+
+// WithUnchanged sets a check for items known to match the file already in the
+// folder. With WithReplaceChanged, such items are not downloaded again to compare.
+func WithUnchanged(unchanged func(item *Item) bool) Option {
+	return func(d *Downloader) {
+		d.unchanged = unchanged
+	}
+}
+
+// WithMaxFailures stops a sync once n items have failed, leaving the remaining
+// items undownloaded. Zero, the default, never stops.
+func WithMaxFailures(n int) Option {
+	return func(d *Downloader) {
+		d.maxFailures = n
+	}
+}
+
+// WithPreviousNames sets a lookup of the names earlier syncs saved items under,
+// by ID. Before downloading, files found under an item's previous name are
+// renamed to the name planned for it, so that items renumbered by NumberedOrder
+// are moved rather than downloaded again beside their old copies.
+func WithPreviousNames(previous func() map[string]string) Option {
+	return func(d *Downloader) {
+		d.previous = previous
+	}
+}
+
+// WithGate sets a check run before each download, which may wait, for example
+// for a download window to open. An error fails the item.
+func WithGate(gate func(ctx context.Context, item *Item) error) Option {
+	return func(d *Downloader) {
+		d.gate = gate
+	}
+}
+
+// SizeGate holds back downloads by their size, which is only known once the
+// download has started.
+type SizeGate interface {
+	// Hold reports whether item, of size bytes or -1 if the server did not say,
+	// must wait.
+	Hold(item *Item, size int64) bool
+	// Wait blocks until the held item may be downloaded or ctx is cancelled.
+	Wait(ctx context.Context, item *Item, size int64) error
+}
+
+// WithSizeGate checks each download against gate once its size is known. A held
+// download is dropped before its body is read and fetched again once Wait
+// returns, so no connection is kept open meanwhile. An error from Wait fails the
+// item.
+func WithSizeGate(gate SizeGate) Option {
+	return func(d *Downloader) {
+		d.sizeGate = gate
+	}
+}
+
+// WithProcessors appends processors, which run in order on each downloaded file
+// before it is moved into the folder. A failing processor fails the item.
+func WithProcessors(processors ...FileProcessor) Option {
+	return func(d *Downloader) {
+		d.processors = append(d.processors, processors...)
+	}
+}
+
+// WithSelectionStages appends stages, which run in order on the whole list of items
+// that made it through the filters and transforms.
+func WithSelectionStages(stages ...SelectionStage) Option {
+	return func(d *Downloader) {
+		d.stages = append(d.stages, stages...)
+	}
+}
+
+// WithHooks runs the hooks in registry before and after the sync and each item.
+// A failing before-sync hook aborts the sync and a failing before-item hook skips
+// the item; failures of the after hooks are only reported.
+func WithHooks(registry *hooks.Registry) Option {
+	return func(d *Downloader) {
+		d.hooks = registry
+	}
+}
+
+// WithEventBus publishes ItemDownloaded, ItemFailed and SyncFinished events on bus.
+func WithEventBus(bus *events.Bus) Option {
+	return func(d *Downloader) {
+		d.events = bus
+	}
+}
+
+// WithConcurrency sets how many items are downloaded at once. Defaults to 1.
+func WithConcurrency(n int) Option {
+	return func(d *Downloader) {
+		if n > 0 {
+			d.concurrency = n
+		}
+	}
+}
+
+// WithRetryPolicy retries downloads that fail with a network error, rate limiting
+// or a server error. By default failed downloads are not retried.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(d *Downloader) {
+		d.retry = policy
+	}
+}
+
+// WithClock sets the clock used for retry backoff and sync timing. Defaults to the
+// real clock.
+func WithClock(c clock.Clock) Option {
+	return func(d *Downloader) {
+		d.clock = c
+	}
+}
+
+// WithMaxFileSize skips items larger than n bytes, counting them as filtered. The
+// size is only known once the download starts, so oversized items cost a request.
+func WithMaxFileSize(n int64) Option {
+	return func(d *Downloader) {
+		d.maxFileSize = n
+	}
+}
+
+// WithReplaceChanged re-downloads items whose files already exist and replaces
+// those whose contents changed, e.g. after an edit in Google Photos. The previous
+// file is moved into the VersionsDir folder, which keeps the newest keep versions
+// of each file; with keep zero the previous file is discarded.
+func WithReplaceChanged(keep int) Option {
+	return func(d *Downloader) {
+		d.replace = true
+		d.keepVersions = keep
+	}
+}
+
+// WithSDFriendly minimises flash wear: every file is staged as a .part file and
+// renamed into place once complete, and none is fsynced until FlushOutputFiles.
+func WithSDFriendly() Option {
+	return func(d *Downloader) {
+		d.unflushed = &flushList{}
+	}
+}
+
+// FlushOutputFiles fsyncs every file written in SD-card friendly mode since the
+// last flush, along with the folders containing them, so the data is on the card
+// before the run ends. Without WithSDFriendly it does nothing.
+func (d *Downloader) FlushOutputFiles() error {
+	if d.unflushed == nil {
+		return nil
+	}
+	return d.unflushed.flush()
+}
+
+// WithLogger sends progress and failure messages to handler instead of slog.Default().
+func WithLogger(handler slog.Handler) Option {
+	return func(d *Downloader) {
+		d.logger = slog.New(handler)
+	}
+}
+
+// NewDownloader returns a Downloader that fetches with client and saves into folder.
+func NewDownloader(client transport.Doer, folder string, opts ...Option) *Downloader {
+	d := &Downloader{client: client, folder: folder, concurrency: 1, clock: clock.Real, logger: slog.Default()}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.retry.Clock == nil {
+		d.retry.Clock = d.clock
+	}
+	if d.retry.Logger == nil {
+		d.retry.Logger = d.logger
+	}
+	return d
+}
+
+// Result summarises a Download call.
+type Result struct {
+	Downloaded int
+	Existing   int
+	Filtered   int
+	Failed     int
+
+	// BytesSaved is the total size of the files that were already in the folder
+	// and so were not transferred.
+	BytesSaved int64
+
+	// Aborted is set if the sync stopped early because too many items failed.
+	Aborted bool
+	// Interrupted is set if ctx was cancelled before every item was dealt with,
+	// leaving some neither downloaded nor counted as failed.
+	Interrupted bool
+	// Added lists the files this run created, as opposed to replaced or left
+	// alone, so that a failed sync can be rolled back.
+	Added []string
+
+	// Saved holds the items now present in the folder, under their final filenames.
+	Saved []Item
+	// Skipped lists the filtered items and why they were left out.
+	Skipped []Skipped
+}
+
+// Skipped records an item left out by the pipeline.
+type Skipped struct {
+	Filename string
+	Reason   string
+}
+
+// tally accumulates a Result from concurrent workers.
+type tally struct {
+	mu     sync.Mutex
+	result Result
+}
+
+func (t *tally) update(f func(r *Result)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	f(&t.result)
+}
+
+// Download runs every item through the pipeline and downloads those that pass,
+// reporting failures and carrying on with the remaining items. It stops early if
+// ctx is cancelled, setting Result.Interrupted, or too many items fail, and returns
+// an error only if the sync could not start.
+func (d *Downloader) Download(ctx context.Context, items picker.DownloadableMediaItems) (Result, error) {
+	start := d.clock.Now()
+	if err := d.hooks.Fire(ctx, hooks.Payload{Stage: hooks.BeforeSync, Folder: d.folder}); err != nil {
+		return Result{}, err
+	}
+
+	var t tally
+	// Downloads in flight are cancelled too once the failure limit is reached
+	work, abort := context.WithCancel(ctx)
+	defer abort()
+	tooManyFailures := func() bool {
+		failed := 0
+		t.update(func(r *Result) { failed = r.Failed })
+		return d.maxFailures > 0 && failed >= d.maxFailures
+	}
+
+	jobs := make(chan *Item)
+	var wg sync.WaitGroup
+	for i := 0; i < d.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				d.downloadOne(work, item, &t)
+				if tooManyFailures() {
+					abort()
+				}
+			}
+		}()
+	}
+
+	// Filters, transforms and selection stages run here, in selection order, so
+	// that only the downloads themselves happen concurrently
+	prepared := d.plan(items, &t)
+	if d.previous != nil {
+		d.rename(prepared, d.previous())
+	}
+
+	claimed := make(map[string]bool)
+	for _, item := range prepared {
+		if tooManyFailures() {
+			d.logger.Warn("Stopping downloads after too many failures", "limit", d.maxFailures)
+			abort()
+			break
+		}
+		if ctx.Err() != nil {
+			d.logger.Warn("Stopping downloads", "err", ctx.Err())
+			break
+		}
+		// Two items with the same name would race for the same file
+		if claimed[item.Filename] {
+			d.logger.Info("File already exists, skipping download", "file", item.Filename)
+			t.update(func(r *Result) { r.Existing++ })
+			continue
+		}
+		claimed[item.Filename] = true
+
+		select {
+		case jobs <- item:
+		case <-work.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	result := t.result
+	result.Aborted = tooManyFailures()
+	result.Interrupted = ctx.Err() != nil
+
+	summary := &hooks.Summary{
+		Downloaded: result.Downloaded,
+		Existing:   result.Existing,
+		Filtered:   result.Filtered,
+		Failed:     result.Failed,
+	}
+	if err := d.hooks.Fire(ctx, hooks.Payload{Stage: hooks.AfterSync, Folder: d.folder, Summary: summary}); err != nil {
+		d.logger.Error("Error running after-sync hooks", "err", err)
+	}
+	d.events.Publish(events.SyncFinished{
+		Folder:     d.folder,
+		Downloaded: result.Downloaded,
+		Existing:   result.Existing,
+		Filtered:   result.Filtered,
+		Failed:     result.Failed,
+		Duration:   d.clock.Now().Sub(start),
+	})
+	return result, nil
+}
+
+// Plan runs items through the filters, transforms and selection stages without
+// downloading anything, and returns those a Download would fetch, for callers that
+// fetch items themselves.
+func (d *Downloader) Plan(items picker.DownloadableMediaItems) []*Item {
+	var t tally
+	return d.plan(items, &t)
+}
+
+// plan prepares items and runs the selection stages, counting those left out in t.
+func (d *Downloader) plan(items picker.DownloadableMediaItems, t *tally) []*Item {
+	var prepared []*Item
+	for _, picked := range items.MediaItems {
+		item, reason, err := d.prepare(picked)
+		if err != nil {
+			d.logger.Error("Error preparing item", "file", picked.MediaFile.Filename, "err", err)
+			t.update(func(r *Result) { r.Failed++ })
+			d.events.Publish(events.ItemFailed{ItemID: picked.Id, Filename: picked.MediaFile.Filename, Err: err})
+			continue
+		}
+		if item == nil {
+			d.skip(t, picked.MediaFile.Filename, reason)
+			continue
+		}
+		prepared = append(prepared, item)
+	}
+	return d.selectItems(prepared, t)
+}
+
+// downloadOne runs the item hooks around the download of a prepared item,
+// recording the outcome in t.
+func (d *Downloader) downloadOne(ctx context.Context, item *Item, t *tally) {
+	info := &hooks.ItemInfo{
+		ID:         item.Id,
+		Type:       string(item.Type),
+		CreateTime: item.CreateTime,
+		Filename:   item.Filename,
+		Path:       filepath.Join(d.folder, item.Filename),
+	}
+	if err := d.hooks.Fire(ctx, hooks.Payload{Stage: hooks.BeforeItem, Folder: d.folder, Item: info}); err != nil {
+		d.logger.Info("Skipping", "file", item.Filename, "reason", err)
+		t.update(func(r *Result) { r.Failed++ })
+		d.events.Publish(events.ItemFailed{ItemID: item.Id, Filename: item.Filename, Err: err})
+		return
+	}
+	if d.gate != nil {
+		if err := d.gate(ctx, item); err != nil {
+			d.logger.Error("Error downloading", "file", item.Filename, "err", err)
+			t.update(func(r *Result) { r.Failed++ })
+			d.events.Publish(events.ItemFailed{ItemID: item.Id, Filename: item.Filename, Err: err})
+			return
+		}
+	}
+
+	replace := d.replace && (d.unchanged == nil || !d.unchanged(item))
+	var hold func(size int64) bool
+	if d.sizeGate != nil {
+		hold = func(size int64) bool { return d.sizeGate.Hold(item, size) }
+	}
+	var outcome fetched
+	var tooLarge *TooLargeError
+	var err error
+	for {
+		var held *heldError
+		err = d.retry.Do(ctx, "Download of "+item.Filename, func() error {
+			var err error
+			outcome, err = fetchToFolder(ctx, d.client, item.URL, d.folder, item.Filename, fetchOptions{
+				logger:       d.logger,
+				maxSize:      d.maxFileSize,
+				hold:         hold,
+				replace:      replace,
+				keepVersions: d.keepVersions,
+				clock:        d.clock,
+				processors:   d.processors,
+				unflushed:    d.unflushed,
+			})
+			// Retrying would not make the file any smaller, and held
+			// downloads wait for the gate below
+			if errors.As(err, &tooLarge) || errors.As(err, &held) {
+				return nil
+			}
+			return err
+		})
+		if err != nil || held == nil {
+			break
+		}
+		err = d.sizeGate.Wait(ctx, item, held.size)
+		if err != nil {
+			break
+		}
+	}
+	item.Size, item.SHA256 = outcome.bytes, outcome.digest
+	switch {
+	case tooLarge != nil:
+		d.skip(t, item.Filename, tooLarge.Error())
+		info.Error = tooLarge.Error()
+	case err != nil:
+		d.logger.Error("Error downloading", "file", item.Filename, "err", err)
+		t.update(func(r *Result) { r.Failed++ })
+		info.Error = err.Error()
+		d.events.Publish(events.ItemFailed{ItemID: item.Id, Filename: item.Filename, Err: err})
+	case outcome.downloaded:
+		t.update(func(r *Result) {
+			r.Downloaded++
+			if !outcome.replaced {
+				r.Added = append(r.Added, item.Filename)
+			}
+			r.Saved = append(r.Saved, *item)
+		})
+		info.Downloaded = true
+		d.events.Publish(events.ItemDownloaded{ItemID: item.Id, Filename: item.Filename, Path: info.Path, Bytes: outcome.bytes})
+	default:
+		// A replacement that turned out the same was transferred all the same
+		var saved int64
+		if outcome.bytes == 0 {
+			if fi, err := os.Stat(info.Path); err == nil {
+				saved = fi.Size()
+			}
+		}
+		t.update(func(r *Result) {
+			r.Existing++
+			r.BytesSaved += saved
+			r.Saved = append(r.Saved, *item)
+		})
+	}
+
+	if err := d.hooks.Fire(ctx, hooks.Payload{Stage: hooks.AfterItem, Folder: d.folder, Item: info}); err != nil {
+		d.logger.Error("Error running after-item hooks", "file", item.Filename, "err", err)
+	}
+}
+
+// rename moves the files of items an earlier sync saved under another name to the
+// names now planned for them. Every file is moved aside before any is moved into
+// place, so that items swapping numbers cannot overwrite each other. A file whose
+// new name is taken goes back where it was, and its item is downloaded as usual.
+func (d *Downloader) rename(items []*Item, previous map[string]string) {
+	type move struct{ from, to string }
+	var moves []move
+	for _, item := range items {
+		old, ok := previous[item.Id]
+		if !ok || old == item.Filename {
+			continue
+		}
+		path := filepath.Join(d.folder, old)
+		if err := os.Rename(path, path+".renaming"); err != nil {
+			if !errors.Is(err, os.ErrNotExist) {
+				d.logger.Warn("Unable to rename", "file", old, "err", err)
+			}
+			continue
+		}
+		moves = append(moves, move{old, item.Filename})
+	}
+	for _, m := range moves {
+		from, to := filepath.Join(d.folder, m.from), filepath.Join(d.folder, m.to)
+		_, err := os.Lstat(to)
+		if err == nil {
+			err = fs.ErrExist
+		} else if errors.Is(err, os.ErrNotExist) {
+			err = os.MkdirAll(filepath.Dir(to), 0o755)
+			if err == nil {
+				err = os.Rename(from+".renaming", to)
+			}
+		}
+		if err != nil {
+			d.logger.Warn("Unable to rename", "file", m.from, "to", m.to, "err", err)
+			if err := os.Rename(from+".renaming", from); err != nil {
+				d.logger.Error("Unable to restore", "file", m.from, "err", err)
+			}
+			continue
+		}
+		d.logger.Info("Renamed", "file", m.from, "to", m.to)
+	}
+}
+
+// skip reports and counts an item left out by the pipeline.
+func (d *Downloader) skip(t *tally, filename, reason string) {
+	d.logger.Info("Skipping", "file", filename, "reason", reason)
+	t.update(func(r *Result) {
+		r.Filtered++
+		r.Skipped = append(r.Skipped, Skipped{Filename: filename, Reason: reason})
+	})
+}
+
+// selectItems runs the selection stages, counting the items they drop as filtered.
+func (d *Downloader) selectItems(items []*Item, t *tally) []*Item {
+	for _, stage := range d.stages {
+		kept := stage.Select(items)
+		keptSet := make(map[*Item]bool, len(kept))
+		for _, item := range kept {
+			keptSet[item] = true
+		}
+		for _, item := range items {
+			if !keptSet[item] {
+				d.skip(t, item.Filename, "not selected")
+			}
+		}
+		items = kept
+	}
+	return items
+}
+
+// prepare runs the filters and transforms. It returns a nil item and the reason if
+// a filter rejected it.
+func (d *Downloader) prepare(picked picker.PickedMediaItem) (*Item, string, error) {
+	item := newItem(picked)
+	for _, filter := range d.filters {
+		if ok, reason := filter.Allow(item); !ok {
+			return nil, reason, nil
+		}
+	}
+	for _, transform := range d.transforms {
+		if err := transform.Transform(item); err != nil {
+			return nil, "", err
+		}
+	}
+	return item, "", nil
+}
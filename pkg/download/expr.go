@@ -0,0 +1,317 @@
+// expr.go
+//
+// A small expression language for filtering items on their metadata, e.g.
+//
+//	type == "PHOTO" && width >= 1600 && created > "2023-01-01"
+//
+// Comparisons (==, !=, <, <=, >, >=, and ~ for glob matching) join a field and a
+// literal, and combine with &&, || and ! and parentheses. Fields:
+//
+//	id, filename, type, mime, camera   strings
+//	width, height, megapixels, ratio   numbers (ratio is width/height)
+//	created                            capture time, compared with "YYYY-MM-DD" or RFC 3339
+//
+// Comparisons against metadata the item lacks, such as the width of an item Google
+// reported no dimensions for, are false.
+package download
+
+import (
+	"cmp"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Expression compiles src into a filter that allows the items it is true for.
+func Expression(src string) (ItemFilter, error) {
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %v", err)
+	}
+	p := &exprParser{tokens: tokens}
+	eval, err := p.parseOr()
+	if err == nil && p.peek().kind != tokEOF {
+		err = fmt.Errorf("unexpected %q", p.peek().text)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter expression: %v", err)
+	}
+	return FilterFunc(func(item *Item) (bool, string) {
+		if eval(item) {
+			return true, ""
+		}
+		return false, "does not match " + src
+	}), nil
+}
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// lex splits src into identifiers, quoted strings, numbers and operators.
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := rune(src[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || c == '_':
+			start := i
+			for i < len(src) && (unicode.IsLetter(rune(src[i])) || unicode.IsDigit(rune(src[i])) || src[i] == '_') {
+				i++
+			}
+			tokens = append(tokens, token{tokIdent, src[start:i]})
+		case unicode.IsDigit(c) || c == '.':
+			start := i
+			for i < len(src) && (unicode.IsDigit(rune(src[i])) || src[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{tokNumber, src[start:i]})
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, fmt.Errorf("unterminated string at offset %d", i)
+			}
+			text, err := strconv.Unquote(src[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string %s", src[i:end+1])
+			}
+			tokens = append(tokens, token{tokString, text})
+			i = end + 1
+		default:
+			op := ""
+			for _, candidate := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "~", "(", ")"} {
+				if strings.HasPrefix(src[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q at offset %d", c, i)
+			}
+			tokens = append(tokens, token{tokOp, op})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF}), nil
+}
+
+// predicate evaluates a compiled expression against an item.
+type predicate func(item *Item) bool
+
+type exprParser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *exprParser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) acceptOp(op string) bool {
+	if t := p.peek(); t.kind == tokOp && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) parseOr() (predicate, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item *Item) bool { return l(item) || right(item) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (predicate, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptOp("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(item *Item) bool { return l(item) && right(item) }
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (predicate, error) {
+	if p.acceptOp("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return func(item *Item) bool { return !operand(item) }, nil
+	}
+	if p.acceptOp("(") {
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.acceptOp(")") {
+			return nil, fmt.Errorf("missing )")
+		}
+		return inner, nil
+	}
+	return p.parseComparison()
+}
+
+// parseComparison parses "field op literal".
+func (p *exprParser) parseComparison() (predicate, error) {
+	fieldTok := p.next()
+	if fieldTok.kind != tokIdent {
+		return nil, fmt.Errorf("expected a field name, got %q", fieldTok.text)
+	}
+	opTok := p.next()
+	if opTok.kind != tokOp || !strings.Contains(" == != < <= > >= ~ ", " "+opTok.text+" ") {
+		return nil, fmt.Errorf("expected a comparison after %s, got %q", fieldTok.text, opTok.text)
+	}
+	literal := p.next()
+	if literal.kind != tokString && literal.kind != tokNumber {
+		return nil, fmt.Errorf("expected a value after %s %s, got %q", fieldTok.text, opTok.text, literal.text)
+	}
+
+	field := fieldTok.text
+	op := opTok.text
+	if get, ok := stringFields[field]; ok {
+		if literal.kind != tokString {
+			return nil, fmt.Errorf("%s is compared with a quoted string", field)
+		}
+		want := literal.text
+		if op == "~" {
+			if _, err := path.Match(want, ""); err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", want, err)
+			}
+			return func(item *Item) bool {
+				ok, _ := path.Match(want, get(item))
+				return ok
+			}, nil
+		}
+		return func(item *Item) bool { return compare(op, strings.Compare(get(item), want)) }, nil
+	}
+	if get, ok := numberFields[field]; ok {
+		if literal.kind != tokNumber || op == "~" {
+			return nil, fmt.Errorf("%s is compared with a number", field)
+		}
+		want, err := strconv.ParseFloat(literal.text, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number %q", literal.text)
+		}
+		return func(item *Item) bool {
+			got, ok := get(item)
+			return ok && compare(op, cmp.Compare(got, want))
+		}, nil
+	}
+	if field == "created" {
+		if literal.kind != tokString || op == "~" {
+			return nil, fmt.Errorf("created is compared with a quoted date")
+		}
+		want, err := time.ParseInLocation(time.DateOnly, literal.text, time.Local)
+		if err != nil {
+			want, err = time.Parse(time.RFC3339, literal.text)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid date %q: use YYYY-MM-DD or RFC 3339", literal.text)
+		}
+		return func(item *Item) bool {
+			got, ok := item.created()
+			return ok && compare(op, got.Compare(want))
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown field %q", field)
+}
+
+var stringFields = map[string]func(item *Item) string{
+	"id":       func(item *Item) string { return item.Id },
+	"filename": func(item *Item) string { return item.MediaFile.Filename },
+	"type":     func(item *Item) string { return string(item.Type) },
+	"mime":     func(item *Item) string { return item.MediaFile.MimeType },
+	"camera": func(item *Item) string {
+		meta := item.MediaFile.MediaFileMetadata
+		return strings.TrimSpace(meta.CameraMake + " " + meta.CameraModel)
+	},
+}
+
+// numberFields report false if the item has no value for the field.
+var numberFields = map[string]func(item *Item) (float64, bool){
+	"width": func(item *Item) (float64, bool) {
+		w := item.MediaFile.MediaFileMetadata.Width
+		return float64(w), w > 0
+	},
+	"height": func(item *Item) (float64, bool) {
+		h := item.MediaFile.MediaFileMetadata.Height
+		return float64(h), h > 0
+	},
+	"megapixels": func(item *Item) (float64, bool) {
+		meta := item.MediaFile.MediaFileMetadata
+		return float64(meta.Width) * float64(meta.Height) / 1e6, meta.Width > 0 && meta.Height > 0
+	},
+	"ratio": func(item *Item) (float64, bool) {
+		meta := item.MediaFile.MediaFileMetadata
+		if meta.Width == 0 || meta.Height == 0 {
+			return 0, false
+		}
+		return float64(meta.Width) / float64(meta.Height), true
+	},
+}
+
+// compare applies op to the result c of comparing two values.
+func compare(op string, c int) bool {
+	switch op {
+	case "==":
+		return c == 0
+	case "!=":
+		return c != 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	}
+	return false
+}
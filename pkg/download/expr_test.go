@@ -0,0 +1,108 @@
+package download
+
+import (
+	"strings"
+	"testing"
+
+	"PhotoSync/pkg/picker"
+)
+
+func exprItem(typ picker.MediaType, filename string, width, height int, created string) *Item {
+	return &Item{PickedMediaItem: picker.PickedMediaItem{
+		Id:         "id-" + filename,
+		CreateTime: created,
+		Type:       typ,
+		MediaFile: picker.MediaFile{
+			Filename:          filename,
+			MimeType:          "image/jpeg",
+			MediaFileMetadata: picker.MediaFileMetadata{Width: width, Height: height, CameraMake: "Canon", CameraModel: "EOS R6"},
+		},
+	}}
+}
+
+func TestExpression(t *testing.T) {
+	landscape := exprItem(picker.MediaTypePhoto, "beach.jpg", 4000, 3000, "2023-06-01T10:00:00Z")
+	portrait := exprItem(picker.MediaTypePhoto, "tower.jpg", 1000, 2000, "2022-01-01T10:00:00Z")
+	video := exprItem(picker.MediaTypeVideo, "clip.mp4", 0, 0, "2023-02-01T10:00:00Z")
+
+	tests := []struct {
+		src  string
+		item *Item
+		want bool
+	}{
+		{`type == "PHOTO"`, landscape, true},
+		{`type != "PHOTO"`, video, true},
+		{`filename ~ "*.jpg"`, portrait, true},
+		{`filename ~ "*.jpg"`, video, false},
+		{`camera == "Canon EOS R6"`, landscape, true},
+		{`width >= 1600`, landscape, true},
+		{`width >= 1600`, portrait, false},
+		{`megapixels > 10`, landscape, true},
+		{`ratio < 1`, portrait, true},
+		{`created > "2023-01-01"`, landscape, true},
+		{`created > "2023-01-01"`, portrait, false},
+		{`created <= "2023-02-01T10:00:00Z"`, video, true},
+
+		// Missing metadata makes every comparison false, negated or not
+		{`width > 0`, video, false},
+		{`width == 0`, video, false},
+		{`!(width > 0)`, video, true},
+
+		// && binds tighter than ||
+		{`type == "VIDEO" || type == "PHOTO" && width > 2000`, video, true},
+		{`type == "VIDEO" || type == "PHOTO" && width > 2000`, portrait, false},
+		{`(type == "VIDEO" || type == "PHOTO") && width > 2000`, video, false},
+		{`(type == "VIDEO" || type == "PHOTO") && width > 2000`, landscape, true},
+
+		// ! applies to the comparison or group that follows it
+		{`!type == "VIDEO" && width > 2000`, landscape, true},
+		{`!(type == "VIDEO" || width > 2000)`, portrait, true},
+		{`!!type == "PHOTO"`, portrait, true},
+		{`!type == "PHOTO" || ratio < 1`, portrait, true},
+	}
+	for _, tt := range tests {
+		filter, err := Expression(tt.src)
+		if err != nil {
+			t.Errorf("Expression(%q): %v", tt.src, err)
+			continue
+		}
+		if got, _ := filter.Allow(tt.item); got != tt.want {
+			t.Errorf("Expression(%q) on %s = %v, want %v", tt.src, tt.item.MediaFile.Filename, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{``, "expected a field name"},
+		{`width`, "expected a comparison after width"},
+		{`width >`, "expected a value after width >"},
+		{`colour == "red"`, `unknown field "colour"`},
+		{`width == "wide"`, "width is compared with a number"},
+		{`width ~ 10`, "width is compared with a number"},
+		{`type == 1`, "type is compared with a quoted string"},
+		{`created > 2023`, "created is compared with a quoted date"},
+		{`created > "last week"`, `invalid date "last week"`},
+		{`filename ~ "[a"`, `invalid pattern "[a"`},
+		{`width > 1.2.3`, `invalid number "1.2.3"`},
+		{`type == "PHOTO`, "unterminated string"},
+		{`width > 10 $`, "unexpected character '$'"},
+		{`(width > 10`, "missing )"},
+		{`width > 10)`, `unexpected ")"`},
+		{`width > 10 &&`, "expected a field name"},
+		{`width > 10 height > 10`, `unexpected "height"`},
+	}
+	for _, tt := range tests {
+		_, err := Expression(tt.src)
+		if err == nil {
+			t.Errorf("Expression(%q) succeeded, want an error containing %q", tt.src, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expression(%q) error = %q, want it to contain %q", tt.src, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,281 @@
+// filters.go
+//
+// Built-in item filters.
+package download
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/picker"
+)
+
+// OnlyTypes allows only items of the given media types, e.g. to skip videos on
+// frames that cannot play them.
+func OnlyTypes(types ...picker.MediaType) ItemFilter {
+	return FilterFunc(func(item *Item) (bool, string) {
+		for _, t := range types {
+			if item.Type == t {
+				return true, ""
+			}
+		}
+		return false, fmt.Sprintf("media type %s not wanted", item.Type)
+	})
+}
+
+// CreatedBetween allows items captured within [since, until). A zero bound is open.
+// Items without a valid capture time are rejected.
+func CreatedBetween(since, until time.Time) ItemFilter {
+	return FilterFunc(func(item *Item) (bool, string) {
+		created, ok := item.created()
+		switch {
+		case !ok:
+			return false, "unknown capture date"
+		case !since.IsZero() && created.Before(since):
+			return false, "captured before " + since.Format(time.DateOnly)
+		case !until.IsZero() && !created.Before(until):
+			return false, "captured after " + until.Format(time.DateOnly)
+		}
+		return true, ""
+	})
+}
+
+// MinResolution rejects items smaller than width x height or with fewer than
+// megapixels million pixels. The size check ignores orientation: the item's long
+// edge is compared with the larger of width and height, and its short edge with the
+// smaller. Zero thresholds are not checked, and items whose dimensions Google did
+// not report are allowed.
+func MinResolution(width, height int, megapixels float64) ItemFilter {
+	long, short := max(width, height), min(width, height)
+	return FilterFunc(func(item *Item) (bool, string) {
+		meta := item.MediaFile.MediaFileMetadata
+		if meta.Width == 0 || meta.Height == 0 {
+			return true, ""
+		}
+		itemLong, itemShort := max(meta.Width, meta.Height), min(meta.Width, meta.Height)
+		if itemLong < long || itemShort < short {
+			return false, fmt.Sprintf("%dx%d is below the minimum size", meta.Width, meta.Height)
+		}
+		if mp := float64(meta.Width) * float64(meta.Height) / 1e6; mp < megapixels {
+			return false, fmt.Sprintf("%.1f megapixels is below the minimum of %.1f", mp, megapixels)
+		}
+		return true, ""
+	})
+}
+
+// MatchFilenames filters items on their original filename. An item must match at
+// least one include pattern, if any are given, and no exclude pattern. Patterns are
+// shell globs as understood by path.Match, e.g. "Screenshot*" or "*.PNG", unless
+// prefixed with "re:", in which case the rest is a regular expression, e.g.
+// "re:(?i)\\.png$". Globs are case sensitive.
+func MatchFilenames(include, exclude []string) (ItemFilter, error) {
+	includes, err := compilePatterns(include)
+	if err != nil {
+		return nil, err
+	}
+	excludes, err := compilePatterns(exclude)
+	if err != nil {
+		return nil, err
+	}
+	return FilterFunc(func(item *Item) (bool, string) {
+		name := item.MediaFile.Filename
+		for _, p := range excludes {
+			if p.match(name) {
+				return false, "matches exclude pattern " + p.source
+			}
+		}
+		if len(includes) == 0 {
+			return true, ""
+		}
+		for _, p := range includes {
+			if p.match(name) {
+				return true, ""
+			}
+		}
+		return false, "matches no include pattern"
+	}), nil
+}
+
+// filenamePattern is a compiled glob or regular expression.
+type filenamePattern struct {
+	source string
+	re     *regexp.Regexp
+}
+
+func (p filenamePattern) match(name string) bool {
+	if p.re != nil {
+		return p.re.MatchString(name)
+	}
+	ok, _ := path.Match(p.source, name)
+	return ok
+}
+
+func compilePatterns(patterns []string) ([]filenamePattern, error) {
+	var compiled []filenamePattern
+	for _, source := range patterns {
+		p := filenamePattern{source: source}
+		if expr, ok := strings.CutPrefix(source, "re:"); ok {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid pattern %q: %v", source, err)
+			}
+			p.re = re
+		} else if _, err := path.Match(source, ""); err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", source, err)
+		}
+		compiled = append(compiled, p)
+	}
+	return compiled, nil
+}
+
+// Orientations accepted by OnlyOrientation.
+const (
+	Landscape = "landscape"
+	Portrait  = "portrait"
+)
+
+// OnlyOrientation allows only landscape or only portrait items. Square items and
+// items whose dimensions are unknown are allowed.
+func OnlyOrientation(orientation string) ItemFilter {
+	return FilterFunc(func(item *Item) (bool, string) {
+		meta := item.MediaFile.MediaFileMetadata
+		switch {
+		case orientation == Landscape && meta.Height > meta.Width:
+			return false, "portrait"
+		case orientation == Portrait && meta.Width > meta.Height:
+			return false, "landscape"
+		}
+		return true, ""
+	})
+}
+
+// AspectRatio allows items whose width/height ratio is within tolerance of ratio,
+// measured relative to ratio: a tolerance of 0.1 accepts 1.6 to 1.96 for a 16:9
+// frame. Items whose dimensions are unknown are allowed.
+func AspectRatio(ratio, tolerance float64) ItemFilter {
+	return FilterFunc(func(item *Item) (bool, string) {
+		meta := item.MediaFile.MediaFileMetadata
+		if meta.Width == 0 || meta.Height == 0 {
+			return true, ""
+		}
+		itemRatio := float64(meta.Width) / float64(meta.Height)
+		if math.Abs(itemRatio/ratio-1) > tolerance {
+			return false, fmt.Sprintf("aspect ratio %.2f is too far from %.2f", itemRatio, ratio)
+		}
+		return true, ""
+	})
+}
+
+// Newest keeps only the n most recently captured items, leaving them in their
+// original order. Items without a valid capture time count as the oldest.
+func Newest(n int) SelectionStage {
+	return SelectionFunc(func(items []*Item) []*Item {
+		if len(items) <= n {
+			return items
+		}
+		byAge := slices.Clone(items)
+		slices.SortStableFunc(byAge, func(a, b *Item) int {
+			ac, _ := a.created()
+			bc, _ := b.created()
+			return bc.Compare(ac)
+		})
+		newest := make(map[*Item]bool, n)
+		for _, item := range byAge[:n] {
+			newest[item] = true
+		}
+		return slices.DeleteFunc(slices.Clone(items), func(item *Item) bool {
+			return !newest[item]
+		})
+	})
+}
+
+// ScreenshotReason starts the reason given for every item SkipScreenshots rejects.
+const ScreenshotReason = "looks like a screenshot or document"
+
+// screenshotName matches the names screenshot tools and scanner apps give their files.
+var screenshotName = regexp.MustCompile(`(?i)screen[ _-]?shot|^(scan|receipt|document)[ _-]`)
+
+// SkipScreenshots rejects photos that look like screenshots, scanned documents,
+// receipts or memes rather than camera photos. A screenshot-style filename is enough
+// on its own; otherwise an item needs two of these signs: it is a PNG, it carries no
+// camera make or model, or one side is more than twice the other. Videos are allowed.
+func SkipScreenshots() ItemFilter {
+	return FilterFunc(func(item *Item) (bool, string) {
+		if item.Type == picker.MediaTypeVideo {
+			return true, ""
+		}
+		if screenshotName.MatchString(item.MediaFile.Filename) {
+			return false, ScreenshotReason + ": filename"
+		}
+
+		var signs []string
+		if item.MediaFile.MimeType == "image/png" || strings.EqualFold(path.Ext(item.MediaFile.Filename), ".png") {
+			signs = append(signs, "PNG")
+		}
+		meta := item.MediaFile.MediaFileMetadata
+		if meta.Width > 0 && meta.Height > 0 {
+			if meta.CameraMake == "" && meta.CameraModel == "" {
+				signs = append(signs, "no camera")
+			}
+			if max(meta.Width, meta.Height) > 2*min(meta.Width, meta.Height) {
+				signs = append(signs, "extreme aspect ratio")
+			}
+		}
+		if len(signs) >= 2 {
+			return false, ScreenshotReason + ": " + strings.Join(signs, ", ")
+		}
+		return true, ""
+	})
+}
+
+// Orders accepted by NumberedOrder.
+const (
+	// OrderPicker keeps the order the items were picked in.
+	OrderPicker = "picker"
+	// OrderChronological sorts items by capture time, oldest first.
+	OrderChronological = "chronological"
+	// OrderShuffle shuffles items with a seeded random order.
+	OrderShuffle = "shuffle"
+)
+
+// NumberedOrder puts items in the given order and prefixes each filename with its
+// position, e.g. "0001_", so that frames which play files alphabetically show them in
+// that order. The same seed always gives the same shuffle. Changing the order or the
+// selection renumbers the items; see WithPreviousNames for moving their files to
+// the new numbers rather than downloading them again.
+func NumberedOrder(order string, seed int64) (SelectionStage, error) {
+	switch order {
+	case OrderPicker, OrderChronological, OrderShuffle:
+	default:
+		return nil, fmt.Errorf("unknown order %q", order)
+	}
+	return SelectionFunc(func(items []*Item) []*Item {
+		ordered := slices.Clone(items)
+		switch order {
+		case OrderChronological:
+			slices.SortStableFunc(ordered, func(a, b *Item) int {
+				ac, _ := a.created()
+				bc, _ := b.created()
+				return ac.Compare(bc)
+			})
+		case OrderShuffle:
+			r := rand.New(rand.NewSource(seed))
+			r.Shuffle(len(ordered), func(i, j int) {
+				ordered[i], ordered[j] = ordered[j], ordered[i]
+			})
+		}
+		width := max(4, len(strconv.Itoa(len(ordered))))
+		for i, item := range ordered {
+			item.Filename = fmt.Sprintf("%0*d_%s", width, i+1, item.Filename)
+			item.Numbered = true
+		}
+		return ordered
+	}), nil
+}
@@ -0,0 +1,78 @@
+// gc.go
+//
+// Clean-up of files a sync leaves behind: .part files from interrupted downloads
+// and previous versions past their retention.
+package download
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Garbage lists the files found by FindGarbage.
+type Garbage struct {
+	// StaleParts are .part files last written before the cutoff, so no running
+	// download owns them.
+	StaleParts []string
+	// OldVersions are kept versions stamped before the version cutoff.
+	OldVersions []string
+}
+
+// FindGarbage returns the .part files in folder last modified more than partAge
+// before now and, if versionAge is positive, the kept versions older than that.
+// Paths are absolute or relative as folder is.
+func FindGarbage(folder string, now time.Time, partAge, versionAge time.Duration) (Garbage, error) {
+	var garbage Garbage
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return garbage, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), partSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if now.Sub(info.ModTime()) > partAge {
+			garbage.StaleParts = append(garbage.StaleParts, filepath.Join(folder, entry.Name()))
+		}
+	}
+
+	if versionAge <= 0 {
+		return garbage, nil
+	}
+	dir := filepath.Join(folder, VersionsDir)
+	entries, err = os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return garbage, nil
+	}
+	if err != nil {
+		return garbage, err
+	}
+	for _, entry := range entries {
+		stamp, ok := versionStamp(entry.Name())
+		if ok && now.Sub(stamp) > versionAge {
+			garbage.OldVersions = append(garbage.OldVersions, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return garbage, nil
+}
+
+// versionStamp returns the time a kept version was stamped with. The stamp comes
+// before the extension, or last for files that have none.
+func versionStamp(name string) (time.Time, bool) {
+	for _, stem := range []string{strings.TrimSuffix(name, filepath.Ext(name)), name} {
+		dot := strings.LastIndex(stem, ".")
+		if dot < 0 {
+			continue
+		}
+		if stamp, err := time.ParseInLocation(versionTimeFormat, stem[dot+1:], time.Local); err == nil {
+			return stamp, true
+		}
+	}
+	return time.Time{}, false
+}
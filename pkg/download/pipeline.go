@@ -0,0 +1,179 @@
+// pipeline.go
+//
+// Filters and transforms applied to picked media items before they are downloaded.
+package download
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+
+	"PhotoSync/pkg/picker"
+)
+
+// Item is a picked media item on its way through the download pipeline.
+type Item struct {
+	picker.PickedMediaItem
+
+	// URL is fetched to download the item. It starts as the baseUrl with "=d"
+	// appended, which returns the original bytes.
+	URL string
+
+	// Filename is the name the item is saved as in the target folder.
+	Filename string
+	// Numbered is set once NumberedOrder has prefixed Filename with the item's
+	// position.
+	Numbered bool
+
+	// Size and SHA256 describe the saved file. They are set only for files fetched
+	// by this run, not for files left as they were in the folder.
+	Size   int64
+	SHA256 string
+}
+
+// newItem starts an item off with the original download URL and filename.
+func newItem(picked picker.PickedMediaItem) *Item {
+	return &Item{
+		PickedMediaItem: picked,
+		URL:             picked.MediaFile.BaseUrl + "=d",
+		Filename:        picked.MediaFile.Filename,
+	}
+}
+
+// created parses the item's capture time, reporting false if it is missing or invalid.
+func (item *Item) created() (time.Time, bool) {
+	created, err := time.Parse(time.RFC3339, item.CreateTime)
+	return created, err == nil
+}
+
+// ItemFilter decides whether an item is downloaded at all.
+type ItemFilter interface {
+	// Allow reports whether item should be downloaded and, if not, why.
+	Allow(item *Item) (bool, string)
+}
+
+// FilterFunc adapts an ordinary function to the ItemFilter interface.
+type FilterFunc func(item *Item) (bool, string)
+
+// Allow calls f(item).
+func (f FilterFunc) Allow(item *Item) (bool, string) {
+	return f(item)
+}
+
+// ItemTransform rewrites an item before it is downloaded, for example to rename
+// the output file or to request a resized variant from Google Photos.
+type ItemTransform interface {
+	Transform(item *Item) error
+}
+
+// TransformFunc adapts an ordinary function to the ItemTransform interface.
+type TransformFunc func(item *Item) error
+
+// Transform calls f(item).
+func (f TransformFunc) Transform(item *Item) error {
+	return f(item)
+}
+
+// FileProcessor rewrites a downloaded file in place before it is moved into the
+// target folder, for example to adjust its colours.
+type FileProcessor interface {
+	Process(path string) error
+}
+
+// ProcessFunc adapts an ordinary function to the FileProcessor interface.
+type ProcessFunc func(path string) error
+
+// Process calls f(path).
+func (f ProcessFunc) Process(path string) error {
+	return f(path)
+}
+
+// SelectionStage works on the whole list of items at once, for decisions that depend
+// on the other items, such as keeping only the newest few. It runs after the filters
+// and transforms and returns the items to download, in the order to download them.
+// Items it leaves out are counted as filtered.
+type SelectionStage interface {
+	Select(items []*Item) []*Item
+}
+
+// SelectionFunc adapts an ordinary function to the SelectionStage interface.
+type SelectionFunc func(items []*Item) []*Item
+
+// Select calls f(items).
+func (f SelectionFunc) Select(items []*Item) []*Item {
+	return f(items)
+}
+
+// MaxDimensions asks Google Photos to scale photos down to fit within width x height
+// before sending them, keeping their aspect ratio. Videos are left untouched.
+func MaxDimensions(width, height int) ItemTransform {
+	return TransformFunc(func(item *Item) error {
+		if item.Type == picker.MediaTypeVideo {
+			return nil
+		}
+		item.URL = fmt.Sprintf("%s=w%d-h%d", item.MediaFile.BaseUrl, width, height)
+		return nil
+	})
+}
+
+// CropTo asks Google Photos to scale and centre-crop photos to exactly width x
+// height before sending them, so they fill the frame without bars. Videos are left
+// untouched.
+func CropTo(width, height int) ItemTransform {
+	return TransformFunc(func(item *Item) error {
+		if item.Type == picker.MediaTypeVideo {
+			return nil
+		}
+		item.URL = fmt.Sprintf("%s=w%d-h%d-c", item.MediaFile.BaseUrl, width, height)
+		return nil
+	})
+}
+
+// renameData is the data available to rename templates.
+type renameData struct {
+	// ID is the media item ID.
+	ID string
+	// Filename is the current filename and Name/Ext are its parts.
+	Filename string
+	Name     string
+	Ext      string
+	// Created is the capture time; Date is it formatted as 2006-01-02.
+	Created time.Time
+	Date    string
+}
+
+// Rename renames items using a text/template, e.g. "{{.Date}}_{{.Filename}}".
+// Fields: ID, Filename, Name, Ext, Created and Date.
+func Rename(pattern string) (ItemTransform, error) {
+	tmpl, err := template.New("rename").Option("missingkey=error").Parse(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid rename template: %v", err)
+	}
+	return TransformFunc(func(item *Item) error {
+		ext := path.Ext(item.Filename)
+		data := renameData{
+			ID:       item.Id,
+			Filename: item.Filename,
+			Name:     strings.TrimSuffix(item.Filename, ext),
+			Ext:      ext,
+		}
+		if created, ok := item.created(); ok {
+			data.Created = created
+			data.Date = created.Format("2006-01-02")
+		}
+
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return fmt.Errorf("failed to rename %s: %v", item.Filename, err)
+		}
+		name := buf.String()
+		if name == "" || strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("rename template produced invalid filename %q", name)
+		}
+		item.Filename = name
+		return nil
+	}), nil
+}
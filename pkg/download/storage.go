@@ -0,0 +1,134 @@
+// storage.go
+//
+// Output file handling, including an SD-card friendly mode that keeps flash wear and
+// the risk of torn files low on photo frame hosts: each file is staged as a .part
+// file renamed into place once complete, and all fsyncs are batched into a single
+// flush at the end of the run.
+package download
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// partSuffix marks files that are still being written in SD-card friendly mode.
+const partSuffix = ".part"
+
+// outputFile is a file being written into the target folder.
+type outputFile struct {
+	*os.File
+	finalPath string
+	staged    bool
+	// unflushed, if set, collects the file once committed, for a single flush
+	// at the end of the run.
+	unflushed *flushList
+}
+
+// openOutputFile creates the file that will end up at path. A staged file is
+// written as a .part file and renamed into place by Commit, so that whatever is at
+// path stays intact until then. Files written in SD-card friendly mode, which have
+// an unflushed list, are always staged.
+func openOutputFile(path string, staged bool, unflushed *flushList) (*outputFile, error) {
+	staged = staged || unflushed != nil
+	writePath := path
+	if staged {
+		writePath = path + partSuffix
+	}
+	f, err := os.Create(writePath)
+	if err != nil {
+		return nil, err
+	}
+	return &outputFile{File: f, finalPath: path, staged: staged, unflushed: unflushed}, nil
+}
+
+// Commit closes the file and moves it into its final place.
+func (o *outputFile) Commit() error {
+	if err := o.File.Close(); err != nil {
+		os.Remove(o.Name())
+		return err
+	}
+	if !o.staged {
+		return nil
+	}
+	if err := os.Rename(o.Name(), o.finalPath); err != nil {
+		os.Remove(o.Name())
+		return err
+	}
+	if o.unflushed != nil {
+		o.unflushed.add(o.finalPath)
+	}
+	return nil
+}
+
+// Abort closes and removes the partially written file.
+func (o *outputFile) Abort() {
+	o.File.Close()
+	os.Remove(o.Name())
+}
+
+// flushList collects the files written in SD-card friendly mode until they are
+// flushed.
+type flushList struct {
+	mu    sync.Mutex
+	paths []string
+}
+
+func (l *flushList) add(path string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.paths = append(l.paths, path)
+}
+
+// flush fsyncs every file collected along with the folders containing them.
+func (l *flushList) flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	dirs := make(map[string]bool)
+	for _, path := range l.paths {
+		if err := syncPath(path); err != nil {
+			return fmt.Errorf("failed to flush %s: %v", path, err)
+		}
+		dirs[filepath.Dir(path)] = true
+	}
+	for dir := range dirs {
+		// Not every platform can fsync a directory; the files themselves are safe
+		if err := syncPath(dir); err != nil {
+			slog.Warn("Unable to flush folder", "dir", dir, "err", err)
+		}
+	}
+	l.paths = nil
+	return nil
+}
+
+func syncPath(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// CopyFile copies src to dst the way downloads are written, removing the partial
+// copy if it fails part way.
+func (d *Downloader) CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := openOutputFile(dst, false, d.unflushed)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Abort()
+		return err
+	}
+	return out.Commit()
+}
@@ -0,0 +1,234 @@
+package download_test
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"PhotoSync/internal/pickertest"
+	"PhotoSync/pkg/clock"
+	"PhotoSync/pkg/download"
+	"PhotoSync/pkg/picker"
+	"PhotoSync/pkg/retry"
+)
+
+// whileWaiting runs fn, advancing fake by a second whenever something is waiting on
+// it, so that polling, backoff and quota waits pass without real delays.
+func whileWaiting(fake *clock.Fake, fn func()) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn()
+	}()
+	for {
+		select {
+		case <-done:
+			return
+		case <-time.After(time.Millisecond):
+		}
+		if fake.Waiters() > 0 {
+			fake.Advance(time.Second)
+		}
+	}
+}
+
+// TestSyncResumeAndRetry picks items from a fake Picker API, downloads them with an
+// interruption part way, finishes the sync with a second run, and recovers an item
+// whose link expired by listing the session again.
+func TestSyncResumeAndRetry(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	discard := slog.NewTextHandler(io.Discard, nil)
+	policy := retry.Policy{Attempts: 3, Backoff: time.Second, Clock: fake, Logger: slog.New(discard)}
+	created := time.Date(2024, 4, 1, 12, 0, 0, 0, time.UTC)
+	server := pickertest.NewServer(
+		pickertest.WithItems(
+			pickertest.Item{ID: "a", Filename: "a.jpg", Type: picker.MediaTypePhoto, CreateTime: created},
+			pickertest.Item{ID: "b", Filename: "b.jpg", Type: picker.MediaTypePhoto, CreateTime: created},
+			pickertest.Item{ID: "c", Filename: "c.jpg", Type: picker.MediaTypePhoto, CreateTime: created},
+		),
+		pickertest.WithMaxPageSize(2),
+		pickertest.WithPollsUntilPicked(2),
+		pickertest.WithClock(fake),
+	)
+	defer server.Close()
+	ctx := context.Background()
+
+	client := picker.NewPickerClient(server.Client(),
+		picker.WithBaseURL(server.BaseURL()),
+		picker.WithClock(fake),
+		picker.WithRetryPolicy(policy),
+		picker.WithLogger(discard),
+	)
+	// Session creation is rate limited once and retried
+	server.RateLimit(1)
+	var session picker.PickingSession
+	var items picker.DownloadableMediaItems
+	var err error
+	whileWaiting(fake, func() {
+		session, err = client.CreateSession(ctx)
+		if err != nil {
+			return
+		}
+		// A poll over quota is skipped rather than ending the wait
+		server.RateLimit(1)
+		items, err = client.WaitForSelection(ctx, session)
+	})
+	if err != nil {
+		t.Fatalf("picking failed: %v", err)
+	}
+	if len(items.MediaItems) != 3 {
+		t.Fatalf("picked %d items over two pages, want 3", len(items.MediaItems))
+	}
+
+	folder := t.TempDir()
+	newDownloader := func(opts ...download.Option) *download.Downloader {
+		opts = append([]download.Option{
+			download.WithClock(fake),
+			download.WithRetryPolicy(policy),
+			download.WithLogger(discard),
+		}, opts...)
+		return download.NewDownloader(server.Client(), folder, opts...)
+	}
+
+	// The first run is interrupted as the second item comes up
+	interrupted, cancel := context.WithCancel(ctx)
+	defer cancel()
+	gate := func(ctx context.Context, item *download.Item) error {
+		if item.Id == "b" {
+			cancel()
+			return ctx.Err()
+		}
+		return nil
+	}
+	var result download.Result
+	whileWaiting(fake, func() {
+		result, err = newDownloader(download.WithGate(gate)).Download(interrupted, items)
+	})
+	if err != nil {
+		t.Fatalf("first run: %v", err)
+	}
+	if !result.Interrupted || result.Downloaded != 1 {
+		t.Fatalf("first run: Interrupted=%v Downloaded=%d, want an interrupted run with 1 download", result.Interrupted, result.Downloaded)
+	}
+
+	// The second run skips what is already there, retrying a rate-limited download
+	server.RateLimit(1)
+	whileWaiting(fake, func() {
+		result, err = newDownloader().Download(ctx, items)
+	})
+	if err != nil {
+		t.Fatalf("second run: %v", err)
+	}
+	if result.Interrupted || result.Existing != 1 || result.Downloaded != 2 || result.Failed != 0 {
+		t.Fatalf("second run: %+v, want 1 existing and 2 downloaded", result)
+	}
+	for _, id := range []string{"a", "b", "c"} {
+		data, err := os.ReadFile(filepath.Join(folder, id+".jpg"))
+		if err != nil || string(data) != "media:"+id {
+			t.Errorf("%s.jpg = %q, %v; want the served content", id, data, err)
+		}
+		if n := server.Downloads(id); n != 1 {
+			t.Errorf("%s was downloaded %d times, want 1", id, n)
+		}
+	}
+
+	// Once the links expire a missing file cannot be fetched until the session is listed again
+	fake.Advance(2 * time.Hour)
+	if err := os.Remove(filepath.Join(folder, "c.jpg")); err != nil {
+		t.Fatal(err)
+	}
+	whileWaiting(fake, func() {
+		result, err = newDownloader().Download(ctx, items)
+	})
+	if err != nil || result.Failed != 1 || result.Existing != 2 {
+		t.Fatalf("run with expired links: %+v, %v; want c to fail and a, b to exist", result, err)
+	}
+	// A page over quota is asked for again
+	server.RateLimit(1)
+	whileWaiting(fake, func() {
+		items, err = client.ListMediaItems(ctx, session.ID)
+		if err != nil {
+			return
+		}
+		result, err = newDownloader().Download(ctx, items)
+	})
+	if err != nil || result.Downloaded != 1 || result.Failed != 0 {
+		t.Fatalf("run with fresh links: %+v, %v; want c downloaded", result, err)
+	}
+	if n := server.Downloads("c"); n != 2 {
+		t.Errorf("c was downloaded %d times, want 2", n)
+	}
+}
+
+// heldOnce holds items over max bytes until Wait is called for them.
+type heldOnce struct {
+	max int64
+
+	mu     sync.Mutex
+	waited map[string]int64
+}
+
+func (g *heldOnce) Hold(item *download.Item, size int64) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, waited := g.waited[item.Id]
+	return size > g.max && !waited
+}
+
+func (g *heldOnce) Wait(ctx context.Context, item *download.Item, size int64) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.waited[item.Id] = size
+	return nil
+}
+
+func TestSizeGate(t *testing.T) {
+	fake := clock.NewFake(time.Date(2024, 5, 1, 9, 0, 0, 0, time.UTC))
+	discard := slog.NewTextHandler(io.Discard, nil)
+	server := pickertest.NewServer(
+		pickertest.WithItems(
+			pickertest.Item{ID: "small", Filename: "small.jpg", Type: picker.MediaTypePhoto, Content: []byte("tiny")},
+			pickertest.Item{ID: "large", Filename: "large.jpg", Type: picker.MediaTypePhoto, Content: []byte("a rather larger photo")},
+		),
+		pickertest.WithClock(fake),
+	)
+	defer server.Close()
+	ctx := context.Background()
+
+	client := picker.NewPickerClient(server.Client(), picker.WithBaseURL(server.BaseURL()), picker.WithClock(fake), picker.WithLogger(discard))
+	var items picker.DownloadableMediaItems
+	var err error
+	whileWaiting(fake, func() {
+		var session picker.PickingSession
+		session, err = client.CreateSession(ctx)
+		if err == nil {
+			items, err = client.WaitForSelection(ctx, session)
+		}
+	})
+	if err != nil {
+		t.Fatalf("picking failed: %v", err)
+	}
+
+	gate := &heldOnce{max: 10, waited: make(map[string]int64)}
+	folder := t.TempDir()
+	downloader := download.NewDownloader(server.Client(), folder, download.WithSizeGate(gate), download.WithLogger(discard))
+	result, err := downloader.Download(ctx, items)
+	if err != nil || result.Downloaded != 2 || result.Failed != 0 {
+		t.Fatalf("Download: %+v, %v; want both items downloaded", result, err)
+	}
+	if len(gate.waited) != 1 || gate.waited["large"] != int64(len("a rather larger photo")) {
+		t.Errorf("waited for %v, want only the large item with its size", gate.waited)
+	}
+	// The held response was dropped and the item fetched again
+	if n := server.Downloads("large"); n != 2 {
+		t.Errorf("large was requested %d times, want 2", n)
+	}
+	if data, err := os.ReadFile(filepath.Join(folder, "large.jpg")); err != nil || string(data) != "a rather larger photo" {
+		t.Errorf("large.jpg = %q, %v", data, err)
+	}
+}
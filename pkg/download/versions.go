@@ -0,0 +1,101 @@
+// versions.go
+//
+// Previous versions of files replaced by a re-download, kept in a hidden folder
+// beside them so that an unwanted edit in Google Photos can be undone.
+package download
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// VersionsDir is the folder inside the target folder holding replaced files.
+const VersionsDir = ".versions"
+
+// versionTimeFormat stamps each kept version; it sorts in time order.
+const versionTimeFormat = "20060102-150405"
+
+// keepVersion moves the file at folder/filename into the versions folder, stamped
+// with now, and removes all but the newest keep versions of it.
+func keepVersion(folder, filename string, now time.Time, keep int) error {
+	dir := filepath.Join(folder, VersionsDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ext := filepath.Ext(filename)
+	stem := strings.TrimSuffix(filename, ext)
+	name := stem + "." + now.Format(versionTimeFormat) + ext
+	if err := os.Rename(filepath.Join(folder, filename), filepath.Join(dir, name)); err != nil {
+		return err
+	}
+
+	versions, err := listVersions(dir, filename)
+	if err != nil {
+		return err
+	}
+	for len(versions) > keep {
+		if err := os.Remove(filepath.Join(dir, versions[0])); err != nil {
+			return err
+		}
+		versions = versions[1:]
+	}
+	return nil
+}
+
+// listVersions returns the kept versions of filename in dir, oldest first.
+func listVersions(dir, filename string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(filename)
+	prefix := strings.TrimSuffix(filename, ext) + "."
+	var versions []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) || len(name) < len(prefix)+len(ext) {
+			continue
+		}
+		stamp := name[len(prefix) : len(name)-len(ext)]
+		if _, err := time.Parse(versionTimeFormat, stamp); err != nil {
+			// Another file whose name merely starts the same way
+			continue
+		}
+		versions = append(versions, name)
+	}
+	slices.Sort(versions)
+	return versions, nil
+}
+
+// fileDigest returns the SHA-256 digest of the file at path.
+func fileDigest(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// sameContents reports whether the files at a and b hold the same bytes.
+func sameContents(a, b string) (bool, error) {
+	da, err := fileDigest(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := fileDigest(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(da, db), nil
+}
@@ -0,0 +1,269 @@
+// colorspace.go
+//
+// Converting photos in wide-gamut colour spaces such as Display P3, which phones
+// tag their photos with, to the sRGB that frames assume. Without conversion the
+// frame shows the P3 values as if they were sRGB and the photo looks flat.
+package enhance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/jpeg"
+	"math"
+	"os"
+)
+
+// ErrNoConversion is returned by ToSRGB for photos that are already sRGB, or whose
+// colour profile it cannot use.
+var ErrNoConversion = errors.New("no colour conversion needed")
+
+// srgbToXYZ is the sRGB to XYZ matrix adapted to the D50 white point that ICC
+// profiles use.
+var srgbToXYZ = matrix{
+	{0.4360747, 0.3850649, 0.1430804},
+	{0.2225045, 0.7168786, 0.0606169},
+	{0.0139322, 0.0971045, 0.7141733},
+}
+
+type matrix [3][3]float64
+
+func (m matrix) mul(n matrix) matrix {
+	var out matrix
+	for i := range out {
+		for j := range out[i] {
+			for k := 0; k < 3; k++ {
+				out[i][j] += m[i][k] * n[k][j]
+			}
+		}
+	}
+	return out
+}
+
+func (m matrix) inverse() matrix {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return matrix{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}
+}
+
+// profile is the part of a matrix/TRC ICC profile needed to convert to sRGB.
+type profile struct {
+	toXYZ matrix
+	// linear maps each channel's 8-bit values to linear light
+	linear [3][256]float64
+}
+
+// parseProfile reads an RGB matrix/TRC ICC profile. Profiles built on lookup
+// tables instead are rare for photos and are not supported.
+func parseProfile(data []byte) (*profile, error) {
+	if len(data) < 132 || string(data[16:20]) != "RGB " {
+		return nil, errors.New("not an RGB profile")
+	}
+	tags := map[string][]byte{}
+	count := int(binary.BigEndian.Uint32(data[128:]))
+	for i := 0; i < count && 132+12*(i+1) <= len(data); i++ {
+		entry := data[132+12*i:]
+		offset, size := int(binary.BigEndian.Uint32(entry[4:])), int(binary.BigEndian.Uint32(entry[8:]))
+		if offset < 0 || size < 0 || offset+size > len(data) {
+			return nil, errors.New("corrupt profile tag")
+		}
+		tags[string(entry[:4])] = data[offset : offset+size]
+	}
+
+	p := &profile{}
+	for c, name := range []string{"rXYZ", "gXYZ", "bXYZ"} {
+		tag := tags[name]
+		if len(tag) < 20 || string(tag[:4]) != "XYZ " {
+			return nil, errors.New("profile has no " + name + " tag")
+		}
+		for row := 0; row < 3; row++ {
+			p.toXYZ[row][c] = s15Fixed16(tag[8+4*row:])
+		}
+	}
+	for c, name := range []string{"rTRC", "gTRC", "bTRC"} {
+		curve, err := parseCurve(tags[name])
+		if err != nil {
+			return nil, err
+		}
+		for v := range p.linear[c] {
+			p.linear[c][v] = curve(float64(v) / 255)
+		}
+	}
+	return p, nil
+}
+
+func s15Fixed16(b []byte) float64 {
+	return float64(int32(binary.BigEndian.Uint32(b))) / 65536
+}
+
+// parseCurve returns the tone response curve of a curv or para tag.
+func parseCurve(tag []byte) (func(float64) float64, error) {
+	if len(tag) < 12 {
+		return nil, errors.New("profile has no tone curve")
+	}
+	switch string(tag[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		switch {
+		case n == 0:
+			return func(v float64) float64 { return v }, nil
+		case n == 1 && len(tag) >= 14:
+			gamma := float64(binary.BigEndian.Uint16(tag[12:])) / 256
+			return func(v float64) float64 { return math.Pow(v, gamma) }, nil
+		case len(tag) >= 12+2*n:
+			table := make([]float64, n)
+			for i := range table {
+				table[i] = float64(binary.BigEndian.Uint16(tag[12+2*i:])) / 65535
+			}
+			return func(v float64) float64 {
+				pos := v * float64(n-1)
+				i := min(int(pos), n-2)
+				return table[i] + (table[i+1]-table[i])*(pos-float64(i))
+			}, nil
+		}
+	case "para":
+		kind := binary.BigEndian.Uint16(tag[8:])
+		params := []int{1, 3, 4, 5, 7}
+		if int(kind) >= len(params) || len(tag) < 12+4*params[kind] {
+			break
+		}
+		var g [7]float64
+		for i := 0; i < params[kind]; i++ {
+			g[i] = s15Fixed16(tag[12+4*i:])
+		}
+		gamma, a, b, c, d, e, f := g[0], g[1], g[2], g[3], g[4], g[5], g[6]
+		return func(v float64) float64 {
+			switch kind {
+			case 0:
+				return math.Pow(v, gamma)
+			case 1:
+				if v >= -b/a {
+					return math.Pow(a*v+b, gamma)
+				}
+				return 0
+			case 2:
+				if v >= -b/a {
+					return math.Pow(a*v+b, gamma) + c
+				}
+				return c
+			case 3:
+				if v >= d {
+					return math.Pow(a*v+b, gamma)
+				}
+				return c * v
+			}
+			if v >= d {
+				return math.Pow(a*v+b, gamma) + e
+			}
+			return c*v + f
+		}, nil
+	}
+	return nil, errors.New("unsupported tone curve")
+}
+
+// srgbEncode maps linear light to an 8-bit sRGB value.
+func srgbEncode(v float64) uint8 {
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(clamp(v*255+0.5, 0, 255))
+}
+
+// isSRGB reports whether a conversion matrix is close enough to the identity that
+// converting would change nothing visible.
+func isSRGB(m matrix) bool {
+	for i := range m {
+		for j := range m[i] {
+			want := 0.0
+			if i == j {
+				want = 1
+			}
+			if math.Abs(m[i][j]-want) > 0.01 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// toSRGB converts img, whose pixel values are in the colour space of p, to sRGB.
+// Colours outside the sRGB gamut are brought in by desaturating them towards
+// their own luminance rather than clipping each channel, which keeps their hue
+// and the detail in saturated highlights.
+func toSRGB(img image.Image, p *profile) *image.NRGBA {
+	convert := srgbToXYZ.inverse().mul(p.toXYZ)
+	out := toNRGBA(img)
+	pix := out.Pix
+	for i := 0; i < len(pix); i += 4 {
+		in := [3]float64{p.linear[0][pix[i]], p.linear[1][pix[i+1]], p.linear[2][pix[i+2]]}
+		var rgb [3]float64
+		for c := range rgb {
+			rgb[c] = convert[c][0]*in[0] + convert[c][1]*in[1] + convert[c][2]*in[2]
+		}
+		luma := clamp(0.2126*rgb[0]+0.7152*rgb[1]+0.0722*rgb[2], 0, 1)
+		// The largest fraction of the way towards the colour that keeps every
+		// channel within range
+		t := 1.0
+		for _, v := range rgb {
+			if v > 1 && v != luma {
+				t = min(t, (1-luma)/(v-luma))
+			} else if v < 0 && v != luma {
+				t = min(t, luma/(luma-v))
+			}
+		}
+		for c, v := range rgb {
+			pix[i+c] = srgbEncode(luma + (v-luma)*t)
+		}
+	}
+	return out
+}
+
+// ToSRGB converts the JPEG at path from the colour space of its embedded ICC
+// profile to sRGB in place, dropping the profile and keeping its EXIF. Photos
+// without a profile, with an sRGB one or in other formats are left alone and
+// ErrNoConversion returned.
+func ToSRGB(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	icc, err := iccProfile(data)
+	if err != nil || icc == nil {
+		return ErrNoConversion
+	}
+	p, err := parseProfile(icc)
+	if err != nil {
+		return ErrNoConversion
+	}
+	if isSRGB(srgbToXYZ.inverse().mul(p.toXYZ)) {
+		return ErrNoConversion
+	}
+	img, err := jpeg.Decode(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, toSRGB(img, p), &jpeg.Options{Quality: 92}); err != nil {
+		return err
+	}
+	segments, err := metadataSegments(data)
+	if err != nil {
+		return err
+	}
+	var exif [][]byte
+	for _, segment := range segments {
+		if segment[1] == 0xE1 {
+			exif = append(exif, segment)
+		}
+	}
+	return writeInPlace(path, insertSegments(buf.Bytes(), exif))
+}
@@ -0,0 +1,185 @@
+// enhance.go
+//
+// Package enhance brightens up photos for the washed-out panels of cheap frames with
+// an automatic white balance, a levels stretch and a little extra saturation. Each
+// correction is deliberately mild so that photos that were fine stay fine.
+package enhance
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"os"
+)
+
+// Options control the strength of each correction. The zero value turns every
+// correction off.
+type Options struct {
+	// WhiteBalance corrects colour casts by assuming the average colour is grey,
+	// limiting each channel's gain to between 1/MaxGain and MaxGain.
+	WhiteBalance bool
+	MaxGain      float64
+	// Levels stretches the range of the photo so that the darkest and brightest
+	// Clip fraction of pixels become black and white.
+	Levels bool
+	Clip   float64
+	// Saturation scales colourfulness; 1 leaves it unchanged.
+	Saturation float64
+}
+
+// Default are the options used for frames: mild corrections throughout.
+var Default = Options{
+	WhiteBalance: true,
+	MaxGain:      1.25,
+	Levels:       true,
+	Clip:         0.005,
+	Saturation:   1.15,
+}
+
+// Image returns an enhanced copy of img.
+func Image(img image.Image, opts Options) *image.NRGBA {
+	out := toNRGBA(img)
+	pix := out.Pix
+
+	// Per-channel lookup tables combine white balance and levels
+	var lut [3][256]uint8
+	var gain [3]float64
+	for c := range gain {
+		gain[c] = 1
+	}
+	if opts.WhiteBalance {
+		var sum [3]float64
+		for i := 0; i < len(pix); i += 4 {
+			sum[0] += float64(pix[i])
+			sum[1] += float64(pix[i+1])
+			sum[2] += float64(pix[i+2])
+		}
+		grey := (sum[0] + sum[1] + sum[2]) / 3
+		for c := range gain {
+			if sum[c] > 0 {
+				gain[c] = clamp(grey/sum[c], 1/opts.MaxGain, opts.MaxGain)
+			}
+		}
+	}
+	low, high := 0.0, 255.0
+	if opts.Levels {
+		low, high = levels(pix, gain, opts.Clip)
+	}
+	for c := range lut {
+		for v := range lut[c] {
+			stretched := (float64(v)*gain[c] - low) * 255 / (high - low)
+			lut[c][v] = uint8(clamp(stretched+0.5, 0, 255))
+		}
+	}
+
+	sat := opts.Saturation
+	if sat == 0 {
+		sat = 1
+	}
+	for i := 0; i < len(pix); i += 4 {
+		r, g, bl := float64(lut[0][pix[i]]), float64(lut[1][pix[i+1]]), float64(lut[2][pix[i+2]])
+		if sat != 1 {
+			luma := 0.299*r + 0.587*g + 0.114*bl
+			r, g, bl = luma+(r-luma)*sat, luma+(g-luma)*sat, luma+(bl-luma)*sat
+		}
+		pix[i] = uint8(clamp(r+0.5, 0, 255))
+		pix[i+1] = uint8(clamp(g+0.5, 0, 255))
+		pix[i+2] = uint8(clamp(bl+0.5, 0, 255))
+	}
+	return out
+}
+
+// toNRGBA returns a copy of img with its origin at (0, 0).
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(out, out.Bounds(), img, b.Min, draw.Src)
+	return out
+}
+
+// levels returns the luminance below which and above which the fraction clip of
+// the white-balanced pixels lie. It never stretches the range by more than half
+// again, so that dark or misty scenes are not forced to full contrast.
+func levels(pix []uint8, gain [3]float64, clip float64) (float64, float64) {
+	var hist [256]int
+	n := 0
+	for i := 0; i < len(pix); i += 4 {
+		luma := 0.299*float64(pix[i])*gain[0] + 0.587*float64(pix[i+1])*gain[1] + 0.114*float64(pix[i+2])*gain[2]
+		hist[int(clamp(luma, 0, 255))]++
+		n++
+	}
+	cut := int(float64(n) * clip)
+	low, high := 0, 255
+	for count := 0; low < 255 && count+hist[low] <= cut; low++ {
+		count += hist[low]
+	}
+	for count := 0; high > 0 && count+hist[high] <= cut; high-- {
+		count += hist[high]
+	}
+	if high-low < 170 {
+		// Widen the window around its middle to limit the stretch to 1.5x
+		mid := float64(low+high) / 2
+		return max(0, mid-85), min(255, mid+85)
+	}
+	return float64(low), float64(high)
+}
+
+func clamp(v, lo, hi float64) float64 {
+	return max(lo, min(v, hi))
+}
+
+// ErrUnsupported is returned by File for formats it cannot decode, such as videos.
+var ErrUnsupported = errors.New("unsupported format")
+
+// File enhances the JPEG or PNG photo at path in place. JPEGs keep their EXIF and
+// colour profile, so orientation and capture date survive. Other files are left
+// alone and ErrUnsupported returned.
+func File(path string, opts Options) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		if err := jpeg.Encode(&buf, Image(img, opts), &jpeg.Options{Quality: 92}); err != nil {
+			return err
+		}
+		out, err := copyMetadata(data, buf.Bytes())
+		if err != nil {
+			return err
+		}
+		return writeInPlace(path, out)
+	case "png":
+		if err := png.Encode(&buf, Image(img, opts)); err != nil {
+			return err
+		}
+		return writeInPlace(path, buf.Bytes())
+	}
+	return ErrUnsupported
+}
+
+// writeInPlace rewrites the file at path, keeping its inode so that open handles
+// to it stay valid.
+func writeInPlace(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_TRUNC, 0)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
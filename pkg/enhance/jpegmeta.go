@@ -0,0 +1,100 @@
+// jpegmeta.go
+//
+// Carrying EXIF and ICC profile segments over to a re-encoded JPEG, which Go's
+// encoder would otherwise drop, taking the photo's orientation with them.
+package enhance
+
+import (
+	"bytes"
+	"errors"
+)
+
+// copyMetadata returns encoded with the EXIF (APP1) and ICC profile (APP2)
+// segments of original inserted after its start-of-image marker.
+func copyMetadata(original, encoded []byte) ([]byte, error) {
+	segments, err := metadataSegments(original)
+	if err != nil || len(segments) == 0 {
+		// Metadata is a nicety; a photo without it is still a photo
+		return encoded, nil
+	}
+	if len(encoded) < 2 || encoded[0] != 0xFF || encoded[1] != 0xD8 {
+		return nil, errors.New("encoded image is not a JPEG")
+	}
+	return insertSegments(encoded, segments), nil
+}
+
+// insertSegments returns the JPEG encoded with segments inserted after its
+// start-of-image marker.
+func insertSegments(encoded []byte, segments [][]byte) []byte {
+	var out bytes.Buffer
+	out.Write(encoded[:2])
+	for _, segment := range segments {
+		out.Write(segment)
+	}
+	out.Write(encoded[2:])
+	return out.Bytes()
+}
+
+// iccProfile returns the ICC profile embedded in a JPEG, reassembled from the
+// APP2 chunks it is split across, or nil if there is none.
+func iccProfile(data []byte) ([]byte, error) {
+	segments, err := metadataSegments(data)
+	if err != nil {
+		return nil, err
+	}
+	var chunks [][]byte
+	for _, segment := range segments {
+		// Marker, length, identifier, then the chunk's sequence number and count
+		payload := segment[4:]
+		if segment[1] != 0xE2 || len(payload) < 14 {
+			continue
+		}
+		seq, count := int(payload[12]), int(payload[13])
+		if chunks == nil {
+			chunks = make([][]byte, count)
+		}
+		if seq < 1 || seq > len(chunks) {
+			return nil, errors.New("corrupt ICC profile chunk")
+		}
+		chunks[seq-1] = payload[14:]
+	}
+	var profile []byte
+	for _, chunk := range chunks {
+		if chunk == nil {
+			return nil, errors.New("ICC profile chunk missing")
+		}
+		profile = append(profile, chunk...)
+	}
+	return profile, nil
+}
+
+// metadataSegments returns the EXIF and ICC profile segments of a JPEG, markers
+// included, in the order they appear.
+func metadataSegments(data []byte) ([][]byte, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errors.New("not a JPEG")
+	}
+	var segments [][]byte
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return nil, errors.New("corrupt JPEG marker")
+		}
+		marker := data[i+1]
+		// Metadata comes before the image data starts
+		if marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		length := int(data[i+2])<<8 | int(data[i+3])
+		end := i + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, errors.New("corrupt JPEG segment")
+		}
+		payload := data[i+4 : end]
+		if (marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00"))) ||
+			(marker == 0xE2 && bytes.HasPrefix(payload, []byte("ICC_PROFILE\x00"))) {
+			segments = append(segments, data[i:end])
+		}
+		i = end
+	}
+	return segments, nil
+}
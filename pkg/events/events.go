@@ -0,0 +1,118 @@
+// events.go
+//
+// Package events publishes typed notifications about sessions and downloads, so
+// notifiers, metrics and frame integrations can consume a single stream.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is implemented by every event type published on a Bus.
+type Event interface {
+	// Name returns a stable identifier for the event type, e.g. "item_downloaded".
+	Name() string
+}
+
+// SessionCreated is published when a Picker session has been created.
+type SessionCreated struct {
+	SessionID string
+	PickerURI string
+}
+
+// SelectionComplete is published when the user has finished picking and the
+// selection has been listed.
+type SelectionComplete struct {
+	SessionID string
+	ItemCount int
+}
+
+// ItemDownloaded is published when an item has been saved into the target folder.
+type ItemDownloaded struct {
+	ItemID   string
+	Filename string
+	Path     string
+	Bytes    int64
+}
+
+// ItemFailed is published when an item could not be downloaded.
+type ItemFailed struct {
+	ItemID   string
+	Filename string
+	Err      error
+}
+
+// SyncFinished is published when a download run has processed every item.
+type SyncFinished struct {
+	Folder     string
+	Downloaded int
+	Existing   int
+	Filtered   int
+	Failed     int
+	Duration   time.Duration
+}
+
+func (SessionCreated) Name() string    { return "session_created" }
+func (SelectionComplete) Name() string { return "selection_complete" }
+func (ItemDownloaded) Name() string    { return "item_downloaded" }
+func (ItemFailed) Name() string        { return "item_failed" }
+func (SyncFinished) Name() string      { return "sync_finished" }
+
+// Handler receives events. Handlers run synchronously on the publishing goroutine,
+// so they should return quickly and hand slow work off elsewhere.
+type Handler func(Event)
+
+// Bus delivers published events to every subscriber in subscription order. A nil
+// *Bus discards everything published on it.
+type Bus struct {
+	mu       sync.RWMutex
+	handlers map[int]Handler
+	order    []int
+	nextID   int
+}
+
+// NewBus returns an empty Bus.
+func NewBus() *Bus {
+	return &Bus{handlers: make(map[int]Handler)}
+}
+
+// Subscribe registers handler for all future events and returns a function that
+// unsubscribes it.
+func (b *Bus) Subscribe(handler Handler) (unsubscribe func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	id := b.nextID
+	b.nextID++
+	b.handlers[id] = handler
+	b.order = append(b.order, id)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.handlers, id)
+		for i, existing := range b.order {
+			if existing == id {
+				b.order = append(b.order[:i:i], b.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Publish delivers event to every current subscriber.
+func (b *Bus) Publish(event Event) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	handlers := make([]Handler, 0, len(b.order))
+	for _, id := range b.order {
+		handlers = append(handlers, b.handlers[id])
+	}
+	b.mu.RUnlock()
+
+	for _, handler := range handlers {
+		handler(event)
+	}
+}
@@ -0,0 +1,212 @@
+// frameindex.go
+//
+// Package frameindex writes the index and marker files that some frames and
+// gallery apps expect beside the photos, such as .nomedia markers, playlists and
+// JSON listings. Each format is a Writer, looked up by name, so a frame folder can
+// be given whichever its model needs.
+package frameindex
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// File describes a file shown by the frame.
+type File struct {
+	// Name is the file's path relative to the frame folder.
+	Name    string
+	Created time.Time
+	Width   int
+	Height  int
+	Video   bool
+}
+
+// Writer writes one kind of index into a frame folder holding files.
+type Writer interface {
+	Write(folder string, files []File) error
+}
+
+// WriterFunc adapts an ordinary function to the Writer interface.
+type WriterFunc func(folder string, files []File) error
+
+// Write calls f(folder, files).
+func (f WriterFunc) Write(folder string, files []File) error {
+	return f(folder, files)
+}
+
+// writers are the index formats, by name.
+var writers = map[string]Writer{
+	"nomedia":  WriterFunc(writeNoMedia),
+	"playlist": WriterFunc(writePlaylist),
+	"json":     WriterFunc(writeJSON),
+	"m3u":      WriterFunc(writeM3U),
+}
+
+// Lookup returns the writer of the named format.
+func Lookup(name string) (Writer, error) {
+	w, ok := writers[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unknown index %q: expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	return w, nil
+}
+
+// Names lists the index formats, sorted.
+func Names() []string {
+	var names []string
+	for name := range writers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// byCreated returns files sorted oldest first, so playlists play in the order the
+// photos were taken. Files without a capture time go last, by name.
+func byCreated(files []File) []File {
+	sorted := slices.Clone(files)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.Created.IsZero() != b.Created.IsZero() {
+			return b.Created.IsZero()
+		}
+		if !a.Created.Equal(b.Created) {
+			return a.Created.Before(b.Created)
+		}
+		return a.Name < b.Name
+	})
+	return sorted
+}
+
+// noMediaName is the marker that tells Android's media scanner, and the gallery
+// apps of Android-based frames, to skip a folder.
+const noMediaName = ".nomedia"
+
+// writeNoMedia marks every subfolder of folder, such as the archive and kept
+// versions, so only the files at the top are shown.
+func writeNoMedia(folder string, files []File) error {
+	entries, err := os.ReadDir(folder)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		// Marked folders are skipped whole, so nothing further down needs marking
+		marker := filepath.Join(folder, entry.Name(), noMediaName)
+		if _, err := os.Stat(marker); !errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err := os.WriteFile(marker, nil, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// playlistName is the file writePlaylist writes.
+const playlistName = "playlist.xml"
+
+// playlist is the layout of playlist.xml.
+type playlist struct {
+	XMLName xml.Name        `xml:"playlist"`
+	Items   []playlistEntry `xml:"item"`
+}
+
+type playlistEntry struct {
+	File    string `xml:"file"`
+	Type    string `xml:"type"`
+	Created string `xml:"date,omitempty"`
+}
+
+// writePlaylist writes playlist.xml, listing the files in the order taken.
+func writePlaylist(folder string, files []File) error {
+	var p playlist
+	for _, f := range byCreated(files) {
+		entry := playlistEntry{File: filepath.ToSlash(f.Name), Type: mediaType(f)}
+		if !f.Created.IsZero() {
+			entry.Created = f.Created.Format(time.RFC3339)
+		}
+		p.Items = append(p.Items, entry)
+	}
+	data, err := xml.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(folder, playlistName), append([]byte(xml.Header), append(data, '\n')...))
+}
+
+// jsonName is the file writeJSON writes.
+const jsonName = "index.json"
+
+// jsonEntry is one file in index.json.
+type jsonEntry struct {
+	File    string     `json:"file"`
+	Type    string     `json:"type"`
+	Created *time.Time `json:"created,omitempty"`
+	Width   int        `json:"width,omitempty"`
+	Height  int        `json:"height,omitempty"`
+}
+
+// writeJSON writes index.json, listing the files in the order taken with their
+// dimensions.
+func writeJSON(folder string, files []File) error {
+	index := struct {
+		Generated time.Time   `json:"generated"`
+		Items     []jsonEntry `json:"items"`
+	}{Generated: time.Now(), Items: []jsonEntry{}}
+	for _, f := range byCreated(files) {
+		entry := jsonEntry{File: filepath.ToSlash(f.Name), Type: mediaType(f), Width: f.Width, Height: f.Height}
+		if !f.Created.IsZero() {
+			entry.Created = &f.Created
+		}
+		index.Items = append(index.Items, entry)
+	}
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFile(filepath.Join(folder, jsonName), append(data, '\n'))
+}
+
+// m3uName is the file writeM3U writes.
+const m3uName = "playlist.m3u"
+
+// writeM3U writes an M3U playlist of the files in the order taken, for frames
+// and media players that play playlists rather than folders.
+func writeM3U(folder string, files []File) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for _, f := range byCreated(files) {
+		b.WriteString(filepath.ToSlash(f.Name) + "\n")
+	}
+	return writeFile(filepath.Join(folder, m3uName), []byte(b.String()))
+}
+
+// mediaType names the kind of file for the listings.
+func mediaType(f File) string {
+	if f.Video {
+		return "video"
+	}
+	return "photo"
+}
+
+// writeFile replaces path with data in a single rename, so frames never read a
+// half-written index.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".part"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
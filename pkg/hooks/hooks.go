@@ -0,0 +1,160 @@
+// hooks.go
+//
+// Package hooks runs user supplied actions before and after each sync and each item
+// download, such as converting files or telling the frame to refresh.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+// Stage identifies when a hook runs.
+type Stage string
+
+const (
+	BeforeSync Stage = "before-sync"
+	AfterSync  Stage = "after-sync"
+	BeforeItem Stage = "before-item"
+	AfterItem  Stage = "after-item"
+)
+
+// ItemInfo describes the item a per-item hook runs for.
+type ItemInfo struct {
+	ID         string `json:"id"`
+	Type       string `json:"type"`
+	CreateTime string `json:"createTime"`
+	Filename   string `json:"filename"`
+	// Path is where the item is (or will be) saved.
+	Path string `json:"path"`
+	// Downloaded is set after the item was fetched rather than found on disk.
+	Downloaded bool `json:"downloaded,omitempty"`
+	// Error is set when the download failed.
+	Error string `json:"error,omitempty"`
+}
+
+// Summary describes the outcome of a sync for after-sync hooks.
+type Summary struct {
+	Downloaded int `json:"downloaded"`
+	Existing   int `json:"existing"`
+	Filtered   int `json:"filtered"`
+	Failed     int `json:"failed"`
+}
+
+// Payload is the structured context handed to every hook.
+type Payload struct {
+	Stage   Stage     `json:"stage"`
+	Folder  string    `json:"folder"`
+	Item    *ItemInfo `json:"item,omitempty"`
+	Summary *Summary  `json:"summary,omitempty"`
+}
+
+// Hook is an action run at one or more stages.
+type Hook interface {
+	Run(ctx context.Context, payload Payload) error
+}
+
+// Func adapts an ordinary function to the Hook interface, for hooks registered from Go.
+type Func func(ctx context.Context, payload Payload) error
+
+// Run calls f(ctx, payload).
+func (f Func) Run(ctx context.Context, payload Payload) error {
+	return f(ctx, payload)
+}
+
+// Command is a hook that runs a shell command. The payload is written to the
+// command's stdin as JSON and the main fields are also exported as PHOTOSYNC_HOOK_*
+// environment variables.
+type Command struct {
+	Command string
+	// Timeout bounds the command's run time; zero means one minute.
+	Timeout time.Duration
+}
+
+// Run runs the command, failing if it exits non-zero.
+func (c Command) Run(ctx context.Context, payload Payload) error {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", c.Command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", c.Command)
+	}
+
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), payloadEnv(payload)...)
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook %q failed: %v", payload.Stage, c.Command, err)
+	}
+	return nil
+}
+
+func payloadEnv(payload Payload) []string {
+	env := []string{
+		"PHOTOSYNC_HOOK_STAGE=" + string(payload.Stage),
+		"PHOTOSYNC_HOOK_FOLDER=" + payload.Folder,
+	}
+	if item := payload.Item; item != nil {
+		env = append(env,
+			"PHOTOSYNC_HOOK_ITEM_ID="+item.ID,
+			"PHOTOSYNC_HOOK_ITEM_TYPE="+item.Type,
+			"PHOTOSYNC_HOOK_ITEM_FILENAME="+item.Filename,
+			"PHOTOSYNC_HOOK_ITEM_PATH="+item.Path,
+			"PHOTOSYNC_HOOK_ITEM_ERROR="+item.Error,
+		)
+	}
+	if summary := payload.Summary; summary != nil {
+		env = append(env,
+			"PHOTOSYNC_HOOK_DOWNLOADED="+strconv.Itoa(summary.Downloaded),
+			"PHOTOSYNC_HOOK_FAILED="+strconv.Itoa(summary.Failed),
+		)
+	}
+	return env
+}
+
+// Registry holds the hooks registered for each stage. The zero value is ready to use
+// and a nil *Registry runs nothing.
+type Registry struct {
+	hooks map[Stage][]Hook
+}
+
+// Register adds hook to run at stage, after any hooks already registered there.
+func (r *Registry) Register(stage Stage, hook Hook) {
+	if r.hooks == nil {
+		r.hooks = make(map[Stage][]Hook)
+	}
+	r.hooks[stage] = append(r.hooks[stage], hook)
+}
+
+// Fire runs the hooks for payload.Stage in order, stopping at the first error.
+func (r *Registry) Fire(ctx context.Context, payload Payload) error {
+	if r == nil {
+		return nil
+	}
+	for _, hook := range r.hooks[payload.Stage] {
+		if err := hook.Run(ctx, payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
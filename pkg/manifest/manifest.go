@@ -0,0 +1,208 @@
+// manifest.go
+//
+// Package manifest records which media items the last sync put in a folder, so later
+// runs can tell what changed in the selection.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"PhotoSync/pkg/picker"
+)
+
+// FileName is the name of the manifest file inside a synced folder.
+const FileName = ".photosync-manifest.json"
+
+// Entry describes one selected item.
+type Entry struct {
+	ID string `json:"id"`
+	// Filename is the name of the item's file in the folder, or empty if the item
+	// was filtered out or failed to download. OriginalFilename is the name Google
+	// Photos gave it, before any renaming.
+	Filename         string `json:"filename,omitempty"`
+	OriginalFilename string `json:"originalFilename"`
+	CreateTime       string `json:"createTime,omitempty"`
+	// Fingerprint summarises what Google Photos reports about the item, so a
+	// change to the item can be spotted without downloading it.
+	Fingerprint string `json:"fingerprint,omitempty"`
+	// Size and SHA256 record the file as it was saved, for verify to check it
+	// against. Both are empty if the file was never fetched with a checksum.
+	Size   int64  `json:"size,omitempty"`
+	SHA256 string `json:"sha256,omitempty"`
+	// Variant is the suffix appended to the item's baseUrl to fetch the file, e.g.
+	// =d for the original or =w1920-h1080 for a scaled copy.
+	Variant string `json:"variant,omitempty"`
+	// Archived is set once the archive command has moved the file out of the
+	// frame's view; Filename then includes the archive folder.
+	Archived bool `json:"archived,omitempty"`
+	// Source says where a file made locally came from, and is empty for items
+	// picked from Google Photos.
+	Source string `json:"source,omitempty"`
+	// Numbered is set if Filename carries a position prefix from -order, which
+	// a later sync may change.
+	Numbered bool `json:"numbered,omitempty"`
+}
+
+// Sources of files that are never part of a Google Photos selection.
+const (
+	// SourceDrop marks files merged in from a local drop folder.
+	SourceDrop = "drop"
+	// SourceCollage marks collages composed from low-resolution photos.
+	SourceCollage = "collage"
+)
+
+// Local reports whether the entry is for a file made locally rather than picked
+// from Google Photos.
+func (e Entry) Local() bool {
+	return e.Source != ""
+}
+
+// DownloadURL returns the URL that fetches the entry's file from baseURL, a fresh
+// baseUrl of the same item. Entries recorded before variants were default to the
+// original.
+func (e Entry) DownloadURL(baseURL string) string {
+	if e.Variant == "" {
+		return baseURL + "=d"
+	}
+	return baseURL + e.Variant
+}
+
+// Manifest lists the items selected at the last sync into a folder.
+type Manifest struct {
+	Updated time.Time `json:"updated"`
+	Items   []Entry   `json:"items"`
+}
+
+// Load reads the manifest in folder. A folder that has never been synced has an
+// empty manifest.
+func Load(folder string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(folder, FileName))
+	if os.IsNotExist(err) {
+		return &Manifest{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	return &m, nil
+}
+
+// Save writes the manifest into folder, replacing the previous one in a single
+// rename so that an interrupted write never leaves a truncated manifest behind.
+func (m *Manifest) Save(folder string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(folder, FileName+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(folder, FileName))
+}
+
+// ArchivedIDs returns the IDs of the items whose files have been archived.
+func (m *Manifest) ArchivedIDs() map[string]bool {
+	ids := make(map[string]bool)
+	for _, entry := range m.Items {
+		if entry.Archived {
+			ids[entry.ID] = true
+		}
+	}
+	return ids
+}
+
+// Change is an item present in both the manifest and the new selection whose
+// details differ.
+type Change struct {
+	Old Entry
+	New Entry
+}
+
+// Diff describes how a new selection differs from a manifest.
+type Diff struct {
+	Added   []Entry
+	Removed []Entry
+	Changed []Change
+}
+
+// Empty reports whether the selection is unchanged.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0
+}
+
+// EntryFor describes a picked item as Google Photos reports it, leaving Filename
+// for the caller to fill in once the item is on disk.
+func EntryFor(item picker.PickedMediaItem) Entry {
+	return Entry{
+		ID:               item.Id,
+		OriginalFilename: item.MediaFile.Filename,
+		CreateTime:       item.CreateTime,
+		Fingerprint:      Fingerprint(item),
+	}
+}
+
+// Fingerprint returns a short hash of the item's metadata: its filename, type,
+// capture time, dimensions and camera. Edits in Google Photos that crop or
+// rotate an item change it; edits that keep the same dimensions do not.
+func Fingerprint(item picker.PickedMediaItem) string {
+	meta := item.MediaFile.MediaFileMetadata
+	fields := []string{
+		item.Id, item.MediaFile.Filename, item.MediaFile.MimeType, item.CreateTime,
+		strconv.Itoa(meta.Width), strconv.Itoa(meta.Height), meta.CameraMake, meta.CameraModel,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Compare reports the items of selection that are not in the manifest, the
+// manifest's items that are no longer selected, and the items whose original
+// filename, capture time or fingerprint has changed. Items are matched by ID.
+// Local files are not part of the selection and are left out.
+func (m *Manifest) Compare(selection []picker.PickedMediaItem) Diff {
+	previous := make(map[string]Entry, len(m.Items))
+	for _, entry := range m.Items {
+		previous[entry.ID] = entry
+	}
+
+	var diff Diff
+	seen := make(map[string]bool, len(selection))
+	for _, item := range selection {
+		entry := EntryFor(item)
+		seen[entry.ID] = true
+		old, ok := previous[entry.ID]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, entry)
+		case old.OriginalFilename != entry.OriginalFilename || old.CreateTime != entry.CreateTime,
+			old.Fingerprint != "" && old.Fingerprint != entry.Fingerprint:
+			diff.Changed = append(diff.Changed, Change{Old: old, New: entry})
+		}
+	}
+	for _, entry := range m.Items {
+		if !seen[entry.ID] && !entry.Local() {
+			diff.Removed = append(diff.Removed, entry)
+		}
+	}
+	return diff
+}
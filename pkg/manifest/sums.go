@@ -0,0 +1,82 @@
+// sums.go
+//
+// A SHA256SUMS file beside the photos, in the format of sha256sum, so the folder
+// can be checked with standard tools, or by verify on a machine without the
+// manifest, such as whatever the frame's SD card is plugged into.
+package manifest
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// SumsFileName is the name of the checksums file inside a synced folder.
+const SumsFileName = "SHA256SUMS"
+
+// WriteSums writes the checksums of the files recorded in the manifest to the
+// SHA256SUMS file in folder. Files without a recorded checksum are left out.
+func (m *Manifest) WriteSums(folder string) error {
+	var entries []Entry
+	for _, entry := range m.Items {
+		if entry.Filename != "" && entry.SHA256 != "" {
+			entries = append(entries, entry)
+		}
+	}
+	slices.SortFunc(entries, func(a, b Entry) int {
+		return strings.Compare(a.Filename, b.Filename)
+	})
+	var sums strings.Builder
+	for _, entry := range entries {
+		fmt.Fprintf(&sums, "%s  %s\n", entry.SHA256, filepath.ToSlash(entry.Filename))
+	}
+
+	tmp, err := os.CreateTemp(folder, SumsFileName+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.WriteString(sums.String()); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), filepath.Join(folder, SumsFileName))
+}
+
+// LoadSums reads the SHA256SUMS file in folder into a manifest that Verify can
+// check the folder against. Its entries have only a filename and checksum.
+func LoadSums(folder string) (*Manifest, error) {
+	f, err := os.Open(filepath.Join(folder, SumsFileName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := &Manifest{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		// "<checksum>  <name>", or "<checksum> *<name>" for binary mode
+		digest, name, ok := strings.Cut(text, " ")
+		if !ok || len(digest) != 64 || len(name) < 2 {
+			return nil, fmt.Errorf("%s line %d: not in sha256sum format", SumsFileName, line)
+		}
+		name = name[1:]
+		m.Items = append(m.Items, Entry{
+			Filename:         filepath.FromSlash(name),
+			OriginalFilename: name,
+			SHA256:           strings.ToLower(digest),
+		})
+	}
+	return m, scanner.Err()
+}
@@ -0,0 +1,107 @@
+// verify.go
+//
+// Integrity checks of a synced folder against its manifest, to catch the missing,
+// truncated and corrupted files a failing SD card leaves behind.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// ProblemKind classifies a file that failed verification.
+type ProblemKind string
+
+const (
+	// Missing files are in the manifest but not in the folder.
+	Missing ProblemKind = "missing"
+	// Truncated files are shorter than when they were saved.
+	Truncated ProblemKind = "truncated"
+	// Corrupted files have the recorded size but different contents, or a
+	// different size that is not explained by truncation.
+	Corrupted ProblemKind = "corrupted"
+)
+
+// Problem is a manifest entry whose file failed verification.
+type Problem struct {
+	Entry  Entry
+	Kind   ProblemKind
+	Detail string
+}
+
+// Report is the outcome of Verify.
+type Report struct {
+	// Checked counts the files whose size and checksum matched.
+	Checked int
+	// Unverifiable counts the files present on disk with no checksum recorded.
+	Unverifiable int
+	Problems     []Problem
+}
+
+// Verify checks every file the manifest says is in folder, comparing its size and
+// SHA-256 with those recorded when it was saved. Entries without a checksum can
+// only be checked for presence.
+func (m *Manifest) Verify(folder string) (Report, error) {
+	var report Report
+	for _, entry := range m.Items {
+		if entry.Filename == "" {
+			continue
+		}
+		path := filepath.Join(folder, entry.Filename)
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			report.Problems = append(report.Problems, Problem{Entry: entry, Kind: Missing, Detail: "file not found"})
+			continue
+		}
+		if err != nil {
+			return report, err
+		}
+		if entry.SHA256 == "" {
+			report.Unverifiable++
+			continue
+		}
+		// Checksums read from SHA256SUMS come without a size
+		if entry.Size > 0 && info.Size() != entry.Size {
+			kind := Corrupted
+			if info.Size() < entry.Size {
+				kind = Truncated
+			}
+			report.Problems = append(report.Problems, Problem{
+				Entry:  entry,
+				Kind:   kind,
+				Detail: fmt.Sprintf("%d bytes, expected %d", info.Size(), entry.Size),
+			})
+			continue
+		}
+		digest, err := FileDigest(path)
+		if err != nil {
+			// An unreadable file is as good as a corrupted one
+			report.Problems = append(report.Problems, Problem{Entry: entry, Kind: Corrupted, Detail: err.Error()})
+			continue
+		}
+		if digest != entry.SHA256 {
+			report.Problems = append(report.Problems, Problem{Entry: entry, Kind: Corrupted, Detail: "checksum mismatch"})
+			continue
+		}
+		report.Checked++
+	}
+	return report, nil
+}
+
+// FileDigest returns the hex SHA-256 of the file at path.
+func FileDigest(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
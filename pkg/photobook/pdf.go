@@ -0,0 +1,137 @@
+// pdf.go
+//
+// Just enough of PDF to lay out JPEG photos and captions: JPEGs are embedded as
+// they are, and captions use Helvetica, which every PDF reader has built in.
+package photobook
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// pdfWriter writes numbered objects to a PDF file, remembering where each starts
+// for the cross-reference table.
+type pdfWriter struct {
+	w       *bufio.Writer
+	written int64
+	offsets map[int]int64
+	next    int
+	err     error
+}
+
+func newPDFWriter(w io.Writer) *pdfWriter {
+	p := &pdfWriter{w: bufio.NewWriter(w), offsets: make(map[int]int64), next: 1}
+	// The binary comment tells tools the file is not plain text
+	p.printf("%%PDF-1.4\n%%\xe2\xe3\xcf\xd3\n")
+	return p
+}
+
+func (p *pdfWriter) printf(format string, args ...any) {
+	if p.err != nil {
+		return
+	}
+	n, err := fmt.Fprintf(p.w, format, args...)
+	p.written += int64(n)
+	p.err = err
+}
+
+func (p *pdfWriter) write(data []byte) {
+	if p.err != nil {
+		return
+	}
+	n, err := p.w.Write(data)
+	p.written += int64(n)
+	p.err = err
+}
+
+// reserve returns the number of an object to be written later.
+func (p *pdfWriter) reserve() int {
+	p.next++
+	return p.next - 1
+}
+
+// object writes object n with the given dictionary or value.
+func (p *pdfWriter) object(n int, body string) {
+	p.offsets[n] = p.written
+	p.printf("%d 0 obj\n%s\nendobj\n", n, body)
+}
+
+// stream writes object n as a stream with the given dictionary entries.
+func (p *pdfWriter) stream(n int, dict string, data []byte) {
+	p.offsets[n] = p.written
+	p.printf("%d 0 obj\n<< %s /Length %d >>\nstream\n", n, dict, len(data))
+	p.write(data)
+	p.printf("\nendstream\nendobj\n")
+}
+
+// finish writes the cross-reference table and trailer, with root as the document
+// catalog.
+func (p *pdfWriter) finish(root int) error {
+	xref := p.written
+	p.printf("xref\n0 %d\n0000000000 65535 f \n", p.next)
+	for n := 1; n < p.next; n++ {
+		p.printf("%010d 00000 n \n", p.offsets[n])
+	}
+	p.printf("trailer\n<< /Size %d /Root %d 0 R >>\nstartxref\n%d\n%%%%EOF\n", p.next, root, xref)
+	if p.err != nil {
+		return p.err
+	}
+	return p.w.Flush()
+}
+
+// winAnsiExtras are the characters WinAnsiEncoding has beyond Latin-1 that
+// captions are likely to use, such as typographic quotes and dashes.
+var winAnsiExtras = map[rune]byte{
+	'€': 0x80, '…': 0x85, '‘': 0x91, '’': 0x92, '“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97,
+}
+
+// pdfText encodes s as a PDF string in WinAnsiEncoding. Characters it has no
+// glyph for become question marks.
+func pdfText(s string) string {
+	var b strings.Builder
+	b.WriteByte('(')
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r >= 32 && r < 127:
+			b.WriteRune(r)
+		case r >= 0xa0 && r <= 0xff:
+			fmt.Fprintf(&b, "\\%03o", r)
+		case winAnsiExtras[r] != 0:
+			fmt.Fprintf(&b, "\\%03o", winAnsiExtras[r])
+		default:
+			b.WriteByte('?')
+		}
+	}
+	b.WriteByte(')')
+	return b.String()
+}
+
+// helveticaWidths are the widths of the printable ASCII characters in Helvetica,
+// in thousandths of the font size, from its standard metrics.
+var helveticaWidths = [95]int{
+	278, 278, 355, 556, 556, 889, 667, 191, 333, 333, 389, 584, 278, 333, 278, 278,
+	556, 556, 556, 556, 556, 556, 556, 556, 556, 556, 278, 278, 584, 584, 584, 556,
+	1015, 667, 667, 722, 722, 667, 611, 778, 722, 278, 500, 667, 556, 833, 722, 778,
+	667, 778, 722, 667, 611, 722, 667, 944, 667, 667, 611, 278, 278, 278, 469, 556,
+	333, 556, 556, 500, 556, 556, 278, 556, 556, 222, 222, 500, 222, 833, 556, 556,
+	556, 556, 333, 500, 278, 556, 500, 722, 500, 500, 500, 334, 260, 334, 584,
+}
+
+// textWidth returns the width of s set in Helvetica at size points. Characters
+// outside ASCII are taken to be as wide as a digit.
+func textWidth(s string, size float64) float64 {
+	total := 0
+	for _, r := range s {
+		if r >= 32 && r < 127 {
+			total += helveticaWidths[r-32]
+		} else {
+			total += 556
+		}
+	}
+	return float64(total) * size / 1000
+}
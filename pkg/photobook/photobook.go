@@ -0,0 +1,241 @@
+// photobook.go
+//
+// Package photobook lays photos out into a printable PDF, a few to a page with
+// captions, so a frame's rotation can be printed and kept.
+package photobook
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"PhotoSync/pkg/variant"
+)
+
+// mm is the number of PDF points in a millimetre.
+const mm = 72 / 25.4
+
+// PageSize is a page's width and height in points.
+type PageSize struct {
+	Width, Height float64
+}
+
+// pageSizes are the paper sizes ParsePageSize knows by name, in portrait.
+var pageSizes = map[string]PageSize{
+	"a3":     {297 * mm, 420 * mm},
+	"a4":     {210 * mm, 297 * mm},
+	"a5":     {148 * mm, 210 * mm},
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+}
+
+// ParsePageSize parses a paper size name, such as a4 or letter, or a size in
+// millimetres such as 200x200.
+func ParsePageSize(s string) (PageSize, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if size, ok := pageSizes[s]; ok {
+		return size, nil
+	}
+	w, h, ok := strings.Cut(strings.TrimSuffix(s, "mm"), "x")
+	if ok {
+		width, werr := strconv.ParseFloat(w, 64)
+		height, herr := strconv.ParseFloat(h, 64)
+		if werr == nil && herr == nil && width > 0 && height > 0 {
+			return PageSize{width * mm, height * mm}, nil
+		}
+	}
+	return PageSize{}, fmt.Errorf("unknown page size %q: expected a3, a4, a5, letter, legal or WIDTHxHEIGHT in mm", s)
+}
+
+// Landscape returns the page turned on its side, if it is not already.
+func (p PageSize) Landscape() PageSize {
+	if p.Width < p.Height {
+		return PageSize{p.Height, p.Width}
+	}
+	return p
+}
+
+// Options controls the layout.
+type Options struct {
+	Page PageSize
+	// PerPage is the number of photos on each page.
+	PerPage int
+	// Margin is the space around the edge of each page and Gap that between
+	// photos, in points.
+	Margin, Gap float64
+	// Title, if set, is printed on a page of its own at the front.
+	Title string
+	// DPI is the resolution photos are scaled down to for print.
+	DPI int
+}
+
+// Default is a 2-up A4 book with 12mm margins, printed at 200 dpi.
+var Default = Options{
+	Page:    pageSizes["a4"],
+	PerPage: 2,
+	Margin:  12 * mm,
+	Gap:     6 * mm,
+	DPI:     200,
+}
+
+// Photo is a photo to include and the caption to print under it, if any.
+type Photo struct {
+	Path    string
+	Caption string
+}
+
+// Caption text size and the room left for it under each photo, in points.
+const (
+	captionSize   = 9
+	captionHeight = 16
+)
+
+// ErrNoPhotos is returned by Write when none of the photos could be read, such
+// as when they are all videos.
+var ErrNoPhotos = errors.New("no photos to lay out")
+
+// Write lays photos out in order and writes the PDF to w. Files that are not
+// photos, such as videos, are left out, and their paths are returned.
+func Write(w io.Writer, photos []Photo, opts Options) ([]string, error) {
+	if opts.PerPage < 1 {
+		return nil, fmt.Errorf("invalid photos per page %d", opts.PerPage)
+	}
+	tmp, err := os.MkdirTemp("", "photobook")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	captions := false
+	for _, photo := range photos {
+		captions = captions || photo.Caption != ""
+	}
+	cols, rows := grid(opts)
+	cellW := (opts.Page.Width - 2*opts.Margin - float64(cols-1)*opts.Gap) / float64(cols)
+	cellH := (opts.Page.Height - 2*opts.Margin - float64(rows-1)*opts.Gap) / float64(rows)
+	imageH := cellH
+	if captions {
+		imageH -= captionHeight
+	}
+	if cellW <= 0 || imageH <= 0 {
+		return nil, fmt.Errorf("%d photos do not fit on the page", opts.PerPage)
+	}
+	// Photos are scaled to print at opts.DPI, which keeps the PDF a sensible size
+	spec := variant.Spec{
+		Width:  int(math.Ceil(cellW / 72 * float64(opts.DPI))),
+		Height: int(math.Ceil(imageH / 72 * float64(opts.DPI))),
+		Format: variant.JPEG,
+	}
+
+	pdf := newPDFWriter(w)
+	catalog, pages, font := pdf.reserve(), pdf.reserve(), pdf.reserve()
+	pdf.object(catalog, fmt.Sprintf("<< /Type /Catalog /Pages %d 0 R >>", pages))
+	pdf.object(font, "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>")
+
+	var kids []string
+	addPage := func(content string, images []int) {
+		var xobjects strings.Builder
+		for _, n := range images {
+			fmt.Fprintf(&xobjects, " /Im%d %d 0 R", n, n)
+		}
+		contents, page := pdf.reserve(), pdf.reserve()
+		pdf.stream(contents, "", []byte(content))
+		pdf.object(page, fmt.Sprintf("<< /Type /Page /Parent %d 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 %d 0 R >> /XObject <<%s >> >> /Contents %d 0 R >>",
+			pages, opts.Page.Width, opts.Page.Height, font, xobjects.String(), contents))
+		kids = append(kids, fmt.Sprintf("%d 0 R", page))
+	}
+
+	if opts.Title != "" {
+		size := 28.0
+		x := (opts.Page.Width - textWidth(opts.Title, size)) / 2
+		addPage(fmt.Sprintf("BT /F1 %.0f Tf %.2f %.2f Td %s Tj ET\n", size, x, opts.Page.Height*0.55, pdfText(opts.Title)), nil)
+	}
+
+	var skipped []string
+	var content strings.Builder
+	var images []int
+	slot := 0
+	for i, photo := range photos {
+		scaled := filepath.Join(tmp, fmt.Sprintf("%d.jpg", i))
+		err := variant.Make(photo.Path, scaled, spec)
+		if errors.Is(err, variant.ErrUnsupported) {
+			skipped = append(skipped, photo.Path)
+			continue
+		}
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %v", filepath.Base(photo.Path), err)
+		}
+		data, err := os.ReadFile(scaled)
+		os.Remove(scaled)
+		if err != nil {
+			return skipped, err
+		}
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return skipped, fmt.Errorf("%s: %v", filepath.Base(photo.Path), err)
+		}
+		colorSpace := "/DeviceRGB"
+		if config.ColorModel == color.GrayModel {
+			colorSpace = "/DeviceGray"
+		}
+		n := pdf.reserve()
+		pdf.stream(n, fmt.Sprintf("/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace %s /BitsPerComponent 8 /Filter /DCTDecode",
+			config.Width, config.Height, colorSpace), data)
+		images = append(images, n)
+
+		// Fit the photo in its cell, centred, with the caption centred below
+		col, row := slot%cols, slot/cols
+		cellX := opts.Margin + float64(col)*(cellW+opts.Gap)
+		cellTop := opts.Page.Height - opts.Margin - float64(row)*(cellH+opts.Gap)
+		scale := min(cellW/float64(config.Width), imageH/float64(config.Height))
+		w, h := float64(config.Width)*scale, float64(config.Height)*scale
+		x, y := cellX+(cellW-w)/2, cellTop-imageH+(imageH-h)/2
+		fmt.Fprintf(&content, "q %.2f 0 0 %.2f %.2f %.2f cm /Im%d Do Q\n", w, h, x, y, n)
+		if photo.Caption != "" {
+			tx := cellX + (cellW-textWidth(photo.Caption, captionSize))/2
+			ty := y - captionHeight + 5
+			fmt.Fprintf(&content, "BT /F1 %d Tf 0.3 g %.2f %.2f Td %s Tj ET\n", captionSize, tx, ty, pdfText(photo.Caption))
+		}
+
+		slot++
+		if slot == opts.PerPage {
+			addPage(content.String(), images)
+			content.Reset()
+			images = nil
+			slot = 0
+		}
+	}
+	if slot > 0 {
+		addPage(content.String(), images)
+	}
+	if len(skipped) == len(photos) {
+		return skipped, ErrNoPhotos
+	}
+
+	pdf.object(pages, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids)))
+	return skipped, pdf.finish(catalog)
+}
+
+// grid chooses the columns and rows that show typical 4:3 landscape photos
+// largest on the page.
+func grid(opts Options) (int, int) {
+	bestCols, bestRows, best := 1, opts.PerPage, 0.0
+	for cols := 1; cols <= opts.PerPage; cols++ {
+		rows := (opts.PerPage + cols - 1) / cols
+		w := (opts.Page.Width - 2*opts.Margin - float64(cols-1)*opts.Gap) / float64(cols)
+		h := (opts.Page.Height - 2*opts.Margin - float64(rows-1)*opts.Gap) / float64(rows)
+		side := min(w/4, h/3)
+		if side > best {
+			bestCols, bestRows, best = cols, rows, side
+		}
+	}
+	return bestCols, bestRows
+}
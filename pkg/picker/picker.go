@@ -0,0 +1,350 @@
+// picker.go
+//
+// Package picker creates Google Photos Picker sessions, waits for the user to finish
+// selecting media items and lists what they picked.
+package picker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"PhotoSync/pkg/clock"
+	"PhotoSync/pkg/events"
+	"PhotoSync/pkg/retry"
+	"PhotoSync/pkg/transport"
+)
+
+// DefaultBaseURL is the root of the Google Photos Picker API.
+const DefaultBaseURL = "https://photospicker.googleapis.com/v1"
+
+// PickerClient talks to the Google Photos Picker API on behalf of an authorized user.
+// Every method honours cancellation of its context.
+type PickerClient struct {
+	httpClient        transport.Doer
+	baseURL           string
+	requestTimeout    time.Duration
+	slowCallThreshold time.Duration
+	pageSize          int
+	events            *events.Bus
+	retry             retry.Policy
+	clock             clock.Clock
+	logger            *slog.Logger
+
+	pauseMu     sync.Mutex
+	pausedUntil time.Time
+}
+
+// Option configures a PickerClient.
+type Option func(*PickerClient)
+
+// WithRequestTimeout bounds each individual API call so that a stalled connection
+// cannot hang session polling or media item listing forever. Defaults to 30s.
+func WithRequestTimeout(timeout time.Duration) Option {
+	return func(c *PickerClient) {
+		c.requestTimeout = timeout
+	}
+}
+
+// WithSlowCallThreshold sets how long an API call may take before a warning is
+// logged. Defaults to 5s.
+func WithSlowCallThreshold(threshold time.Duration) Option {
+	return func(c *PickerClient) {
+		c.slowCallThreshold = threshold
+	}
+}
+
+// WithPageSize sets the number of media items requested per listing page. Defaults
+// to 100, the most the API returns.
+func WithPageSize(pageSize int) Option {
+	return func(c *PickerClient) {
+		c.pageSize = pageSize
+	}
+}
+
+// WithBaseURL points the client at a different API root, such as a test server.
+func WithBaseURL(baseURL string) Option {
+	return func(c *PickerClient) {
+		c.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
+// WithRetryPolicy controls retries of session creation after transient failures.
+// Defaults to retry.DefaultPolicy.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(c *PickerClient) {
+		c.retry = policy
+	}
+}
+
+// WithClock sets the clock used for polling and retry backoff. Defaults to the real clock.
+func WithClock(c clock.Clock) Option {
+	return func(pc *PickerClient) {
+		pc.clock = c
+	}
+}
+
+// WithLogger sends the client's warnings, such as slow calls and retries, to handler
+// instead of slog.Default().
+func WithLogger(handler slog.Handler) Option {
+	return func(pc *PickerClient) {
+		pc.logger = slog.New(handler)
+	}
+}
+
+// WithEventBus publishes SessionCreated and SelectionComplete events on bus.
+func WithEventBus(bus *events.Bus) Option {
+	return func(c *PickerClient) {
+		c.events = bus
+	}
+}
+
+// NewPickerClient returns a client that sends requests with httpClient, which must
+// already be authorized for the photospicker.mediaitems.readonly scope. Any
+// transport.Doer may be used in place of an *http.Client.
+func NewPickerClient(httpClient transport.Doer, opts ...Option) *PickerClient {
+	c := &PickerClient{
+		httpClient:        httpClient,
+		baseURL:           DefaultBaseURL,
+		requestTimeout:    30 * time.Second,
+		slowCallThreshold: 5 * time.Second,
+		pageSize:          100,
+		retry:             retry.DefaultPolicy,
+		clock:             clock.Real,
+		logger:            slog.Default(),
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.retry.Clock == nil {
+		c.retry.Clock = c.clock
+	}
+	if c.retry.Logger == nil {
+		c.retry.Logger = c.logger
+	}
+	return c
+}
+
+// SessionOption configures a Picker session as it is created.
+type SessionOption func(*PickingSession)
+
+// MaxItemCount limits the user to picking at most n items.
+func MaxItemCount(n int) SessionOption {
+	return func(s *PickingSession) {
+		if s.PickingConfig == nil {
+			s.PickingConfig = &PickingConfig{}
+		}
+		s.PickingConfig.MaxItemCount = strconv.Itoa(n)
+	}
+}
+
+// CreateSession creates a new Picker session for the user to select media items in,
+// retrying transient failures according to the client's retry policy.
+func (c *PickerClient) CreateSession(ctx context.Context, opts ...SessionOption) (PickingSession, error) {
+	var request PickingSession
+	for _, opt := range opts {
+		opt(&request)
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return PickingSession{}, fmt.Errorf("failed to encode session request: %v", err)
+	}
+
+	var session PickingSession
+	err = c.retry.Do(ctx, "Session creation", func() error {
+		var err error
+		session, err = c.createSession(ctx, body)
+		return err
+	})
+	if err != nil {
+		return PickingSession{}, err
+	}
+	c.events.Publish(events.SessionCreated{SessionID: session.ID, PickerURI: session.PickerURI})
+	return session, nil
+}
+
+func (c *PickerClient) createSession(ctx context.Context, body []byte) (PickingSession, error) {
+	resp, err := c.do(ctx, http.MethodPost, c.baseURL+"/sessions", "application/json", bytes.NewReader(body))
+	if err != nil {
+		return PickingSession{}, fmt.Errorf("failed to create session: %w", err)
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return PickingSession{}, c.apiError("failed to create session", resp)
+	}
+
+	var sessionResult PickingSession
+	if err := json.NewDecoder(resp.Body).Decode(&sessionResult); err != nil {
+		return PickingSession{}, fmt.Errorf("failed to decode session response: %v", err)
+	}
+	return sessionResult, nil
+}
+
+// GetSession fetches the current state of a session, including whether the user
+// has finished picking.
+func (c *PickerClient) GetSession(ctx context.Context, sessionID string) (PickingSession, error) {
+	sessionCheckURL := fmt.Sprintf("%s/sessions/%s", c.baseURL, url.PathEscape(sessionID))
+	resp, err := c.do(ctx, http.MethodGet, sessionCheckURL, "", nil)
+	if err != nil {
+		return PickingSession{}, fmt.Errorf("failed to check session: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return PickingSession{}, c.apiError("failed to check session", resp)
+	}
+
+	var sessionResult PickingSession
+	if err := json.NewDecoder(resp.Body).Decode(&sessionResult); err != nil {
+		return PickingSession{}, fmt.Errorf("failed to decode session response: %v", err)
+	}
+	return sessionResult, nil
+}
+
+// listPage fetches one page of the media items picked in a session. An empty
+// pageToken requests the first page.
+func (c *PickerClient) listPage(ctx context.Context, sessionID string, pageToken string) (MediaItemsList, error) {
+	mediaItemsURL, err := url.Parse(c.baseURL + "/mediaItems")
+	if err != nil {
+		return MediaItemsList{}, fmt.Errorf("failed to parse media items URL: %v", err)
+	}
+	mediaItemsQuery := mediaItemsURL.Query()
+	mediaItemsQuery.Add("sessionId", sessionID)
+	mediaItemsQuery.Add("pageSize", strconv.Itoa(c.pageSize))
+	if pageToken != "" {
+		mediaItemsQuery.Add("pageToken", pageToken)
+	}
+	mediaItemsURL.RawQuery = mediaItemsQuery.Encode()
+
+	resp, err := c.do(ctx, http.MethodGet, mediaItemsURL.String(), "", nil)
+	if err != nil {
+		return MediaItemsList{}, fmt.Errorf("failed to get media items: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return MediaItemsList{}, c.apiError("failed to fetch media items", resp)
+	}
+
+	var pageItems MediaItemsList
+	if err := json.NewDecoder(resp.Body).Decode(&pageItems); err != nil {
+		return MediaItemsList{}, fmt.Errorf("failed to decode media items response: %v", err)
+	}
+	return pageItems, nil
+}
+
+// ListMediaItems lists every media item picked in a completed session, following
+// pagination until the last page.
+func (c *PickerClient) ListMediaItems(ctx context.Context, sessionID string) (DownloadableMediaItems, error) {
+	var downloadableItems DownloadableMediaItems
+
+	pageToken := ""
+	for {
+		pageList, err := c.listPage(ctx, sessionID, pageToken)
+		if c.quotaExhausted(err) {
+			// Ask for the same page again once the quota is back
+			if err := c.waitForQuota(ctx); err != nil {
+				return DownloadableMediaItems{}, err
+			}
+			continue
+		}
+		if err != nil {
+			return DownloadableMediaItems{}, fmt.Errorf("failed to fetch media items page: %w", err)
+		}
+		downloadableItems.MediaItems = append(downloadableItems.MediaItems, pageList.MediaItems...)
+
+		// Next page token has been returned
+		pageToken = pageList.NextPageToken
+		if pageToken == "" {
+			return downloadableItems, nil
+		}
+	}
+}
+
+// quotaExhausted reports whether err is a quota refusal. When Google gave no retry
+// delay the client still pauses for its retry backoff, so that the caller's next
+// request does not go straight back to the API.
+func (c *PickerClient) quotaExhausted(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || !apiErr.QuotaExhausted() {
+		return false
+	}
+	if apiErr.RetryDelay <= 0 {
+		c.pauseFor(c.retry.Backoff)
+	}
+	return true
+}
+
+// parseDuration converts a duration string like "30s" or "1m" to time.Duration
+func parseDuration(duration string) (time.Duration, error) {
+	// Remove any quotes if present
+	duration = strings.Trim(duration, "\"")
+	return time.ParseDuration(duration)
+}
+
+// WaitForSelection polls the session at its advertised interval until the user has
+// finished picking, then lists the picked items. It fails if the session's timeout
+// passes first or ctx is cancelled.
+func (c *PickerClient) WaitForSelection(ctx context.Context, session PickingSession) (DownloadableMediaItems, error) {
+	// Parse the polling interval
+	interval, err := parseDuration(session.PollingConfig.PollInterval)
+	if err != nil {
+		return DownloadableMediaItems{}, fmt.Errorf("invalid polling interval: %v", err)
+	}
+
+	// Parse the timeout
+	timeout, err := parseDuration(session.PollingConfig.TimeoutIn)
+	if err != nil {
+		return DownloadableMediaItems{}, fmt.Errorf("invalid timeout: %v", err)
+	}
+
+	// Create a timer for the overall timeout
+	timeoutTimer := c.clock.NewTimer(timeout)
+	defer timeoutTimer.Stop()
+
+	// Create a ticker for polling at the specified interval
+	ticker := c.clock.NewTicker(interval)
+	defer ticker.Stop()
+
+	// Start polling
+	for {
+		select {
+		case <-ctx.Done():
+			return DownloadableMediaItems{}, ctx.Err()
+
+		case <-timeoutTimer.C():
+			return DownloadableMediaItems{}, fmt.Errorf("session timed out after %v", timeout)
+
+		case <-ticker.C():
+			current, err := c.GetSession(ctx, session.ID)
+			if c.quotaExhausted(err) {
+				// Skip this poll; the next one waits for the quota first
+				continue
+			}
+			if err != nil {
+				return DownloadableMediaItems{}, fmt.Errorf("polling failed: %w", err)
+			}
+
+			if current.MediaItemsSet {
+				// Fetch the selected media items
+				mediaItems, err := c.ListMediaItems(ctx, session.ID)
+				if err != nil {
+					return DownloadableMediaItems{}, fmt.Errorf("failed to fetch selected media items: %w", err)
+				}
+				c.events.Publish(events.SelectionComplete{SessionID: session.ID, ItemCount: len(mediaItems.MediaItems)})
+
+				return mediaItems, nil
+			}
+		}
+	}
+}
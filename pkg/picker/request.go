@@ -0,0 +1,182 @@
+// request.go
+//
+// HTTP plumbing shared by all Picker API calls: per-request deadlines, slow-call
+// warnings, status errors and pacing after quota errors.
+package picker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// APIError reports an unexpected HTTP status returned by the Picker API, with the
+// details Google gave in the response body, if any.
+type APIError struct {
+	Op         string
+	StatusCode int
+	// Status is the canonical error code, e.g. PERMISSION_DENIED, and Reason the
+	// machine-readable cause, e.g. ACCESS_TOKEN_SCOPE_INSUFFICIENT.
+	Status  string
+	Reason  string
+	Message string
+	// QuotaMetric names the exhausted quota, and RetryDelay is how long Google
+	// asked the caller to wait, if it said.
+	QuotaMetric string
+	RetryDelay  time.Duration
+}
+
+func (e *APIError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("%s: status %d: %s", e.Op, e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("%s: status %d", e.Op, e.StatusCode)
+}
+
+// Reasons Google gives for refusing a call.
+const (
+	// ReasonScopeInsufficient means the token was granted without the Picker scope.
+	ReasonScopeInsufficient = "ACCESS_TOKEN_SCOPE_INSUFFICIENT"
+	// ReasonServiceDisabled means the Picker API is not enabled in the Cloud project.
+	ReasonServiceDisabled = "SERVICE_DISABLED"
+)
+
+// googleError is the JSON error body of Google APIs. Details hold ErrorInfo
+// (reason and metadata such as quota_metric) and RetryInfo (retryDelay) messages.
+type googleError struct {
+	Error struct {
+		Message string `json:"message"`
+		Status  string `json:"status"`
+		Details []struct {
+			Type       string            `json:"@type"`
+			Reason     string            `json:"reason"`
+			Metadata   map[string]string `json:"metadata"`
+			RetryDelay string            `json:"retryDelay"`
+		} `json:"details"`
+	} `json:"error"`
+}
+
+// apiError builds an APIError from a failed response, reading Google's error
+// details from its body. Quota errors pause the client's further calls until the
+// quota is expected to be available again.
+func (c *PickerClient) apiError(op string, resp *http.Response) *APIError {
+	apiErr := &APIError{Op: op, StatusCode: resp.StatusCode}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+		apiErr.RetryDelay = time.Duration(seconds) * time.Second
+	}
+	var body googleError
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil {
+		apiErr.Status = body.Error.Status
+		apiErr.Message = body.Error.Message
+		for _, detail := range body.Error.Details {
+			if detail.Reason != "" && apiErr.Reason == "" {
+				apiErr.Reason = detail.Reason
+			}
+			if metric := detail.Metadata["quota_metric"]; metric != "" {
+				apiErr.QuotaMetric = metric
+			}
+			if delay, err := time.ParseDuration(detail.RetryDelay); err == nil {
+				apiErr.RetryDelay = delay
+			}
+		}
+	}
+
+	if apiErr.QuotaExhausted() && apiErr.RetryDelay > 0 {
+		c.pauseFor(apiErr.RetryDelay)
+		c.logger.Warn("Picker API quota exhausted, pausing calls", "metric", apiErr.QuotaMetric, "for", apiErr.RetryDelay)
+	}
+	return apiErr
+}
+
+// QuotaExhausted reports whether the call was refused for exceeding a rate limit
+// or quota.
+func (e *APIError) QuotaExhausted() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.Status == "RESOURCE_EXHAUSTED"
+}
+
+// RetryAfter returns how long Google asked the caller to wait before retrying, or
+// zero if it did not say.
+func (e *APIError) RetryAfter() time.Duration {
+	return e.RetryDelay
+}
+
+// pauseFor holds back the client's calls for d.
+func (c *PickerClient) pauseFor(d time.Duration) {
+	c.pauseMu.Lock()
+	defer c.pauseMu.Unlock()
+	if until := c.clock.Now().Add(d); until.After(c.pausedUntil) {
+		c.pausedUntil = until
+	}
+}
+
+// waitForQuota blocks until any pause after a quota error has passed.
+func (c *PickerClient) waitForQuota(ctx context.Context) error {
+	c.pauseMu.Lock()
+	wait := c.pausedUntil.Sub(c.clock.Now())
+	c.pauseMu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	timer := c.clock.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C():
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// HTTPStatus returns the status code, letting retry policies classify the error.
+func (e *APIError) HTTPStatus() int {
+	return e.StatusCode
+}
+
+// cancelOnCloseBody releases a request's context once its response body is closed.
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// do sends a Picker API request bounded by the client's request timeout, logging a
+// warning when the server takes longer than the slow-call threshold to respond.
+func (c *PickerClient) do(ctx context.Context, method string, rawURL string, contentType string, body io.Reader) (*http.Response, error) {
+	if err := c.waitForQuota(ctx); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.requestTimeout)
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	start := c.clock.Now()
+	resp, err := c.httpClient.Do(req)
+	elapsed := c.clock.Now().Sub(start)
+	if elapsed > c.slowCallThreshold {
+		c.logger.Warn("Slow call", "method", method, "path", req.URL.Path, "elapsed", elapsed.Round(time.Millisecond))
+	}
+	if err != nil {
+		cancel()
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("%s %s timed out after %v", method, req.URL.Path, c.requestTimeout)
+		}
+		return nil, err
+	}
+	resp.Body = cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
@@ -0,0 +1,63 @@
+// types.go
+//
+// JSON types of the Google Photos Picker API.
+package picker
+
+type PollingConfig struct {
+	PollInterval string `json:"pollInterval,omitempty"`
+	TimeoutIn    string `json:"timeoutIn,omitempty"`
+}
+
+type PickingSession struct {
+	ID            string         `json:"id,omitempty"`
+	MediaItemsSet bool           `json:"mediaItemsSet,omitempty"`
+	PickerURI     string         `json:"pickerUri,omitempty"`
+	PollingConfig PollingConfig  `json:"pollingConfig"`
+	PickingConfig *PickingConfig `json:"pickingConfig,omitempty"`
+}
+
+// PickingConfig limits what the user can pick in a session.
+type PickingConfig struct {
+	// MaxItemCount is the most items the user can pick, as a decimal string.
+	MaxItemCount string `json:"maxItemCount,omitempty"`
+}
+
+type MediaFile struct {
+	BaseUrl           string            `json:"baseUrl"`
+	MimeType          string            `json:"mimeType"`
+	Filename          string            `json:"filename"`
+	MediaFileMetadata MediaFileMetadata `json:"mediaFileMetadata"`
+}
+
+// MediaFileMetadata describes the original file. Fields Google could not determine
+// are left zero.
+type MediaFileMetadata struct {
+	Width       int    `json:"width"`
+	Height      int    `json:"height"`
+	CameraMake  string `json:"cameraMake"`
+	CameraModel string `json:"cameraModel"`
+}
+
+type MediaType string
+
+const (
+	MediaTypePhoto           MediaType = "PHOTO"
+	MediaTypeVideo           MediaType = "VIDEO"
+	MediaTypeTypeUnspecified MediaType = "TYPE_UNSPECIFIED"
+)
+
+type PickedMediaItem struct {
+	Id         string    `json:"id"`
+	CreateTime string    `json:"createTime"`
+	Type       MediaType `json:"type"`
+	MediaFile  MediaFile `json:"mediaFile"`
+}
+
+type MediaItemsList struct {
+	MediaItems    []PickedMediaItem `json:"mediaItems"`
+	NextPageToken string            `json:"nextPageToken"`
+}
+
+type DownloadableMediaItems struct {
+	MediaItems []PickedMediaItem
+}
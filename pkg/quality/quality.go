@@ -0,0 +1,163 @@
+// quality.go
+//
+// Package quality measures how sharp and how well exposed a photo is, and gives it a
+// perceptual hash for spotting near-duplicates, all from a small greyscale copy so
+// that full-size photos can be analysed on a frame host's limited memory.
+package quality
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+)
+
+// analysisSize is the longest side of the greyscale copy photos are measured on.
+// Measurements are comparable between photos because they all use this scale.
+const analysisSize = 512
+
+// Analysis describes a photo.
+type Analysis struct {
+	// Sharpness is the variance of the Laplacian: in-focus photos have strong edges
+	// and score high, blurry ones low.
+	Sharpness float64
+	// Brightness is the mean luminance, from 0 (black) to 1 (white).
+	Brightness float64
+	// Clipped is the fraction of pixels that are pure black or pure white.
+	Clipped float64
+	// Hash is a difference hash; near-identical photos differ in few bits.
+	Hash uint64
+}
+
+// Score rates a photo for picking the best of several similar ones: sharp photos
+// score high, and lose points for lost shadows and highlights.
+func (a Analysis) Score() float64 {
+	return a.Sharpness * (1 - a.Clipped)
+}
+
+// Distance returns how many bits of the two hashes differ, from 0 for the same
+// picture to 64.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Analyze decodes the photo at path and measures it.
+func Analyze(path string) (Analysis, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return Analysis{}, err
+	}
+	defer f.Close()
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return Analysis{}, err
+	}
+	return analyzeImage(img), nil
+}
+
+// grey is a greyscale image with values from 0 to 1.
+type grey struct {
+	w, h int
+	pix  []float64
+}
+
+func (g *grey) at(x, y int) float64 {
+	return g.pix[y*g.w+x]
+}
+
+func analyzeImage(img image.Image) Analysis {
+	g := shrink(img, analysisSize)
+	var a Analysis
+
+	var sum, clipped float64
+	for _, v := range g.pix {
+		sum += v
+		if v < 0.02 || v > 0.98 {
+			clipped++
+		}
+	}
+	a.Brightness = sum / float64(len(g.pix))
+	a.Clipped = clipped / float64(len(g.pix))
+
+	// Variance of the 4-neighbour Laplacian over the interior
+	var n, mean, m2 float64
+	for y := 1; y < g.h-1; y++ {
+		for x := 1; x < g.w-1; x++ {
+			lap := g.at(x-1, y) + g.at(x+1, y) + g.at(x, y-1) + g.at(x, y+1) - 4*g.at(x, y)
+			n++
+			delta := lap - mean
+			mean += delta / n
+			m2 += delta * (lap - mean)
+		}
+	}
+	if n > 1 {
+		// Scaled up so typical values are easy to read and set thresholds on
+		a.Sharpness = m2 / (n - 1) * 1e4
+	}
+
+	// Difference hash: compare each cell of a 9x8 copy with its right neighbour
+	tiny := shrinkGrey(g, 9, 8)
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			a.Hash <<= 1
+			if tiny.at(x, y) < tiny.at(x+1, y) {
+				a.Hash |= 1
+			}
+		}
+	}
+	return a
+}
+
+// shrink converts img to greyscale, averaging blocks of pixels so that its longest
+// side is at most size.
+func shrink(img image.Image, size int) *grey {
+	b := img.Bounds()
+	step := max(1, (max(b.Dx(), b.Dy())+size-1)/size)
+	w, h := max(1, b.Dx()/step), max(1, b.Dy()/step)
+	g := &grey{w: w, h: h, pix: make([]float64, w*h)}
+
+	luma := func(x, y int) float64 {
+		r, gr, bl, _ := img.At(x, y).RGBA()
+		return (0.299*float64(r) + 0.587*float64(gr) + 0.114*float64(bl)) / 0xffff
+	}
+	// JPEGs decode to YCbCr, whose Y channel is the luminance already
+	if ycc, ok := img.(*image.YCbCr); ok {
+		luma = func(x, y int) float64 {
+			return float64(ycc.Y[ycc.YOffset(x, y)]) / 0xff
+		}
+	}
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var sum float64
+			for dy := 0; dy < step; dy++ {
+				for dx := 0; dx < step; dx++ {
+					sum += luma(b.Min.X+x*step+dx, b.Min.Y+y*step+dy)
+				}
+			}
+			g.pix[y*w+x] = sum / float64(step*step)
+		}
+	}
+	return g
+}
+
+// shrinkGrey averages g down to exactly w x h.
+func shrinkGrey(g *grey, w, h int) *grey {
+	out := &grey{w: w, h: h, pix: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			x0, x1 := x*g.w/w, max((x+1)*g.w/w, x*g.w/w+1)
+			y0, y1 := y*g.h/h, max((y+1)*g.h/h, y*g.h/h+1)
+			var sum float64
+			for sy := y0; sy < min(y1, g.h); sy++ {
+				for sx := x0; sx < min(x1, g.w); sx++ {
+					sum += g.at(sx, sy)
+				}
+			}
+			out.pix[y*w+x] = sum / float64((min(y1, g.h)-y0)*(min(x1, g.w)-x0))
+		}
+	}
+	return out
+}
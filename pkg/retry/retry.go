@@ -0,0 +1,97 @@
+// retry.go
+//
+// Package retry provides bounded exponential-backoff retries for one-shot
+// control-plane calls such as Picker session creation and OAuth token exchange.
+package retry
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"PhotoSync/pkg/clock"
+)
+
+// Policy controls how many times a call is attempted and how long to wait between attempts.
+type Policy struct {
+	// Attempts is the maximum number of calls, including the first.
+	Attempts int
+	// Backoff is the wait before the first retry; it doubles after each attempt.
+	Backoff time.Duration
+	// Clock times the backoff; nil means the real clock.
+	Clock clock.Clock
+	// Logger reports each retry; nil means slog.Default().
+	Logger *slog.Logger
+}
+
+// DefaultPolicy is used for session creation and token exchange.
+var DefaultPolicy = Policy{Attempts: 4, Backoff: 2 * time.Second}
+
+// StatusError is implemented by errors that carry the HTTP status of a failed call.
+type StatusError interface {
+	error
+	HTTPStatus() int
+}
+
+// RetryAfterError is implemented by errors that say how long to wait before trying
+// again, e.g. from a Retry-After header or a quota error's retry delay.
+type RetryAfterError interface {
+	error
+	RetryAfter() time.Duration
+}
+
+// IsTransient reports whether err is worth retrying: network failures, timeouts,
+// rate limiting and server errors are; other client errors are not.
+func IsTransient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var statusErr StatusError
+	if errors.As(err, &statusErr) {
+		return transientStatus(statusErr.HTTPStatus())
+	}
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) && retrieveErr.Response != nil {
+		return transientStatus(retrieveErr.Response.StatusCode)
+	}
+	return true
+}
+
+func transientStatus(code int) bool {
+	return code == http.StatusTooManyRequests || code >= 500
+}
+
+// Do runs call, retrying transient failures with exponential backoff. If the error
+// asks for a longer wait than the backoff, that wait is used instead. Cancelling
+// ctx ends the wait, and Do then returns ctx's error.
+func (p Policy) Do(ctx context.Context, op string, call func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := call()
+		if err == nil || !IsTransient(err) || attempt >= p.Attempts {
+			return err
+		}
+		wait := backoff
+		var retryAfter RetryAfterError
+		if errors.As(err, &retryAfter) && retryAfter.RetryAfter() > wait {
+			wait = retryAfter.RetryAfter()
+		}
+		logger := p.Logger
+		if logger == nil {
+			logger = slog.Default()
+		}
+		logger.Warn(op+" failed, retrying", "attempt", attempt, "of", p.Attempts, "backoff", wait, "err", err)
+		timer := clock.OrReal(p.Clock).NewTimer(wait)
+		select {
+		case <-timer.C():
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+}
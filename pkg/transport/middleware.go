@@ -0,0 +1,98 @@
+// middleware.go
+//
+// Middleware shared by every outbound client, and an adapter that lets a Doer chain
+// serve as the base transport of clients built elsewhere, such as OAuth2's.
+package transport
+
+import (
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// roundTripper adapts a Doer to http.RoundTripper.
+type roundTripper struct {
+	doer Doer
+}
+
+func (t roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.doer.Do(req)
+}
+
+// RoundTripper returns an http.RoundTripper that sends requests through doer. The
+// doer must not follow redirects or otherwise behave like an http.Client, only
+// like a transport.
+func RoundTripper(doer Doer) http.RoundTripper {
+	return roundTripper{doer}
+}
+
+// Transport adapts an http.RoundTripper to the Doer interface, to sit at the
+// bottom of a middleware chain.
+func Transport(rt http.RoundTripper) Doer {
+	return DoerFunc(rt.RoundTrip)
+}
+
+// UserAgent sets the User-Agent header of every request to userAgent.
+func UserAgent(userAgent string) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			// Requests must not be modified in place
+			req = req.Clone(req.Context())
+			req.Header.Set("User-Agent", userAgent)
+			return next.Do(req)
+		})
+	}
+}
+
+// redactedHeaders carry credentials and are never logged.
+var redactedHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"Set-Cookie":    true,
+}
+
+// maxLoggedPath is the longest URL path Log writes out. Download paths are long
+// and, while their links are valid, grant access to the photo.
+const maxLoggedPath = 48
+
+// Log logs every request and its response: the method, URL, status, latency and
+// headers, with credentials redacted.
+func Log(logger *slog.Logger) Middleware {
+	return func(next Doer) Doer {
+		return DoerFunc(func(req *http.Request) (*http.Response, error) {
+			path := req.URL.Path
+			if len(path) > maxLoggedPath {
+				path = path[:maxLoggedPath] + "..."
+			}
+			url := req.URL.Scheme + "://" + req.URL.Host + path
+			logger.Info("HTTP request", "method", req.Method, "url", url, headerAttrs(req.Header))
+
+			start := time.Now()
+			resp, err := next.Do(req)
+			latency := time.Since(start).Round(time.Millisecond)
+			if err != nil {
+				logger.Info("HTTP error", "method", req.Method, "url", url, "latency", latency, "err", err)
+				return nil, err
+			}
+			logger.Info("HTTP response", "method", req.Method, "url", url, "status", resp.StatusCode,
+				"latency", latency, headerAttrs(resp.Header))
+			return resp, nil
+		})
+	}
+}
+
+// headerAttrs groups headers into a log attribute, redacting credentials.
+func headerAttrs(header http.Header) slog.Attr {
+	var attrs []any
+	for name, values := range header {
+		value := values[0]
+		if redactedHeaders[name] {
+			value = "REDACTED"
+		} else if len(values) > 1 {
+			attrs = append(attrs, slog.Any(name, values))
+			continue
+		}
+		attrs = append(attrs, slog.String(name, value))
+	}
+	return slog.Group("headers", attrs...)
+}
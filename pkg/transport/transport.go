@@ -0,0 +1,32 @@
+// transport.go
+//
+// Package transport defines the minimal HTTP interface used by the picker and
+// download packages, so callers can inject fakes, recorded fixtures or middleware.
+package transport
+
+import "net/http"
+
+// Doer sends an HTTP request and returns its response. *http.Client satisfies it.
+type Doer interface {
+	Do(req *http.Request) (*http.Response, error)
+}
+
+// DoerFunc adapts an ordinary function to the Doer interface.
+type DoerFunc func(req *http.Request) (*http.Response, error)
+
+// Do calls f(req).
+func (f DoerFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Middleware wraps a Doer with extra behaviour such as logging or retries.
+type Middleware func(next Doer) Doer
+
+// Chain wraps doer with the given middleware. The first middleware is outermost,
+// so it sees each request first and each response last.
+func Chain(doer Doer, middleware ...Middleware) Doer {
+	for i := len(middleware) - 1; i >= 0; i-- {
+		doer = middleware[i](doer)
+	}
+	return doer
+}
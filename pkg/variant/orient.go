@@ -0,0 +1,100 @@
+// orient.go
+//
+// Reading a JPEG's EXIF orientation and turning the image upright to match.
+package variant
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+)
+
+// orientation returns the EXIF orientation of a JPEG, from 1 (upright) to 8, or 1
+// if it has none.
+func orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		marker := data[i+1]
+		if data[i] != 0xFF || marker == 0xDA || marker == 0xD9 {
+			break
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) {
+			break
+		}
+		if marker == 0xE1 && bytes.HasPrefix(data[i+4:end], []byte("Exif\x00\x00")) {
+			return tiffOrientation(data[i+10 : end])
+		}
+		i = end
+	}
+	return 1
+}
+
+// tiffOrientation finds the orientation tag in the first IFD of EXIF's TIFF data.
+func tiffOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder = binary.BigEndian
+	if string(tiff[:2]) == "II" {
+		order = binary.LittleEndian
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 0 || ifd+2 > len(tiff) {
+		return 1
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + 12*i
+		if entry+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			if v := int(order.Uint16(tiff[entry+8:])); v >= 1 && v <= 8 {
+				return v
+			}
+			break
+		}
+	}
+	return 1
+}
+
+// orient returns img turned upright for the given EXIF orientation.
+func orient(img image.Image, orientation int) image.Image {
+	if orientation <= 1 || orientation > 8 {
+		return img
+	}
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	// Orientations 5 to 8 swap width and height
+	if orientation >= 5 {
+		w, h = h, w
+	}
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			// The source pixel shown at (x, y) once the photo is upright
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = b.Dx()-1-x, y
+			case 3:
+				sx, sy = b.Dx()-1-x, b.Dy()-1-y
+			case 4:
+				sx, sy = x, b.Dy()-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, b.Dy()-1-x
+			case 7:
+				sx, sy = b.Dx()-1-y, b.Dy()-1-x
+			case 8:
+				sx, sy = b.Dx()-1-y, x
+			}
+			out.Set(x, y, img.At(b.Min.X+sx, b.Min.Y+sy))
+		}
+	}
+	return out
+}
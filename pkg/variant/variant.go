@@ -0,0 +1,163 @@
+// variant.go
+//
+// Package variant makes copies of downloaded photos sized and encoded for a
+// particular frame, so that one download can feed frames with very different
+// screens, from a 4K TV to a small e-ink panel.
+package variant
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+
+	// Register the other formats Make can read
+	_ "image/gif"
+)
+
+// Formats Make can write.
+const (
+	JPEG = "jpeg"
+	PNG  = "png"
+)
+
+// ErrUnsupported is returned by Make for files it cannot decode, such as videos.
+var ErrUnsupported = errors.New("unsupported format")
+
+// Spec describes the variant a frame wants. Zero Width and Height keep the photo's
+// size, and an empty Format keeps its format where it can be written.
+type Spec struct {
+	Width, Height int
+	// Crop fills Width x Height exactly, cutting off the edges, instead of fitting
+	// inside it.
+	Crop   bool
+	Format string
+}
+
+// Filename returns the name of the variant of the file called name, whose
+// extension follows the variant's format.
+func (s Spec) Filename(name string) string {
+	ext := ""
+	switch s.Format {
+	case JPEG:
+		ext = ".jpg"
+	case PNG:
+		ext = ".png"
+	default:
+		return name
+	}
+	if current := strings.ToLower(filepath.Ext(name)); current == ext || ext == ".jpg" && current == ".jpeg" {
+		return name
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name)) + ext
+}
+
+// Make writes the variant of the photo at src to dst. Photos are turned upright
+// following their EXIF orientation first, since the variant carries no EXIF, and
+// are never enlarged.
+func Make(src, dst string, spec Spec) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if errors.Is(err, image.ErrFormat) {
+		return ErrUnsupported
+	}
+	if err != nil {
+		return err
+	}
+	if format == "jpeg" {
+		img = orient(img, orientation(data))
+	}
+	if spec.Width > 0 && spec.Height > 0 {
+		img = scale(img, spec.Width, spec.Height, spec.Crop)
+	}
+
+	out := spec.Format
+	if out == "" {
+		out = JPEG
+		if format == "png" || format == "gif" {
+			out = PNG
+		}
+	}
+	var buf bytes.Buffer
+	if out == PNG {
+		err = png.Encode(&buf, img)
+	} else {
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+	}
+	if err != nil {
+		return err
+	}
+	// Write beside dst and rename, so the frame never shows a half-written variant
+	tmp := dst + ".part"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// scale resizes img to fit inside, or with crop to cover, width x height. Each
+// output pixel averages the block of source pixels it covers, which keeps fine
+// detail from turning into noise when large photos are shrunk a long way.
+func scale(img image.Image, width, height int, crop bool) image.Image {
+	b := img.Bounds()
+	sx, sy := float64(width)/float64(b.Dx()), float64(height)/float64(b.Dy())
+	factor := min(sx, sy)
+	if crop {
+		factor = max(sx, sy)
+	}
+	factor = min(factor, 1)
+
+	src := b
+	w, h := max(1, int(float64(b.Dx())*factor+0.5)), max(1, int(float64(b.Dy())*factor+0.5))
+	if crop {
+		// Keep the centre of the photo, as much of it as fits the frame's shape
+		cw, ch := min(b.Dx(), int(float64(width)/factor+0.5)), min(b.Dy(), int(float64(height)/factor+0.5))
+		origin := image.Pt(b.Min.X+(b.Dx()-cw)/2, b.Min.Y+(b.Dy()-ch)/2)
+		src = image.Rectangle{Min: origin, Max: origin.Add(image.Pt(cw, ch))}
+		w, h = min(width, max(1, int(float64(cw)*factor+0.5))), min(height, max(1, int(float64(ch)*factor+0.5)))
+	}
+	if w == src.Dx() && h == src.Dy() {
+		if src == b {
+			return img
+		}
+		out := image.NewNRGBA(image.Rect(0, 0, w, h))
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				out.Set(x, y, img.At(src.Min.X+x, src.Min.Y+y))
+			}
+		}
+		return out
+	}
+
+	out := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		y0 := src.Min.Y + y*src.Dy()/h
+		y1 := max(y0+1, src.Min.Y+(y+1)*src.Dy()/h)
+		for x := 0; x < w; x++ {
+			x0 := src.Min.X + x*src.Dx()/w
+			x1 := max(x0+1, src.Min.X+(x+1)*src.Dx()/w)
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a = r+uint64(pr), g+uint64(pg), bl+uint64(pb), a+uint64(pa)
+					n++
+				}
+			}
+			out.Set(x, y, color.RGBA64{uint16(r / n), uint16(g / n), uint16(bl / n), uint16(a / n)})
+		}
+	}
+	return out
+}